@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	engine, err := xref.New() // default: Go + TS + Python adapters
+	engine, err := xref.New() // default: every registered adapter
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,516 @@
+package xref
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/cpp"
+)
+
+// cAdapter indexes the C family. C and C++ share one symbol namespace ("c") because headers are
+// routinely shared between them; .c files use the C grammar and everything else the C++ grammar.
+type cAdapter struct {
+	c, cpp       symbolQueries
+	includePaths []string
+	graph        storeCache[*cIncludeGraph]
+}
+
+// cInclude is a single #include directive.
+type cInclude struct {
+	path   string // header path without delimiters
+	system bool   // <...> rather than "..."
+	rng    Range
+}
+
+// NewCAdapter creates a C/C++ language adapter with pre-compiled tree-sitter queries.
+// includePaths are searched (in order) for #include targets, after the including file's own
+// directory for quoted includes; see IncludePathsFromCompileCommands for deriving them from a build.
+func NewCAdapter(includePaths ...string) (LanguageAdapter, error) {
+	return (&cAdapter{includePaths: includePaths}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its C and C++ queries read from src.
+func (a *cAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	cq, cErr := loadSymbolQueries(src, "c", c.GetLanguage())
+	cppq, cppErr := loadSymbolQueries(src, "cpp", cpp.GetLanguage())
+	if err := errors.Join(cErr, cppErr); err != nil {
+		return nil, err
+	}
+	return &cAdapter{c: cq, cpp: cppq, includePaths: a.includePaths}, nil
+}
+
+func (a *cAdapter) querySets() []*querySet { return []*querySet{a.c.queries, a.cpp.queries} }
+
+func (a *cAdapter) Lang() string { return "c" }
+func (a *cAdapter) CanHandle(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".c", ".h", ".cc", ".cpp", ".cxx", ".c++", ".hh", ".hpp", ".hxx", ".inl", ".ipp":
+		return true
+	}
+	return false
+}
+
+// isC reports whether a file should be parsed with the plain C grammar.
+// Go files only reach the C adapter through their cgo preamble, which is C.
+func (a *cAdapter) isC(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".c" || ext == ".go"
+}
+
+func (a *cAdapter) queries(path string) symbolQueries {
+	if a.isC(path) {
+		return a.c
+	}
+	return a.cpp
+}
+
+// Language returns the tree-sitter grammar for a file, for incremental reparsing of open documents.
+func (a *cAdapter) Language(path string) *sitter.Language {
+	if a.isC(path) {
+		return c.GetLanguage()
+	}
+	return cpp.GetLanguage()
+}
+
+func (a *cAdapter) Parse(path string, src []byte) (*sitter.Tree, error) {
+	name, lang := "cpp", cpp.GetLanguage()
+	if a.isC(path) {
+		name, lang = "c", c.GetLanguage()
+	}
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse(name, lang, src)
+}
+
+// Extract analyzes a C or C++ source file's syntax tree and extracts all symbols.
+// Members are keyed by their enclosing namespaces and classes (including out-of-line Foo::bar
+// definitions), and prototypes and extern variables are recorded with Decl set so they can be
+// told apart from the definitions they announce.
+func (a *cAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "c", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	q := a.queries(path)
+	qp := q.queries.run(src, root) // One walk of the tree for all queries
+
+	// Extract #include directives
+	for _, inc := range a.readIncludes(q, src, qp) {
+		spelled := `"` + inc.path + `"`
+		if inc.system {
+			spelled = "<" + inc.path + ">"
+		}
+		fi.Imports[spelled] = inc.path
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: inc.path, KindHint: "import", Rng: inc.rng})
+	}
+
+	qp.each(q.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		decl := nodeByName(capts, q.qDefs, "rng")
+		var nameNode *sitter.Node
+		var kind string
+		var isDecl bool
+		switch {
+		case nodeByName(capts, q.qDefs, "fdecl") != nil:
+			// Function body: always a definition
+			n, isFunc := unwrapCDeclarator(nodeByName(capts, q.qDefs, "fdecl"))
+			if !isFunc {
+				return
+			}
+			nameNode, kind = n, "func"
+		case nodeByName(capts, q.qDefs, "decl") != nil:
+			n, isFunc := unwrapCDeclarator(nodeByName(capts, q.qDefs, "decl"))
+			switch {
+			case isFunc:
+				// Prototype (or in-class constructor/destructor declaration)
+				nameNode, kind, isDecl = n, "func", true
+			case isCFileScope(decl):
+				nameNode, kind = n, "var"
+				isDecl = hasExternStorage(src, decl) && nodeByName(capts, q.qDefs, "decl").Type() != "init_declarator"
+			default:
+				return // Local variables are not indexed
+			}
+		case nodeByName(capts, q.qDefs, "field") != nil:
+			n, isFunc := unwrapCDeclarator(nodeByName(capts, q.qDefs, "field"))
+			if isFunc {
+				nameNode, kind, isDecl = n, "func", true // Member function declared in the class body
+			} else {
+				nameNode, kind = n, "field"
+			}
+		case nodeByName(capts, q.qDefs, "tdecl") != nil:
+			nameNode, _ = unwrapCDeclarator(nodeByName(capts, q.qDefs, "tdecl"))
+			kind = "type"
+		case nodeByName(capts, q.qDefs, "sname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "sname"), "struct"
+		case nodeByName(capts, q.qDefs, "uname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "uname"), "union"
+		case nodeByName(capts, q.qDefs, "ename") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "ename"), "enum"
+		case nodeByName(capts, q.qDefs, "kname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "kname"), "const"
+		case nodeByName(capts, q.qDefs, "mname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "mname"), "macro"
+		case nodeByName(capts, q.qDefs, "clname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "clname"), "class"
+		case nodeByName(capts, q.qDefs, "nsname") != nil:
+			nameNode, kind = nodeByName(capts, q.qDefs, "nsname"), "namespace"
+		default:
+			nameNode, kind = nodeByName(capts, q.qDefs, "tname"), "type"
+		}
+		if nameNode == nil {
+			return
+		}
+
+		// Qualified declarators (Foo::bar) contribute their scope to the container
+		scope, name := splitCQualified(src, nameNode)
+		if name == "" {
+			return
+		}
+		container := cContainer(src, decl)
+		if scope != "" {
+			container = strings.Trim(container+"."+scope, ".")
+		}
+		rng := rangeByName(src, capts, q.qDefs, "rng")
+		sid := symbolID("c", path, container, name)
+		if prev, ok := fi.Defs[sid]; ok && !prev.Decl && isDecl {
+			return // A prototype never shadows the definition from the same file
+		}
+		fi.Defs[sid] = DefLocation{Lang: "c", File: path, Rng: rng, Name: name, Kind: kind, Decl: isDecl}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(q.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, q.qRefs, "id")
+		rng := rangeByName(src, capts, q.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
+// Candidates are ranked so that definitions come before declarations: same-file definitions,
+// definitions in the file's (transitive) includes, definitions of anything those includes declare,
+// then the declarations themselves, and finally any other symbol with the same name.
+func (a *cAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	g := a.graph.get(idx, func() *cIncludeGraph { return a.newIncludeGraph(idx) })
+	visible := g.closure(path, idx)
+
+	named := idx.Lookup("c", occ.Name)
+	declaredVisible := false
+	for _, sid := range named {
+		if d, ok := idx.Definition(sid); ok && d.Decl {
+			if _, ok := visible[d.File]; ok {
+				declaredVisible = true
+				break
+			}
+		}
+	}
+
+	rank := func(d DefLocation) int {
+		_, vis := visible[d.File]
+		switch {
+		case !d.Decl && d.File == path:
+			return 0
+		case !d.Decl && vis:
+			return 1
+		case !d.Decl && declaredVisible:
+			return 2
+		case d.Decl && vis:
+			return 3
+		case !d.Decl:
+			return 4
+		default:
+			return 5
+		}
+	}
+	out := make([]string, 0, len(named))
+	defs := map[string]DefLocation{}
+	for _, sid := range named {
+		if d, ok := idx.Definition(sid); ok {
+			out = append(out, sid)
+			defs[sid] = d
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := rank(defs[out[i]]), rank(defs[out[j]])
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// readIncludes returns the #include directives of a parsed file.
+func (a *cAdapter) readIncludes(q symbolQueries, src []byte, qp *queryPass) []cInclude {
+	var out []cInclude
+	qp.each(q.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		raw := getByName(src, capts, q.qImport, "path")
+		if raw == "" {
+			return
+		}
+		out = append(out, cInclude{
+			path:   strings.Trim(raw, `"<>`),
+			system: strings.HasPrefix(raw, "<"),
+			rng:    rangeByName(src, capts, q.qImport, "rng"),
+		})
+	})
+	return out
+}
+
+// cIncludeGraph is what resolving #include directives needs of an index: its C-family files by
+// absolute path and by base name, and the files each one includes, resolved as first needed.
+// It is derived once per update of the index, rather than on every lookup.
+type cIncludeGraph struct {
+	includePaths []string
+	cwd          string              // to make relative paths absolute without a syscall each
+	byAbs        map[string]string   // absolute path -> indexed path
+	byBase       map[string][]string // base name -> indexed paths, sorted
+
+	mu    sync.Mutex
+	edges map[string][]string // file -> indexed files its #include directives resolve to
+}
+
+func (a *cAdapter) newIncludeGraph(idx Store) *cIncludeGraph {
+	g := &cIncludeGraph{includePaths: a.includePaths, byAbs: map[string]string{}, byBase: map[string][]string{}, edges: map[string][]string{}}
+	g.cwd, _ = os.Getwd()
+	for _, f := range idx.Files() { // Sorted, so byBase is too
+		if a.CanHandle(f) {
+			g.byAbs[g.abs(f)] = f
+			g.byBase[filepath.Base(f)] = append(g.byBase[filepath.Base(f)], f)
+		}
+	}
+	return g
+}
+
+// abs returns a cleaned absolute form of p for comparing paths spelled differently.
+func (g *cIncludeGraph) abs(p string) string {
+	if !filepath.IsAbs(p) && g.cwd != "" {
+		p = filepath.Join(g.cwd, p)
+	}
+	return filepath.Clean(p)
+}
+
+// closure returns the set of indexed files reachable from path through #include, including
+// path itself. Nested includes are followed through the #include directives each file recorded
+// in the index (its Imports, keyed by the spelled "x.h" or <x.h>).
+func (g *cIncludeGraph) closure(path string, idx Store) map[string]struct{} {
+	seen := map[string]struct{}{path: {}}
+	queue := []string{path}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, target := range g.includes(cur, idx) {
+			if _, ok := seen[target]; !ok {
+				seen[target] = struct{}{}
+				queue = append(queue, target)
+			}
+		}
+	}
+	return seen
+}
+
+// includes returns the indexed files the #include directives of file resolve to.
+func (g *cIncludeGraph) includes(file string, idx Store) []string {
+	g.mu.Lock()
+	out, ok := g.edges[file]
+	g.mu.Unlock()
+	if ok {
+		return out
+	}
+	for spelled, inc := range idx.Imports(file) {
+		if spelled == "" || (spelled[0] != '"' && spelled[0] != '<') {
+			continue // A Go import of a cgo file, not an #include
+		}
+		if target := g.resolve(file, cInclude{path: inc, system: spelled[0] == '<'}); target != "" {
+			out = append(out, target)
+		}
+	}
+	g.mu.Lock()
+	g.edges[file] = out
+	g.mu.Unlock()
+	return out
+}
+
+// resolve maps an #include directive to an indexed file, or "" if none matches. Quoted includes
+// try the including file's directory first; both forms then try each include path, and finally
+// any indexed file whose path ends with the included path.
+func (g *cIncludeGraph) resolve(from string, inc cInclude) string {
+	var dirs []string
+	if !inc.system {
+		dirs = append(dirs, filepath.Dir(from))
+	}
+	dirs = append(dirs, g.includePaths...)
+	for _, dir := range dirs {
+		if f, ok := g.byAbs[g.abs(filepath.Join(dir, inc.path))]; ok {
+			return f
+		}
+	}
+	suffix := "/" + filepath.ToSlash(filepath.Clean(inc.path))
+	for _, f := range g.byBase[filepath.Base(inc.path)] {
+		if strings.HasSuffix("/"+filepath.ToSlash(f), suffix) {
+			return f // The first in path order
+		}
+	}
+	return ""
+}
+
+// unwrapCDeclarator digs through pointer, reference, array and init declarators to the declared
+// name, reporting whether the declarator declares a function (as opposed to e.g. a function pointer).
+func unwrapCDeclarator(n *sitter.Node) (*sitter.Node, bool) {
+	isFunc := false
+	for n != nil {
+		switch n.Type() {
+		case "identifier", "field_identifier", "type_identifier", "qualified_identifier",
+			"destructor_name", "operator_name", "template_function":
+			return n, isFunc
+		case "function_declarator":
+			isFunc = true
+		case "parenthesized_declarator":
+			isFunc = false // (*fp)(...) declares a pointer, not a function
+		case "pointer_declarator", "reference_declarator", "init_declarator", "array_declarator", "attributed_declarator":
+		default:
+			return nil, false
+		}
+		if next := n.ChildByFieldName("declarator"); next != nil {
+			n = next
+		} else {
+			n = n.NamedChild(int(n.NamedChildCount()) - 1)
+		}
+	}
+	return nil, false
+}
+
+// splitCQualified splits a (possibly qualified) declarator name into its dotted scope and final name.
+func splitCQualified(src []byte, n *sitter.Node) (string, string) {
+	var scope []string
+	for n != nil && n.Type() == "qualified_identifier" {
+		if s := n.ChildByFieldName("scope"); s != nil {
+			scope = append(scope, s.Content(src))
+		}
+		n = n.ChildByFieldName("name")
+	}
+	if n == nil {
+		return "", ""
+	}
+	name := n.Content(src)
+	if n.Type() == "template_function" {
+		if base := n.ChildByFieldName("name"); base != nil {
+			name = base.Content(src)
+		}
+	}
+	return strings.Join(scope, "."), name
+}
+
+// cContainer returns the chain of enclosing namespaces and classes for a declaration node.
+func cContainer(src []byte, decl *sitter.Node) string {
+	if decl == nil {
+		return ""
+	}
+	var parts []string
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		switch n.Type() {
+		case "namespace_definition", "class_specifier", "struct_specifier", "union_specifier":
+			if name := n.ChildByFieldName("name"); name != nil {
+				parts = append([]string{name.Content(src)}, parts...)
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// isCFileScope reports whether a declaration sits at file or namespace scope (not in a function body).
+// Conditional compilation blocks, templates and extern "C" blocks are transparent.
+func isCFileScope(decl *sitter.Node) bool {
+	for p := decl.Parent(); p != nil; p = p.Parent() {
+		switch t := p.Type(); {
+		case t == "translation_unit", t == "declaration_list":
+			return true // file scope, or a namespace or extern "C" { ... } body
+		case t == "template_declaration", t == "linkage_specification", strings.HasPrefix(t, "preproc_"):
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// hasExternStorage reports whether a declaration carries the extern storage class.
+func hasExternStorage(src []byte, decl *sitter.Node) bool {
+	for i := 0; i < int(decl.NamedChildCount()); i++ {
+		if ch := decl.NamedChild(i); ch.Type() == "storage_class_specifier" && ch.Content(src) == "extern" {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath returns a cleaned absolute form of p for comparing paths spelled differently.
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
+
+// IncludePathsFromCompileCommands reads a compile_commands.json compilation database and returns
+// the -I, -iquote and -isystem directories it mentions, resolved against each entry's directory.
+func IncludePathsFromCompileCommands(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var entries []struct {
+		Directory string   `json:"directory"`
+		Command   string   `json:"command"`
+		Arguments []string `json:"arguments"`
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var out []string
+	seen := map[string]struct{}{}
+	add := func(base, dir string) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		dir = filepath.Clean(dir)
+		if _, ok := seen[dir]; !ok {
+			seen[dir] = struct{}{}
+			out = append(out, dir)
+		}
+	}
+	for _, e := range entries {
+		args := e.Arguments
+		if len(args) == 0 {
+			args = strings.Fields(e.Command)
+		}
+		for i := 0; i < len(args); i++ {
+			for _, flag := range []string{"-isystem", "-iquote", "-idirafter", "-I"} {
+				if !strings.HasPrefix(args[i], flag) {
+					continue
+				}
+				if dir := strings.TrimPrefix(args[i], flag); dir != "" {
+					add(e.Directory, dir)
+				} else if i+1 < len(args) {
+					i++
+					add(e.Directory, args[i])
+				}
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,79 @@
+package xref
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// cFiles is a small C project: a header declaring what a source file defines, a header found
+// through the include path, and two same-named headers that each includer sees one of.
+var cFiles = fstest.MapFS{
+	"include/api.h":   {Data: []byte("#ifndef API_H\n#define API_H\n#include <types.h>\nint make(int n);\nextern int counter;\n#endif\n")},
+	"include/types.h": {Data: []byte("typedef struct point { int x; } point_t;\n")},
+	"src/api.c":       {Data: []byte("#include \"api.h\"\nint counter = 0;\nint make(int n) { return n + counter; }\n")},
+	"src/main.c":      {Data: []byte("#include \"api.h\"\n#include \"util.h\"\nint main(void) { point_t p; return make(twice(1)); }\n")},
+	"src/util.h":      {Data: []byte("static inline int twice(int x) { return 2 * x; }\n")},
+	"tools/util.h":    {Data: []byte("static inline int twice(int x) { return x + x; }\n")},
+	"tools/tool.c":    {Data: []byte("#include \"util.h\"\nint tool(void) { return twice(2); }\n")},
+}
+
+// TestCResolveAt checks how C candidates are ranked: definitions before the prototypes and
+// extern declarations that announce them, and definitions the file's includes make visible
+// before same-named ones elsewhere. It then changes an #include and checks that visibility
+// follows.
+func TestCResolveAt(t *testing.T) {
+	c, err := NewCAdapter("include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithLanguages(), WithAdapter(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := maps.Clone(cFiles)
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      []string // candidate files, in order
+	}{
+		{"definition before prototype", "src/main.c", 3, 36, []string{"src/api.c", "include/api.h"}},
+		{"same file before extern declaration", "src/api.c", 3, 30, []string{"src/api.c", "include/api.h"}},
+		{"included header before another", "src/main.c", 3, 41, []string{"src/util.h", "tools/util.h"}},
+		{"header of the includer's directory", "tools/tool.c", 2, 25, []string{"tools/util.h", "src/util.h"}},
+		{"nested include through the include path", "src/main.c", 3, 18, []string{"include/types.h"}},
+	}
+	candidates := func(file string, line, col int) []string {
+		t.Helper()
+		_, cands, err := e.FindDefinitionAt(file, line, col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, sid := range cands {
+			d, _ := e.Index.Definition(sid)
+			files = append(files, d.File)
+		}
+		return files
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := candidates(tt.file, tt.line, tt.col); !slices.Equal(got, tt.want) {
+				t.Errorf("candidates in %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	fsys["tools/tool.c"] = &fstest.MapFile{Data: []byte("#include \"../src/util.h\"\nint tool(void) { return twice(2); }\n")}
+	if err := e.IndexFS(fsys, "tools/tool.c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := candidates("tools/tool.c", 2, 25), []string{"src/util.h", "tools/util.h"}; !slices.Equal(got, want) {
+		t.Errorf("after changing the #include: candidates in %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,355 @@
+package xref
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/csharp"
+)
+
+type csAdapter struct {
+	symbolQueries
+	globals storeCache[*csGlobalUsings]
+}
+
+// csGlobalUsings are the global using directives of every indexed C# file, which apply to all.
+type csGlobalUsings struct {
+	aliases  map[string]string   // alias -> qualified namespace or type
+	imported map[string]struct{} // namespaces and types imported by name
+}
+
+// csUsing is a single using directive.
+type csUsing struct {
+	alias  string // using Alias = Target; empty for namespace and static usings
+	target string // qualified namespace or type name
+	global bool
+	rng    Range
+}
+
+// newCsAdapter creates a C# language adapter with pre-compiled tree-sitter queries.
+func newCsAdapter() (LanguageAdapter, error) {
+	return (&csAdapter{}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (c *csAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "cs", csharp.GetLanguage())
+	if err != nil {
+		return nil, err
+	}
+	return &csAdapter{symbolQueries: sq}, nil
+}
+
+func (c *csAdapter) Lang() string { return "cs" }
+func (c *csAdapter) CanHandle(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".cs")
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (c *csAdapter) Language(_ string) *sitter.Language { return csharp.GetLanguage() }
+
+func (c *csAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	lang := csharp.GetLanguage()
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse("cs", lang, src)
+}
+
+// csDefs maps the name captures of queries/cs/defs.scm to the kinds of definition they name.
+var csDefs = []DefCapture{
+	{Name: "nsname", Kind: "namespace"},
+	{Name: "cname", Kind: "class"},
+	{Name: "sname", Kind: "struct"},
+	{Name: "rname", Kind: "record"},
+	{Name: "iname", Kind: "interface"},
+	{Name: "ename", Kind: "enum"},
+	{Name: "dname", Kind: "delegate"},
+	{Name: "kname", Kind: "const"},
+	{Name: "mname", Kind: "method"},
+	{Name: "ctor", Kind: "constructor"},
+	{Name: "pname", Kind: "property"},
+	{Name: "evname", Kind: "event"},
+	{Name: "fname", Kind: "field"},
+}
+
+// Extract analyzes a C# source file's syntax tree and extracts all symbols.
+// Symbols are keyed by their fully qualified container (namespace plus enclosing types), so the
+// parts of a partial class declared in different files share one container and resolve together.
+// Methods and constructors additionally carry their parameter types so overloads stay distinct.
+func (c *csAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "cs", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	qp := c.queries.run(src, root) // One walk of the tree for all queries
+	fileNS := csFileNamespace(src, root)
+	fi.Package = fileNS
+
+	// Record using directives; global ones are prefixed so other files can pick them up
+	for _, u := range c.readUsings(src, qp) {
+		key := u.target + ".*"
+		if u.alias != "" {
+			key = u.alias
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: u.alias, KindHint: "import", Rng: u.rng})
+		}
+		if u.global {
+			key = "global::" + key
+		}
+		fi.Imports[key] = u.target
+	}
+
+	qp.each(c.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		name, d := matchDef(src, capts, c.qDefs, csDefs)
+		kind := d.Kind
+		if name == "" {
+			return
+		}
+		decl := nodeByName(capts, c.qDefs, "rng")
+		var container string
+		if decl.Type() != "file_scoped_namespace_declaration" {
+			container = csContainer(src, decl, fileNS)
+		}
+		if kind == "namespace" {
+			// namespace A.B declares B inside A (and inside any enclosing namespace)
+			container, name = strings.Trim(container+"."+trimLastDotted(name), "."), lastDotted(name)
+		}
+		rng := rangeByName(src, capts, c.qDefs, "rng")
+		sid := symbolID("cs", path, container, name)
+		if params := nodeByName(capts, c.qDefs, "params"); params != nil {
+			// Overloads share a name, so the parameter types become part of the ID
+			sid += "(" + strings.Join(csParamTypes(src, params), ",") + ")"
+		}
+		fi.Defs[sid] = DefLocation{Lang: "cs", File: path, Rng: rng, Name: name, Kind: kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(c.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, c.qRefs, "id")
+		rng := rangeByName(src, capts, c.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
+// Using aliases win outright; otherwise candidates are ranked by C# scoping: members of the
+// enclosing types (across all partial declarations), then enclosing namespaces from the inside out,
+// then namespaces and types imported by using directives (this file's and every global using).
+// The enclosing scopes and usings are read from the index rather than by parsing the file again.
+func (c *csAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	scopes := csScopes(path, occ.Rng.Start, idx)
+	globals := c.globals.get(idx, func() *csGlobalUsings { return c.globalUsings(idx) })
+
+	name, target := occ.Name, ""
+	imported := map[string]struct{}{}
+	for key, to := range idx.Imports(path) {
+		switch {
+		case strings.HasPrefix(key, "global::"):
+			// Among the global usings
+		case strings.HasSuffix(key, ".*"):
+			imported[to] = struct{}{}
+		case key == occ.Name:
+			target = to
+		}
+	}
+	if to, ok := globals.aliases[occ.Name]; ok && target == "" {
+		target = to
+	}
+	if target != "" {
+		// Aliased: only the aliased namespace or type itself qualifies
+		var out []string
+		for _, sid := range idx.Lookup("cs", lastDotted(target)) {
+			if csQualified(sid) == target {
+				out = append(out, sid)
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	rank := func(sid string) int {
+		container := trimLastDotted(csQualified(sid))
+		for i, s := range scopes {
+			if container == s {
+				return i
+			}
+		}
+		if _, ok := imported[container]; ok {
+			return len(scopes)
+		}
+		if _, ok := globals.imported[container]; ok {
+			return len(scopes)
+		}
+		if container == "" {
+			return len(scopes) + 1 // Global namespace
+		}
+		return len(scopes) + 2
+	}
+	out := idx.Lookup("cs", name)
+	local := map[string]bool{}
+	for _, sid := range out {
+		d, _ := idx.Definition(sid)
+		local[sid] = d.File == path
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := rank(out[i]), rank(out[j])
+		if ri != rj {
+			return ri < rj
+		}
+		// Within a scope prefer this file, then a stable order
+		fi, fj := local[out[i]], local[out[j]]
+		if fi != fj {
+			return fi
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// globalUsings collects the global using directives of the indexed C# files.
+func (c *csAdapter) globalUsings(idx Store) *csGlobalUsings {
+	g := &csGlobalUsings{aliases: map[string]string{}, imported: map[string]struct{}{}}
+	for _, file := range idx.Files() {
+		if !c.CanHandle(file) {
+			continue
+		}
+		for key, to := range idx.Imports(file) {
+			if key, ok := strings.CutPrefix(key, "global::"); ok {
+				if strings.HasSuffix(key, ".*") {
+					g.imported[to] = struct{}{}
+				} else {
+					g.aliases[key] = to
+				}
+			}
+		}
+	}
+	return g
+}
+
+// csScopes returns the containers enclosing pos in file, innermost first: the qualified names of
+// the types and namespaces whose definitions contain it and of their own containers, or else of
+// the file-scoped namespace.
+func csScopes(file string, pos Pos, idx Store) []string {
+	inner := idx.Package(file)
+	for _, o := range idx.Occurrences(file) { // By position, so enclosing definitions come first
+		if o.KindHint != "def" || o.SymbolID == "" || !rangeContains(o.Rng, pos) {
+			continue
+		}
+		switch d, _ := idx.Definition(o.SymbolID); d.Kind {
+		case "namespace", "class", "struct", "record", "interface", "enum":
+			inner = csQualified(o.SymbolID)
+		}
+	}
+	var scopes []string
+	for s := inner; s != ""; s = trimLastDotted(s) {
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
+
+// csParamTypes returns the parameter types of a parameter_list node, with the ref, out and in
+// modifiers that tell overloads apart. Whitespace and global:: are dropped, and other alias
+// qualifiers (A::B) written as A.B, so symbol IDs keep "::" as their separator alone. Type
+// arguments are kept, since C# overloads can differ by them.
+func csParamTypes(src []byte, params *sitter.Node) []string {
+	clean := func(t string) string {
+		t = strings.ReplaceAll(strings.Join(strings.Fields(t), ""), "global::", "")
+		return strings.ReplaceAll(t, "::", ".")
+	}
+	var out []string
+	for i := 0; i < int(params.ChildCount()); i++ {
+		p := params.Child(i)
+		switch {
+		case p.Type() == "parameter":
+			t := p.ChildByFieldName("type")
+			if t == nil {
+				continue
+			}
+			typ := clean(t.Content(src))
+			for k := 0; k < int(p.NamedChildCount()); k++ {
+				if m := p.NamedChild(k); m.Type() == "modifier" {
+					switch mod := m.Content(src); mod {
+					case "ref", "out", "in":
+						typ = mod + " " + typ
+					}
+				}
+			}
+			out = append(out, typ)
+		case params.FieldNameForChild(i) == "type":
+			out = append(out, clean(p.Content(src))) // The array of params T[] rest
+		}
+	}
+	return out
+}
+
+// readUsings returns the using directives of a parsed C# file.
+func (c *csAdapter) readUsings(src []byte, qp *queryPass) []csUsing {
+	var out []csUsing
+	qp.each(c.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		target := getByName(src, capts, c.qImport, "path")
+		if target == "" {
+			return
+		}
+		out = append(out, csUsing{
+			alias:  getByName(src, capts, c.qImport, "alias"),
+			target: target,
+			global: getByName(src, capts, c.qImport, "global") != "",
+			rng:    rangeByName(src, capts, c.qImport, "rng"),
+		})
+	})
+	return out
+}
+
+// csFileNamespace returns the name of a file-scoped namespace declaration (namespace A.B;), if any.
+func csFileNamespace(src []byte, root *sitter.Node) string {
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		if ch := root.NamedChild(i); ch.Type() == "file_scoped_namespace_declaration" {
+			if name := ch.ChildByFieldName("name"); name != nil {
+				return name.Content(src)
+			}
+		}
+	}
+	return ""
+}
+
+// csContainer returns the fully qualified container of a node: the file-scoped namespace,
+// enclosing namespace blocks and enclosing type declarations, joined with dots.
+func csContainer(src []byte, decl *sitter.Node, fileNS string) string {
+	var parts []string
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		switch n.Type() {
+		case "namespace_declaration", "class_declaration", "struct_declaration", "record_declaration",
+			"interface_declaration", "enum_declaration":
+			if name := n.ChildByFieldName("name"); name != nil {
+				parts = append([]string{name.Content(src)}, parts...)
+			}
+		}
+	}
+	if fileNS != "" {
+		parts = append([]string{fileNS}, parts...)
+	}
+	return strings.Join(parts, ".")
+}
+
+// csQualified returns the qualified name of a C# symbol ID, without the parameter types of a
+// method or constructor ("cs::file::A.B.M(int)" -> "A.B.M").
+func csQualified(sid string) string {
+	q, _, _ := strings.Cut(sidQualified(sid), "(")
+	return q
+}
+
+// rangeContains reports whether p lies within r, whose End is exclusive.
+func rangeContains(r Range, p Pos) bool {
+	before := func(a, b Pos) bool { return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col }
+	return !before(p, r.Start) && before(p, r.End)
+}
+
+// sidQualified returns the qualified-name part of a symbol ID ("lang::file::A.B.c" -> "A.B.c").
+func sidQualified(sid string) string {
+	return sid[strings.LastIndex(sid, "::")+2:]
+}
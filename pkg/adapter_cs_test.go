@@ -0,0 +1,118 @@
+package xref
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// csFiles has a partial class split across a namespace block and a file-scoped namespace, with
+// overloads in both parts, and same-named types in two namespaces that global usings and
+// aliases choose between.
+var csFiles = fstest.MapFS{
+	"Global.cs":      {Data: []byte("global using Acme.Models;\nglobal using M = Acme.Models.Money;\n")},
+	"Shop/Models.cs": {Data: []byte("namespace Acme.Models\n{\n    public class User { }\n    public class Money { }\n}\n")},
+	"Other/Other.cs": {Data: []byte("namespace Other { public class User { } public class Money { } }\n")},
+	"Shop/Cart.Part1.cs": {Data: []byte(`namespace Acme.Shop
+{
+    public partial class Cart
+    {
+        public void Add(int id) { }
+        public void Add(string sku) { Add(1); }
+    }
+}
+`)},
+	"Shop/Cart.Part2.cs": {Data: []byte(`namespace Acme.Shop;
+using O = Other.Money;
+public partial class Cart
+{
+    public void Add(User u, int qty) { }
+    void Total() { Add(2); User u; M m; O o; }
+}
+`)},
+}
+
+// TestCsResolveAt checks C# resolution across the parts of a partial class, through global
+// usings, and through local and global aliases.
+func TestCsResolveAt(t *testing.T) {
+	e, err := New(WithLanguages("cs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(csFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	var adds []string
+	for sid, d := range e.GetDefinitions() {
+		if d.Name == "Add" {
+			adds = append(adds, strings.TrimPrefix(sid, "cs::"))
+		}
+	}
+	slices.Sort(adds)
+	want := []string{"Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(int)", "Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(string)", "Shop/Cart.Part2.cs::Acme.Shop.Cart.Add(User,int)"}
+	if !slices.Equal(adds, want) {
+		t.Errorf("overloads of Add %q, want %q", adds, want)
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      []string // leading candidates, without the "cs::" prefix
+	}{
+		{
+			name: "members of every partial part, this file's first",
+			file: "Shop/Cart.Part2.cs", line: 6, col: 20,
+			want: []string{"Shop/Cart.Part2.cs::Acme.Shop.Cart.Add(User,int)", "Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(int)", "Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(string)"},
+		},
+		{
+			name: "members of every partial part, from a namespace block",
+			file: "Shop/Cart.Part1.cs", line: 6, col: 39,
+			want: []string{"Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(int)", "Shop/Cart.Part1.cs::Acme.Shop.Cart.Add(string)", "Shop/Cart.Part2.cs::Acme.Shop.Cart.Add(User,int)"},
+		},
+		{
+			name: "namespace of a global using",
+			file: "Shop/Cart.Part2.cs", line: 6, col: 28,
+			want: []string{"Shop/Models.cs::Acme.Models.User", "Other/Other.cs::Other.User"},
+		},
+		{
+			name: "namespace of a global using, in a parameter",
+			file: "Shop/Cart.Part2.cs", line: 5, col: 21,
+			want: []string{"Shop/Models.cs::Acme.Models.User", "Other/Other.cs::Other.User"},
+		},
+		{
+			name: "global alias",
+			file: "Shop/Cart.Part2.cs", line: 6, col: 36,
+			want: []string{"Shop/Models.cs::Acme.Models.Money"},
+		},
+		{
+			name: "local alias",
+			file: "Shop/Cart.Part2.cs", line: 6, col: 41,
+			want: []string{"Other/Other.cs::Other.Money"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, sid := range cands {
+				got = append(got, strings.TrimPrefix(sid, "cs::"))
+			}
+			if len(got) < len(tt.want) || !slices.Equal(got[:len(tt.want)], tt.want) {
+				t.Errorf("candidates %q, want them to start with %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := e.RemoveFile("Global.cs"); err != nil {
+		t.Fatal(err)
+	}
+	if d, cands, err := e.FindDefinitionAt("Shop/Cart.Part2.cs", 6, 36); err == nil {
+		t.Errorf("global alias M still resolves to %s %s (candidates %q) once Global.cs is gone", d.Kind, d.Name, cands)
+	}
+}
@@ -1,7 +1,6 @@
 package xref
 
 import (
-	"context"
 	"path/filepath"
 	"strings"
 
@@ -10,47 +9,38 @@ import (
 )
 
 type goAdapter struct {
-	qDefs, qRefs, qImport *sitter.Query
+	symbolQueries
 }
 
 // newGoAdapter creates a Go language adapter with pre-compiled tree-sitter queries.
-// Loads definition, reference, and import queries from .scm files for Go syntax trees.
+// Loads definition (functions, types, vars, consts), reference (identifier usage) and import
+// queries from .scm files for Go syntax trees, combined to run in one pass.
 func newGoAdapter() (LanguageAdapter, error) {
-	tsLang := golang.GetLanguage()
-	
-	// Load tree-sitter query for finding definitions (functions, types, vars, consts)
-	qd, err := loadQuery("go", "defs.scm", tsLang)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Load tree-sitter query for finding references (identifier usage)
-	qr, err := loadQuery("go", "refs.scm", tsLang)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Load tree-sitter query for finding import statements
-	qi, err := loadQuery("go", "imports.scm", tsLang)
+	return (&goAdapter{}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (g *goAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "go", golang.GetLanguage())
 	if err != nil {
 		return nil, err
 	}
-	
-	return &goAdapter{qDefs: qd, qRefs: qr, qImport: qi}, nil
+	return &goAdapter{symbolQueries: sq}, nil
 }
 func (g *goAdapter) Lang() string { return "go" }
 func (g *goAdapter) CanHandle(path string) bool {
 	return strings.HasSuffix(strings.ToLower(path), ".go")
 }
 
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (g *goAdapter) Language(_ string) *sitter.Language { return golang.GetLanguage() }
+
 func (g *goAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
-	p := sitter.NewParser()
 	lang := golang.GetLanguage()
 	if lang == nil {
 		return nil, nil // Language not available
 	}
-	p.SetLanguage(lang)
-	return p.ParseCtx(context.Background(), nil, src)
+	return parse("go", lang, src)
 }
 
 // Extract analyzes a Go source file's syntax tree and extracts all symbols.
@@ -62,9 +52,10 @@ func (g *goAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIn
 		return fi, nil // Return empty index if parsing failed
 	}
 	root := tree.RootNode()
+	qp := g.queries.run(src, root) // One walk of the tree for all queries
 	
 	// Extract import statements using the imports query
-	execQuery(src, root, g.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+	qp.each(g.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
 		alias := getByName(src, capts, g.qImport, "alias")
 		ipath := strings.Trim(getByName(src, capts, g.qImport, "path"), "`\"")
 		rng := rangeByName(src, capts, g.qImport, "rng")
@@ -79,7 +70,7 @@ func (g *goAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIn
 	})
 	
 	// Extract definitions (functions, methods, types, variables, constants) using the defs query
-	execQuery(src, root, g.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+	qp.each(g.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
 		var name, kind, recv string
 		
 		// Determine the type of definition based on which query capture matched
@@ -113,7 +104,7 @@ func (g *goAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIn
 	})
 	
 	// Extract all identifier references using the refs query
-	execQuery(src, root, g.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+	qp.each(g.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
 		id := getByName(src, capts, g.qRefs, "id")
 		rng := rangeByName(src, capts, g.qRefs, "rng")
 		if id != "" {
@@ -127,17 +118,19 @@ func (g *goAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIn
 // ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
 // First tries to find a local definition in the same file, then falls back to global name lookup.
 // Returns symbol IDs in priority order (local definitions first, then global matches).
-func (g *goAdapter) ResolveAt(path string, _ []byte, occ Occurrence, pi *ProjectIndex) []string {
-	pi.mu.RLock()
-	defer pi.mu.RUnlock()
+func (g *goAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	cname, isCgo := cgoName(src, occ)
+
+	// cgo: C.xxx names a C definition from the preamble or the package directory
+	if isCgo {
+		return resolveCgo(path, cname, idx)
+	}
 	
 	// First priority: look for a definition in the same file (local scope)
-	for sid, d := range pi.Defs {
-		if d.Lang == "go" && d.File == path && d.Name == occ.Name {
-			return []string{sid}
-		}
+	if local := idx.FileLookup(path, "go", occ.Name); len(local) > 0 {
+		return local[:1]
 	}
 	
 	// Fallback: use global name lookup to find symbols across all files
-	return append([]string(nil), pi.NameLookup["go:"+occ.Name]...)
+	return idx.Lookup("go", occ.Name)
 }
@@ -0,0 +1,225 @@
+package xref
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/java"
+)
+
+// DefaultJavaSourceRoots are the source roots searched when resolving Java packages to directories.
+// The empty root matches a package directory anywhere in the tree.
+var DefaultJavaSourceRoots = []string{"src/main/java", "src/test/java", "src", ""}
+
+type javaAdapter struct {
+	symbolQueries
+	roots []string
+}
+
+// NewJavaAdapter creates a Java language adapter with pre-compiled tree-sitter queries.
+// sourceRoots lists the directories (e.g. "src/main/java") under which package directories live;
+// when empty, DefaultJavaSourceRoots is used.
+func NewJavaAdapter(sourceRoots ...string) (LanguageAdapter, error) {
+	if len(sourceRoots) == 0 {
+		sourceRoots = DefaultJavaSourceRoots
+	}
+	return (&javaAdapter{roots: sourceRoots}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (j *javaAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "java", java.GetLanguage())
+	if err != nil {
+		return nil, err
+	}
+	cp := *j
+	cp.symbolQueries = sq
+	return &cp, nil
+}
+
+func (j *javaAdapter) Lang() string { return "java" }
+func (j *javaAdapter) CanHandle(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".java")
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (j *javaAdapter) Language(_ string) *sitter.Language { return java.GetLanguage() }
+
+func (j *javaAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	lang := java.GetLanguage()
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse("java", lang, src)
+}
+
+// javaDefs maps the name captures of queries/java/defs.scm to the kinds of definition they name.
+var javaDefs = []DefCapture{
+	{Name: "cname", Kind: "class"},
+	{Name: "iname", Kind: "interface"},
+	{Name: "ename", Kind: "enum"},
+	{Name: "rname", Kind: "record"},
+	{Name: "aname", Kind: "annotation"},
+	{Name: "mname", Kind: "method"},
+	{Name: "ctor", Kind: "constructor"},
+	{Name: "kname", Kind: "const"},
+	{Name: "fname", Kind: "field"},
+}
+
+// Extract analyzes a Java source file's syntax tree and extracts all symbols.
+// Types, methods and fields are keyed by their enclosing type chain (Outer.Inner), and
+// methods and constructors additionally carry their erased parameter types so overloads stay distinct.
+func (j *javaAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "java", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	qp := j.queries.run(src, root) // One walk of the tree for all queries
+
+	// Record the package declaration and import statements
+	pkg, imps := j.readHeader(src, qp)
+	fi.Package = pkg
+	for _, im := range imps {
+		if im.wildcard {
+			// On-demand imports don't introduce a name of their own
+			fi.Imports[im.path+".*"] = im.path
+			continue
+		}
+		alias := lastDotted(im.path)
+		fi.Imports[alias] = im.path
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: alias, KindHint: "import", Rng: im.rng})
+	}
+
+	// Extract type, method, constructor, field and enum constant definitions
+	qp.each(j.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		name, d := matchDef(src, capts, j.qDefs, javaDefs)
+		kind := d.Kind
+		if name == "" {
+			return
+		}
+		rng := rangeByName(src, capts, j.qDefs, "rng")
+		container := javaContainer(src, nodeByName(capts, j.qDefs, "rng"))
+		sid := symbolID("java", path, container, name)
+		params := nodeByName(capts, j.qDefs, "params")
+		if params != nil {
+			// Overloads share a name, so the erased parameter types become part of the ID
+			sid += "(" + strings.Join(javaParamTypes(src, params), ",") + ")"
+		}
+		fi.Defs[sid] = DefLocation{Lang: "java", File: path, Rng: rng, Name: name, Kind: kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+		if params != nil {
+			// The declared name gets an occurrence too, ahead of its reference, so going to the
+			// definition from it picks this overload rather than every one of that name
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rangeByName(src, capts, j.qDefs, d.Name), SymbolID: sid})
+		}
+	})
+
+	qp.each(j.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, j.qRefs, "id")
+		rng := rangeByName(src, capts, j.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
+// Same-file definitions win; otherwise candidates (Java and Kotlin alike) are ranked the way javac
+// resolves simple names, see resolveJVM.
+func (j *javaAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	if occ.SymbolID != "" {
+		return []string{occ.SymbolID} // Definitions already know their (overload-specific) ID
+	}
+
+	// First priority: definitions in the same file (all overloads, in stable order)
+	local := idx.FileLookup(path, "java", occ.Name)
+	if len(local) > 0 {
+		sort.Strings(local)
+		return local
+	}
+
+	// The package and imports come from the index rather than parsing the file again
+	return resolveJVM(path, occ.Name, idx.Package(path), jvmImports(idx.Imports(path)), j.roots, idx)
+}
+
+// readHeader returns the declared package and the import declarations of a Java file.
+func (j *javaAdapter) readHeader(src []byte, qp *queryPass) (string, []jvmImport) {
+	var pkg string
+	var imps []jvmImport
+	qp.each(j.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		if p := getByName(src, capts, j.qImport, "package"); p != "" {
+			pkg = p
+			return
+		}
+		if p := getByName(src, capts, j.qImport, "path"); p != "" {
+			imps = append(imps, jvmImport{
+				path:     p,
+				static:   getByName(src, capts, j.qImport, "static") != "",
+				wildcard: getByName(src, capts, j.qImport, "wildcard") != "",
+				rng:      rangeByName(src, capts, j.qImport, "rng"),
+			})
+		}
+	})
+	return pkg, imps
+}
+
+// javaContainer returns the chain of enclosing type names for a declaration node, e.g. "Outer.Inner".
+func javaContainer(src []byte, decl *sitter.Node) string {
+	if decl == nil {
+		return ""
+	}
+	var parts []string
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		switch n.Type() {
+		case "class_declaration", "interface_declaration", "enum_declaration", "record_declaration", "annotation_type_declaration":
+			if name := n.ChildByFieldName("name"); name != nil {
+				parts = append([]string{name.Content(src)}, parts...)
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// javaParamTypes returns the erased parameter types of a formal_parameters node.
+// Generic arguments and whitespace are dropped so List<String> and List<T> collide as they do in javac.
+func javaParamTypes(src []byte, params *sitter.Node) []string {
+	var out []string
+	for i := 0; i < int(params.NamedChildCount()); i++ {
+		p := params.NamedChild(i)
+		switch p.Type() {
+		case "formal_parameter":
+			if t := p.ChildByFieldName("type"); t != nil {
+				out = append(out, eraseGenerics(t.Content(src)))
+			}
+		case "spread_parameter":
+			// Varargs: the type is the first named child that isn't a modifier
+			for k := 0; k < int(p.NamedChildCount()); k++ {
+				if c := p.NamedChild(k); c.Type() != "modifiers" {
+					out = append(out, eraseGenerics(c.Content(src))+"...")
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// eraseGenerics strips type arguments and whitespace from a type expression.
+func eraseGenerics(t string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range t {
+		switch {
+		case r == '<':
+			depth++
+		case r == '>':
+			depth--
+		case depth == 0 && r != ' ' && r != '\t' && r != '\n' && r != '\r':
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package xref
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// javaFiles has overloaded methods and constructors, and classes reached through single,
+// on-demand and static imports, with same-named declarations in a decoy package that sorts first.
+var javaFiles = fstest.MapFS{
+	"src/main/java/com/acme/util/Calc.java": {Data: []byte(`package com.acme.util;
+import java.util.List;
+public class Calc {
+    public Calc() {}
+    public Calc(int seed) {}
+    public static int add(int a) { return a; }
+    public static int add(int a, String b) { return a; }
+    public static int add(List<String> xs) { return 0; }
+    public static int add(int... xs) { return add(1); }
+}
+`)},
+	"src/main/java/com/acme/model/User.java": {Data: []byte("package com.acme.model;\npublic class User {}\n")},
+	"src/main/java/com/acme/app/Repo.java":   {Data: []byte("package com.acme.app;\nclass Repo {}\n")},
+	"src/main/java/com/acme/a/Decoy.java": {Data: []byte(`package com.acme.a;
+class Calc { static int add(int a) { return a; } }
+class User {}
+class Repo {}
+`)},
+	"src/main/java/com/acme/app/App.java": {Data: []byte(`package com.acme.app;
+import com.acme.util.Calc;
+import com.acme.model.*;
+import static com.acme.util.Calc.add;
+class App {
+    void run() { Calc c = new Calc(1); User u; Repo r; add(2); }
+}
+`)},
+}
+
+// TestJavaResolveAt checks that overloads get distinct symbol IDs, that a declaration resolves
+// to its own overload and a call to all of them, and that names from other packages resolve
+// through single, on-demand and static imports and the file's own package.
+func TestJavaResolveAt(t *testing.T) {
+	e, err := New(WithLanguages("java"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(javaFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	const calc = "java::src/main/java/com/acme/util/Calc.java::"
+	var got []string
+	for sid := range e.GetDefinitions() {
+		if strings.HasPrefix(sid, calc) {
+			got = append(got, strings.TrimPrefix(sid, calc))
+		}
+	}
+	slices.Sort(got)
+	want := []string{"Calc", "Calc.Calc()", "Calc.Calc(int)", "Calc.add(List)", "Calc.add(int)", "Calc.add(int,String)", "Calc.add(int...)"}
+	if !slices.Equal(got, want) {
+		t.Errorf("symbol IDs in Calc.java %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      []string // leading candidates, without the "java::" prefix
+	}{
+		{
+			name: "declaration of an overload",
+			file: "src/main/java/com/acme/util/Calc.java", line: 7, col: 23,
+			want: []string{"src/main/java/com/acme/util/Calc.java::Calc.add(int,String)"},
+		},
+		{
+			name: "call within the class",
+			file: "src/main/java/com/acme/util/Calc.java", line: 9, col: 48,
+			want: []string{
+				"src/main/java/com/acme/util/Calc.java::Calc.add(List)", "src/main/java/com/acme/util/Calc.java::Calc.add(int)",
+				"src/main/java/com/acme/util/Calc.java::Calc.add(int,String)", "src/main/java/com/acme/util/Calc.java::Calc.add(int...)",
+			},
+		},
+		{
+			name: "single import",
+			file: "src/main/java/com/acme/app/App.java", line: 6, col: 18,
+			want: []string{"src/main/java/com/acme/util/Calc.java::Calc"},
+		},
+		{
+			name: "on-demand import",
+			file: "src/main/java/com/acme/app/App.java", line: 6, col: 40,
+			want: []string{"src/main/java/com/acme/model/User.java::User"},
+		},
+		{
+			name: "same package",
+			file: "src/main/java/com/acme/app/App.java", line: 6, col: 48,
+			want: []string{"src/main/java/com/acme/app/Repo.java::Repo"},
+		},
+		{
+			name: "static import of overloads",
+			file: "src/main/java/com/acme/app/App.java", line: 6, col: 56,
+			want: []string{
+				"src/main/java/com/acme/util/Calc.java::Calc.add(List)", "src/main/java/com/acme/util/Calc.java::Calc.add(int)",
+				"src/main/java/com/acme/util/Calc.java::Calc.add(int,String)", "src/main/java/com/acme/util/Calc.java::Calc.add(int...)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, sid := range cands {
+				got = append(got, strings.TrimPrefix(sid, "java::"))
+			}
+			if len(got) < len(tt.want) || !slices.Equal(got[:len(tt.want)], tt.want) {
+				t.Errorf("candidates %q, want them to start with %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package xref
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/kotlin"
+)
+
+// DefaultKotlinSourceRoots are the source roots searched when resolving Kotlin (and, across
+// languages, Java) packages to directories. The empty root matches a package directory anywhere.
+var DefaultKotlinSourceRoots = []string{"src/main/kotlin", "src/test/kotlin", "src/main/java", "src/test/java", "src", ""}
+
+type ktAdapter struct {
+	symbolQueries
+	roots []string
+}
+
+// NewKtAdapter creates a Kotlin language adapter with pre-compiled tree-sitter queries.
+// sourceRoots lists the directories under which package directories live; when empty,
+// DefaultKotlinSourceRoots is used.
+func NewKtAdapter(sourceRoots ...string) (LanguageAdapter, error) {
+	if len(sourceRoots) == 0 {
+		sourceRoots = DefaultKotlinSourceRoots
+	}
+	return (&ktAdapter{roots: sourceRoots}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (k *ktAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "kt", kotlin.GetLanguage())
+	if err != nil {
+		return nil, err
+	}
+	cp := *k
+	cp.symbolQueries = sq
+	return &cp, nil
+}
+
+func (k *ktAdapter) Lang() string { return "kt" }
+func (k *ktAdapter) CanHandle(path string) bool {
+	l := strings.ToLower(path)
+	return strings.HasSuffix(l, ".kt") || strings.HasSuffix(l, ".kts")
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (k *ktAdapter) Language(_ string) *sitter.Language { return kotlin.GetLanguage() }
+
+func (k *ktAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	lang := kotlin.GetLanguage()
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse("kt", lang, src)
+}
+
+// ktDefs maps the name captures of queries/kt/defs.scm to the kinds of definition they name.
+// Companion objects are captured as @companion, without a name.
+var ktDefs = []DefCapture{
+	{Name: "cname", Kind: "class"},
+	{Name: "oname", Kind: "object"},
+	{Name: "fname", Kind: "func"},
+	{Name: "pname", Kind: "property"},
+	{Name: "kname", Kind: "const"},
+	{Name: "tname", Kind: "type"},
+}
+
+// Extract analyzes a Kotlin source file's syntax tree and extracts all symbols.
+// Members are keyed by their enclosing classes and objects (a companion object counts as one,
+// named "Companion" unless declared otherwise); extension functions use their receiver type as container.
+func (k *ktAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "kt", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	qp := k.queries.run(src, root) // One walk of the tree for all queries
+
+	pkg, imps := k.readHeader(src, qp)
+	fi.Package = pkg
+	for _, im := range imps {
+		if im.wildcard {
+			fi.Imports[im.path+".*"] = im.path
+			continue
+		}
+		fi.Imports[im.localName()] = im.path
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: im.localName(), KindHint: "import", Rng: im.rng})
+	}
+
+	qp.each(k.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		decl := nodeByName(capts, k.qDefs, "rng")
+		name, d := matchDef(src, capts, k.qDefs, ktDefs)
+		kind := d.Kind
+		switch {
+		case kind == "class" && hasChildOfType(decl, "interface"):
+			kind = "interface"
+		case kind == "class" && hasChildOfType(decl, "enum"):
+			kind = "enum"
+		case nodeByName(capts, k.qDefs, "companion") != nil:
+			name, kind = ktObjectName(src, decl), "object" // Named by its declaration, else "Companion"
+		}
+		if name == "" {
+			return
+		}
+		container := ktContainer(src, decl)
+		if kind == "func" {
+			if recv := ktReceiver(src, decl); recv != "" {
+				container = recv // Extension function: String.shout() lives on String
+			}
+		}
+		rng := rangeByName(src, capts, k.qDefs, "rng")
+		sid := symbolID("kt", path, container, name)
+		fi.Defs[sid] = DefLocation{Lang: "kt", File: path, Rng: rng, Name: name, Kind: kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(k.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, k.qRefs, "id")
+		rng := rangeByName(src, capts, k.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
+// Same-file definitions win; otherwise Kotlin and Java candidates are ranked together by
+// imports and packages (see resolveJVM), so Kotlin code can jump into Java classes and back.
+// The file's package and imports are read from the index rather than parsed again.
+func (k *ktAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	local := idx.FileLookup(path, "kt", occ.Name)
+	if len(local) > 0 {
+		sort.Strings(local)
+		return local
+	}
+	return resolveJVM(path, occ.Name, idx.Package(path), jvmImports(idx.Imports(path)), k.roots, idx)
+}
+
+// readHeader returns the declared package and the imports of a Kotlin file.
+func (k *ktAdapter) readHeader(src []byte, qp *queryPass) (string, []jvmImport) {
+	var pkg string
+	var imps []jvmImport
+	qp.each(k.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		if p := getByName(src, capts, k.qImport, "package"); p != "" {
+			pkg = p
+			return
+		}
+		if p := getByName(src, capts, k.qImport, "path"); p != "" {
+			imps = append(imps, jvmImport{
+				path:     p,
+				alias:    getByName(src, capts, k.qImport, "alias"),
+				wildcard: getByName(src, capts, k.qImport, "wildcard") != "",
+				rng:      rangeByName(src, capts, k.qImport, "rng"),
+			})
+		}
+	})
+	return pkg, imps
+}
+
+// ktContainer returns the chain of enclosing classes and objects for a declaration node.
+func ktContainer(src []byte, decl *sitter.Node) string {
+	var parts []string
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		switch n.Type() {
+		case "class_declaration", "object_declaration", "companion_object":
+			if name := ktObjectName(src, n); name != "" {
+				parts = append([]string{name}, parts...)
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// ktObjectName returns the declared name of a class or object; companion objects default to "Companion".
+func ktObjectName(src []byte, n *sitter.Node) string {
+	for i := 0; i < int(n.NamedChildCount()); i++ {
+		if ch := n.NamedChild(i); ch.Type() == "type_identifier" {
+			return ch.Content(src)
+		}
+	}
+	if n.Type() == "companion_object" {
+		return "Companion"
+	}
+	return ""
+}
+
+// ktReceiver returns the receiver type name of an extension function, or "" for ordinary functions.
+// The receiver is the type written before the function name (fun List<T>.second()).
+func ktReceiver(src []byte, fn *sitter.Node) string {
+	for i := 0; i < int(fn.NamedChildCount()); i++ {
+		ch := fn.NamedChild(i)
+		switch ch.Type() {
+		case "simple_identifier":
+			return "" // Reached the name without seeing a receiver
+		case "user_type", "nullable_type", "parenthesized_type":
+			if id := firstDescendantOfType(ch, "type_identifier"); id != nil {
+				return id.Content(src)
+			}
+		}
+	}
+	return ""
+}
+
+// hasChildOfType reports whether n has a direct child (named or anonymous) of the given type.
+func hasChildOfType(n *sitter.Node, typ string) bool {
+	for i := 0; i < int(n.ChildCount()); i++ {
+		if n.Child(i).Type() == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// firstDescendantOfType returns the first node of the given type in a pre-order walk of n.
+func firstDescendantOfType(n *sitter.Node, typ string) *sitter.Node {
+	if n.Type() == typ {
+		return n
+	}
+	for i := 0; i < int(n.NamedChildCount()); i++ {
+		if d := firstDescendantOfType(n.NamedChild(i), typ); d != nil {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package xref
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// ktJavaFiles is a Gradle-style project mixing Kotlin and Java, with same-named declarations
+// in a decoy package that sorts first.
+var ktJavaFiles = fstest.MapFS{
+	"src/main/java/com/acme/model/User.java":  {Data: []byte("package com.acme.model;\npublic class User {}\n")},
+	"src/main/java/com/acme/model/Order.java": {Data: []byte("package com.acme.model;\npublic class Order {}\n")},
+	"src/main/java/com/acme/decoy/Decoy.java": {Data: []byte("package com.acme.decoy;\nclass User {}\nclass Order {}\nclass Service {}\n")},
+	"src/main/kotlin/com/acme/decoy/Decoy.kt": {Data: []byte("package com.acme.decoy\nclass Formatter\nobject Config\nfun helper() {}\n")},
+	"src/main/kotlin/com/acme/util/Format.kt": {Data: []byte("package com.acme.util\nclass Formatter\n")},
+	"src/main/kotlin/com/acme/conf/Config.kt": {Data: []byte("package com.acme.conf\nobject Config\n")},
+	"src/main/kotlin/com/acme/app/Helpers.kt": {Data: []byte("package com.acme.app\nfun helper() {}\n")},
+	"src/main/java/com/acme/app/Service.java": {Data: []byte("package com.acme.app;\npublic class Service {}\n")},
+	"src/main/kotlin/com/acme/app/Main.kt": {Data: []byte(`package com.acme.app
+import com.acme.model.User
+import com.acme.model.Order as Purchase
+fun main() {
+    val u = User(); val p = Purchase(); helper(); Service()
+}
+`)},
+	"src/main/java/com/acme/app/Job.java": {Data: []byte(`package com.acme.app;
+import com.acme.util.Formatter;
+import com.acme.conf.*;
+class Job {
+    void run() { Formatter f; Config c; }
+}
+`)},
+}
+
+// TestKtJavaInterop checks that Kotlin names resolve to Java declarations and Java names to
+// Kotlin ones, by single and on-demand imports, import aliases and the file's own package.
+func TestKtJavaInterop(t *testing.T) {
+	e, err := New(WithLanguages("kt", "java"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(ktJavaFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      string // file of the first candidate
+	}{
+		{"Kotlin to a Java class by import", "src/main/kotlin/com/acme/app/Main.kt", 5, 13, "src/main/java/com/acme/model/User.java"},
+		{"Kotlin to a Java class by import alias", "src/main/kotlin/com/acme/app/Main.kt", 5, 29, "src/main/java/com/acme/model/Order.java"},
+		{"Kotlin to a Kotlin function in the package", "src/main/kotlin/com/acme/app/Main.kt", 5, 41, "src/main/kotlin/com/acme/app/Helpers.kt"},
+		{"Kotlin to a Java class in the package", "src/main/kotlin/com/acme/app/Main.kt", 5, 51, "src/main/java/com/acme/app/Service.java"},
+		{"Java to a Kotlin class by import", "src/main/java/com/acme/app/Job.java", 5, 18, "src/main/kotlin/com/acme/util/Format.kt"},
+		{"Java to a Kotlin object by on-demand import", "src/main/java/com/acme/app/Job.java", 5, 31, "src/main/kotlin/com/acme/conf/Config.kt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.File != tt.want {
+				t.Errorf("definition %s %s in %s, want one in %s (candidates %q)", d.Kind, d.Name, d.File, tt.want, cands)
+			}
+		})
+	}
+
+	// Resolution reads the imports the index holds, so it follows an update of the file
+	fsys := fstest.MapFS{"src/main/kotlin/com/acme/app/Main.kt": {Data: []byte(`package com.acme.app
+import com.acme.decoy.User
+fun main() {
+    val u = User()
+}
+`)}}
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	if d, _, err := e.FindDefinitionAt("src/main/kotlin/com/acme/app/Main.kt", 4, 13); err != nil || d.File != "src/main/java/com/acme/decoy/Decoy.java" {
+		t.Errorf("after changing the import: definition in %s (%v), want one in Decoy.java", d.File, err)
+	}
+}
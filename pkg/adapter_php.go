@@ -0,0 +1,459 @@
+package xref
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/php"
+)
+
+type phpAdapter struct {
+	symbolQueries
+
+	mu       sync.Mutex
+	composer map[string]composerFile // composer.json path -> its stamp when last read, and its config
+}
+
+// composerFile is the stamp of a composer.json when it was last read, and its parsed autoload
+// config (nil if invalid).
+type composerFile struct {
+	stamp fileStamp
+	al    *composerAutoload
+}
+
+// phpUse is one imported name from a use statement (group uses are expanded).
+type phpUse struct {
+	alias string // local name
+	fqn   string // fully qualified name without leading backslash
+	rng   Range
+}
+
+// composerAutoload holds the PSR-4 and PSR-0 mappings of a composer.json (autoload and autoload-dev).
+type composerAutoload struct {
+	dir        string
+	psr4, psr0 []composerPrefix // longest prefix first
+}
+
+type composerPrefix struct {
+	prefix string
+	dirs   []string
+}
+
+// newPhpAdapter creates a PHP language adapter with pre-compiled tree-sitter queries.
+func newPhpAdapter() (LanguageAdapter, error) {
+	return (&phpAdapter{}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (p *phpAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "php", php.GetLanguage())
+	if err != nil {
+		return nil, err
+	}
+	return &phpAdapter{symbolQueries: sq, composer: map[string]composerFile{}}, nil
+}
+
+func (p *phpAdapter) Lang() string { return "php" }
+func (p *phpAdapter) CanHandle(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".php")
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (p *phpAdapter) Language(_ string) *sitter.Language { return php.GetLanguage() }
+
+func (p *phpAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	lang := php.GetLanguage()
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse("php", lang, src)
+}
+
+// phpDefs maps the name captures of queries/php/defs.scm to the kinds of definition they name.
+var phpDefs = []DefCapture{
+	{Name: "nsname", Kind: "namespace"},
+	{Name: "cname", Kind: "class"},
+	{Name: "iname", Kind: "interface"},
+	{Name: "tname", Kind: "trait"},
+	{Name: "ename", Kind: "enum"},
+	{Name: "fname", Kind: "func"},
+	{Name: "mname", Kind: "method"},
+	{Name: "kname", Kind: "const"},
+	{Name: "pname", Kind: "property"},
+}
+
+// Extract analyzes a PHP source file's syntax tree and extracts all symbols.
+// Containers are namespace-qualified with backslashes (App\Models\User), so a method's symbol ID
+// ends in "App\Models\User.save" and a class's in "App\Models.User".
+func (p *phpAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "php", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	qp := p.queries.run(src, root) // One walk of the tree for all queries
+
+	for _, u := range p.readUses(src, qp) {
+		fi.Imports[u.alias] = u.fqn
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: u.alias, KindHint: "import", Rng: u.rng})
+	}
+
+	qp.each(p.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		name, d := matchDef(src, capts, p.qDefs, phpDefs)
+		kind := d.Kind
+		if name == "" {
+			return
+		}
+		decl := nodeByName(capts, p.qDefs, "rng")
+		var container string
+		if kind == "namespace" {
+			// namespace App\Models declares Models inside App
+			container, name = phpTrimLast(name), phpLast(name)
+		} else {
+			container = phpContainer(src, decl)
+		}
+		rng := rangeByName(src, capts, p.qDefs, "rng")
+		sid := symbolID("php", path, container, name)
+		fi.Defs[sid] = DefLocation{Lang: "php", File: path, Rng: rng, Name: name, Kind: kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(p.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, p.qRefs, "id")
+		rng := rangeByName(src, capts, p.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a symbol occurrence to candidate symbol IDs for "go to definition".
+// The occurrence is first turned into a fully qualified name using PHP's rules (use aliases,
+// qualified and fully qualified names, the current namespace). Candidates are then ranked:
+// definitions in the file Composer's PSR-4/PSR-0 autoloader would load for that name, any other
+// definition with that qualified name, same-file definitions, and finally any symbol with the name.
+func (p *phpAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	return p.resolveIn(osFS{}, path, src, occ, idx)
+}
+
+// resolveIn is ResolveAt with composer.json read from fsys, the file system path was indexed from.
+func (p *phpAdapter) resolveIn(fsys fs.FS, path string, src []byte, occ Occurrence, idx Store) []string {
+	fqn := occ.Name
+	if tree, err := p.Parse(path, src); err == nil && tree != nil {
+		fqn = p.qualify(src, tree.RootNode(), occ)
+	}
+	name := phpLast(fqn)
+	var autoloaded []string
+	if al := p.autoloadFor(fsys, path); al != nil {
+		autoloaded = al.files(fqn)
+	}
+
+	rank := func(sid string) int {
+		d, _ := idx.Definition(sid)
+		qualified := sidQualified(sid)
+		if i := strings.LastIndex(qualified, "."); i >= 0 {
+			qualified = qualified[:i] + `\` + qualified[i+1:]
+		}
+		qualified = strings.TrimPrefix(qualified, `\`)
+		switch {
+		case qualified == fqn && len(autoloaded) > 0 && slices.Contains(autoloaded, absPath(d.File)):
+			return 0
+		case qualified == fqn:
+			return 1
+		case d.File == path:
+			return 2
+		default:
+			return 3
+		}
+	}
+	out := idx.Lookup("php", name)
+	ranks := make(map[string]int, len(out))
+	for _, sid := range out {
+		ranks[sid] = rank(sid) // Once per candidate: ranking reads its definition
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := ranks[out[i]], ranks[out[j]]
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// qualify expands the name at occ into a fully qualified name.
+func (p *phpAdapter) qualify(src []byte, root *sitter.Node, occ Occurrence) string {
+	pt := sitter.Point{Row: uint32(occ.Rng.Start.Line - 1), Column: uint32(occ.Rng.Start.Col - 1)}
+	n := root.NamedDescendantForPointRange(pt, pt)
+	if n == nil {
+		return occ.Name
+	}
+	aliases := map[string]string{}
+	for _, u := range p.readUses(src, lazyPass(src, root)) {
+		aliases[strings.ToLower(u.alias)] = u.fqn
+	}
+
+	// Widen to the qualified name the occurrence is part of, up to and including the occurrence
+	// (so the App in App\Models\User names the namespace, not the class)
+	written := occ.Name
+	for q := n.Parent(); q != nil; q = q.Parent() {
+		if q.Type() == "qualified_name" {
+			written = string(src[q.StartByte():n.EndByte()])
+			break
+		}
+		if q.Type() != "namespace_name" && q.Type() != "namespace_name_as_prefix" {
+			break
+		}
+	}
+	if strings.HasPrefix(written, `\`) {
+		return strings.TrimPrefix(written, `\`) // Fully qualified
+	}
+	first, rest, qualified := strings.Cut(written, `\`)
+	if target, ok := aliases[strings.ToLower(first)]; ok {
+		if qualified {
+			return target + `\` + rest
+		}
+		return target
+	}
+	if ns := phpNamespaceAt(src, n); ns != "" {
+		return ns + `\` + written
+	}
+	return written
+}
+
+// readUses returns every name imported by the file's use statements (class, function and const).
+func (p *phpAdapter) readUses(src []byte, qp *queryPass) []phpUse {
+	var out []phpUse
+	qp.each(p.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		decl := nodeByName(capts, p.qImport, "rng")
+		rng := rangeByName(src, capts, p.qImport, "rng")
+		var prefix string
+		for i := 0; i < int(decl.NamedChildCount()); i++ {
+			ch := decl.NamedChild(i)
+			switch ch.Type() {
+			case "namespace_name":
+				prefix = strings.TrimPrefix(ch.Content(src), `\`) + `\`
+			case "namespace_use_clause":
+				out = append(out, phpUseClause(src, ch, "", rng))
+			case "namespace_use_group":
+				for k := 0; k < int(ch.NamedChildCount()); k++ {
+					if gc := ch.NamedChild(k); gc.Type() == "namespace_use_group_clause" {
+						out = append(out, phpUseClause(src, gc, prefix, rng))
+					}
+				}
+			}
+		}
+	})
+	return out
+}
+
+func phpUseClause(src []byte, clause *sitter.Node, prefix string, rng Range) phpUse {
+	var fqn, alias string
+	for i := 0; i < int(clause.NamedChildCount()); i++ {
+		ch := clause.NamedChild(i)
+		switch ch.Type() {
+		case "namespace_aliasing_clause":
+			if ch.NamedChildCount() > 0 {
+				alias = ch.NamedChild(0).Content(src)
+			}
+		default:
+			if fqn == "" {
+				fqn = prefix + strings.TrimPrefix(ch.Content(src), `\`)
+			}
+		}
+	}
+	if alias == "" {
+		alias = phpLast(fqn)
+	}
+	return phpUse{alias: alias, fqn: fqn, rng: rng}
+}
+
+// phpNamespaceAt returns the namespace in effect at node n: the enclosing braced namespace block,
+// or the closest preceding unbraced namespace statement.
+func phpNamespaceAt(src []byte, n *sitter.Node) string {
+	top := n
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Type() == "namespace_definition" {
+			if name := p.ChildByFieldName("name"); name != nil {
+				return name.Content(src)
+			}
+			return ""
+		}
+		if p.Type() == "program" {
+			break
+		}
+		top = p
+	}
+	for s := top.PrevNamedSibling(); s != nil; s = s.PrevNamedSibling() {
+		if s.Type() == "namespace_definition" && s.ChildByFieldName("body") == nil {
+			if name := s.ChildByFieldName("name"); name != nil {
+				return name.Content(src)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// phpContainer returns the qualified container of a declaration: its namespace, plus the
+// enclosing class-like declaration for members.
+func phpContainer(src []byte, decl *sitter.Node) string {
+	container := phpNamespaceAt(src, decl)
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		switch n.Type() {
+		case "class_declaration", "interface_declaration", "trait_declaration", "enum_declaration":
+			if name := n.ChildByFieldName("name"); name != nil {
+				return strings.TrimPrefix(container+`\`+name.Content(src), `\`)
+			}
+		}
+	}
+	return container
+}
+
+// autoloadFor returns the autoload config of the nearest valid composer.json above path in fsys,
+// or nil. The files are stat'ed on each lookup, so edits apply at once; a file is only read and
+// parsed again when its stamp changed, or always if fsys has no modification times.
+func (p *phpAdapter) autoloadFor(fsys fs.FS, path string) *composerAutoload {
+	if _, isOS := fsys.(osFS); isOS {
+		path = absPath(path)
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if al := p.parsedAutoload(fsys, dir); al != nil {
+			return al
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil
+		}
+	}
+}
+
+// parsedAutoload returns the autoload config of the composer.json in dir, or nil if there is
+// none or it is invalid, parsing it unless it is unchanged since it was last read.
+func (p *phpAdapter) parsedAutoload(fsys fs.FS, dir string) *composerAutoload {
+	file := filepath.Join(dir, "composer.json")
+	info, err := fs.Stat(fsys, file)
+	if err != nil {
+		p.forget(file)
+		return nil
+	}
+	st := fileStamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	p.mu.Lock()
+	cf, ok := p.composer[file]
+	p.mu.Unlock()
+	if ok && cf.stamp == st && !info.ModTime().IsZero() {
+		return cf.al
+	}
+	b, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		p.forget(file)
+		return nil
+	}
+	al := parseComposerAutoload(dir, b)
+	p.mu.Lock()
+	p.composer[file] = composerFile{stamp: st, al: al}
+	p.mu.Unlock()
+	return al
+}
+
+// forget drops the cached config of the composer.json at path, or of every one beneath it.
+// Paths read from disk are cached by absolute path, so path matches either way.
+func (p *phpAdapter) forget(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for file := range p.composer {
+		for _, q := range []string{path, absPath(path)} {
+			if file == q || strings.HasPrefix(file, q+string(filepath.Separator)) {
+				delete(p.composer, file)
+			}
+		}
+	}
+}
+
+// parseComposerAutoload parses the composer.json of dir, returning nil if it is invalid.
+func parseComposerAutoload(dir string, b []byte) *composerAutoload {
+	type autoload struct {
+		PSR4 map[string]json.RawMessage `json:"psr-4"`
+		PSR0 map[string]json.RawMessage `json:"psr-0"`
+	}
+	var cfg struct {
+		Autoload    autoload `json:"autoload"`
+		AutoloadDev autoload `json:"autoload-dev"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil
+	}
+	al := &composerAutoload{dir: dir}
+	for _, a := range []autoload{cfg.Autoload, cfg.AutoloadDev} {
+		al.psr4 = appendComposerPrefixes(al.psr4, a.PSR4)
+		al.psr0 = appendComposerPrefixes(al.psr0, a.PSR0)
+	}
+	for _, list := range [][]composerPrefix{al.psr4, al.psr0} {
+		sort.SliceStable(list, func(i, j int) bool { return len(list[i].prefix) > len(list[j].prefix) })
+	}
+	return al
+}
+
+// appendComposerPrefixes adds prefix mappings whose value may be a single directory or a list.
+func appendComposerPrefixes(dst []composerPrefix, m map[string]json.RawMessage) []composerPrefix {
+	for prefix, raw := range m {
+		var dirs []string
+		if err := json.Unmarshal(raw, &dirs); err != nil {
+			var one string
+			if err := json.Unmarshal(raw, &one); err != nil {
+				continue
+			}
+			dirs = []string{one}
+		}
+		dst = append(dst, composerPrefix{prefix: prefix, dirs: dirs})
+	}
+	return dst
+}
+
+// files returns the absolute paths Composer would try, in order, to autoload fqn.
+func (al *composerAutoload) files(fqn string) []string {
+	var out []string
+	for _, m := range al.psr4 {
+		if !strings.HasPrefix(fqn, m.prefix) {
+			continue
+		}
+		rel := strings.ReplaceAll(strings.TrimPrefix(fqn, m.prefix), `\`, "/") + ".php"
+		for _, d := range m.dirs {
+			out = append(out, absPath(filepath.Join(al.dir, d, rel)))
+		}
+	}
+	for _, m := range al.psr0 {
+		if !strings.HasPrefix(fqn, m.prefix) {
+			continue
+		}
+		// PSR-0: namespace separators become directories, as do underscores in the class name
+		ns, class := phpTrimLast(fqn), phpLast(fqn)
+		rel := strings.ReplaceAll(class, "_", "/") + ".php"
+		if ns != "" {
+			rel = strings.ReplaceAll(ns, `\`, "/") + "/" + rel
+		}
+		for _, d := range m.dirs {
+			out = append(out, absPath(filepath.Join(al.dir, d, rel)))
+		}
+	}
+	return out
+}
+
+// phpLast returns the final segment of a backslash-separated name.
+func phpLast(name string) string {
+	return name[strings.LastIndex(name, `\`)+1:]
+}
+
+// phpTrimLast drops the final segment of a backslash-separated name.
+func phpTrimLast(name string) string {
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package xref
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// phpFiles is a Composer project with a PSR-4 mapping and a PSR-0 one, copies of its classes
+// the autoloader wouldn't load, and a controller that names them through a group use, an alias,
+// a fully qualified name and a relative one.
+var phpFiles = fstest.MapFS{
+	"composer.json":            {Data: []byte(`{"autoload": {"psr-4": {"App\\": "src/"}}, "autoload-dev": {"psr-0": {"Legacy_": "legacy/"}}}`)},
+	"src/Models/User.php":      {Data: []byte("<?php\nnamespace App\\Models;\nclass User { function save() {} }\n")},
+	"src/Models/Post.php":      {Data: []byte("<?php\nnamespace App\\Models;\nclass Post {}\n")},
+	"src/Http/Models/Form.php": {Data: []byte("<?php\nnamespace App\\Http\\Models;\nclass Form {}\n")},
+	"legacy/Legacy/Mailer.php": {Data: []byte("<?php\nclass Legacy_Mailer {}\n")},
+	"copies/all.php":           {Data: []byte("<?php\nnamespace App\\Models { class User {} class Post {} }\nnamespace { class Legacy_Mailer {} }\n")},
+	"src/Http/Controller.php": {Data: []byte(`<?php
+namespace App\Http;
+use App\Models\{User, Post as P};
+function run() {
+    $u = new User(); $p = new P(); $m = new \Legacy_Mailer(); $f = new Models\Form();
+}
+`)},
+}
+
+// TestPhpResolveAt checks PHP symbol IDs under namespace statements and blocks, and that names
+// resolve through use statements and the current namespace to the file Composer's PSR-4 or
+// PSR-0 autoloader would load, ahead of same-named definitions elsewhere. It then edits
+// composer.json and checks that the ranking follows.
+func TestPhpResolveAt(t *testing.T) {
+	e, err := New(WithLanguages("php"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := maps.Clone(phpFiles)
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := e.GetDefinitions()
+	for _, sid := range []string{
+		`php::src/Models/User.php::App.Models`,
+		`php::src/Models/User.php::App\Models.User`,
+		`php::src/Models/User.php::App\Models\User.save`,
+		`php::copies/all.php::App.Models`,
+		`php::copies/all.php::App\Models.Post`,
+		`php::copies/all.php::Legacy_Mailer`,
+	} {
+		if _, ok := defs[sid]; !ok {
+			t.Errorf("no definition %s", sid)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		line, col int
+		want      []string // candidate files, in order
+	}{
+		{"group use", 5, 14, []string{"src/Models/User.php", "copies/all.php"}},
+		{"aliased group use", 5, 31, []string{"src/Models/Post.php", "copies/all.php"}},
+		{"fully qualified, PSR-0", 5, 46, []string{"legacy/Legacy/Mailer.php", "copies/all.php"}},
+		{"relative to the namespace", 5, 79, []string{"src/Http/Models/Form.php"}},
+	}
+	candidates := func(line, col int) []string {
+		t.Helper()
+		_, cands, err := e.FindDefinitionAt("src/Http/Controller.php", line, col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, sid := range cands {
+			d, _ := e.Index.Definition(sid)
+			files = append(files, d.File)
+		}
+		return files
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := candidates(tt.line, tt.col); !slices.Equal(got, tt.want) {
+				t.Errorf("candidates in %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	fsys["composer.json"] = &fstest.MapFile{Data: []byte(`{"autoload": {"psr-4": {"App\\": "src/"}}}`)}
+	if got, want := candidates(5, 46), []string{"copies/all.php", "legacy/Legacy/Mailer.php"}; !slices.Equal(got, want) {
+		t.Errorf("after dropping the PSR-0 mapping: candidates in %q, want %q", got, want)
+	}
+}
+
+// TestPhpComposerCache checks that the cached autoload config of a composer.json on disk
+// follows edits to it, and is dropped when the file is removed.
+func TestPhpComposerCache(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for name, f := range phpFiles {
+		files[name] = string(f.Data)
+	}
+	writeTree(t, dir, files)
+	e, err := New(WithLanguages("php"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexPaths(dir); err != nil {
+		t.Fatal(err)
+	}
+	php := e.Adapters[0].(*phpAdapter)
+	controller, composer := filepath.Join(dir, "src/Http/Controller.php"), filepath.Join(dir, "composer.json")
+	file := func() string {
+		t.Helper()
+		d, _, err := e.FindDefinitionAt(controller, 5, 46)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.File
+	}
+
+	if got, want := file(), filepath.Join(dir, "legacy/Legacy/Mailer.php"); got != want {
+		t.Errorf("Legacy_Mailer in %s, want %s", got, want)
+	}
+	if _, ok := php.composer[absPath(composer)]; !ok {
+		t.Fatalf("composer.json not cached: %v", slices.Collect(maps.Keys(php.composer)))
+	}
+
+	writeTree(t, dir, map[string]string{"composer.json": `{"autoload": {"psr-4": {"App\\": "src/"}}}`})
+	later := time.Now().Add(time.Minute) // A new stamp even where the clock is coarse
+	if err := os.Chtimes(composer, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file(), filepath.Join(dir, "copies/all.php"); got != want {
+		t.Errorf("after dropping the PSR-0 mapping: Legacy_Mailer in %s, want %s", got, want)
+	}
+
+	if err := os.Remove(composer); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveFile(composer); err != nil {
+		t.Fatal(err)
+	}
+	if len(php.composer) != 0 {
+		t.Errorf("cached after removal: %v", slices.Collect(maps.Keys(php.composer)))
+	}
+}
@@ -0,0 +1,229 @@
+package xref
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/protobuf"
+)
+
+type protoAdapter struct {
+	symbolQueries
+}
+
+// newProtoAdapter creates a Protocol Buffers adapter with pre-compiled tree-sitter queries.
+func newProtoAdapter() (LanguageAdapter, error) {
+	return (&protoAdapter{}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src.
+func (p *protoAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	sq, err := loadSymbolQueries(src, "proto", protobuf.GetLanguage())
+	if err != nil {
+		return nil, err
+	}
+	return &protoAdapter{symbolQueries: sq}, nil
+}
+
+func (p *protoAdapter) Lang() string { return "proto" }
+func (p *protoAdapter) CanHandle(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".proto")
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (p *protoAdapter) Language(_ string) *sitter.Language { return protobuf.GetLanguage() }
+
+func (p *protoAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	lang := protobuf.GetLanguage()
+	if lang == nil {
+		return nil, nil // Language not available
+	}
+	return parse("proto", lang, src)
+}
+
+// protoDefs maps the name captures of queries/proto/defs.scm to the kinds of definition they name.
+var protoDefs = []DefCapture{
+	{Name: "pkgname", Kind: "package"},
+	{Name: "mname", Kind: "message"},
+	{Name: "ename", Kind: "enum"},
+	{Name: "vname", Kind: "const"},
+	{Name: "oname", Kind: "oneof"},
+	{Name: "sname", Kind: "service"},
+	{Name: "rname", Kind: "rpc"},
+	{Name: "fname", Kind: "field"},
+}
+
+// Extract analyzes a .proto file's syntax tree and extracts all symbols.
+// Symbols are keyed by their fully qualified proto name (package plus enclosing messages, enums
+// and services), e.g. acme.users.v1.User.user_id. Imports are recorded by path, and file options
+// under "option:<name>" so the generated-code linker can read go_package.
+func (p *protoAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	fi := &FileIndex{Lang: "proto", File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	root := tree.RootNode()
+	qp := p.queries.run(src, root) // One walk of the tree for all queries
+
+	pkg, imports, options := p.readHeader(src, qp)
+	fi.Package = pkg
+	for _, imp := range imports {
+		fi.Imports[imp] = imp
+	}
+	for name, value := range options {
+		fi.Imports["option:"+name] = value
+	}
+
+	qp.each(p.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		name, d := matchDef(src, capts, p.qDefs, protoDefs)
+		kind := d.Kind
+		if name == "" {
+			return
+		}
+		var container string
+		if kind != "package" {
+			container = protoContainer(src, nodeByName(capts, p.qDefs, "rng"), pkg)
+		}
+		rng := rangeByName(src, capts, p.qDefs, "rng")
+		sid := symbolID("proto", path, container, name)
+		fi.Defs[sid] = DefLocation{Lang: "proto", File: path, Rng: rng, Name: name, Kind: kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(p.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, p.qRefs, "id")
+		rng := rangeByName(src, capts, p.qRefs, "rng")
+		if id != "" {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: id, KindHint: "ref", Rng: rng})
+		}
+	})
+	return fi, nil
+}
+
+// ResolveAt resolves a type reference to candidate symbol IDs for "go to definition".
+// It follows protobuf scoping: a (partially) qualified name is looked up from the innermost
+// enclosing message outwards to the package root, and a leading dot makes it fully qualified.
+// Unscoped matches follow, from this file and imported files first.
+func (p *protoAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	if occ.SymbolID != "" {
+		return []string{occ.SymbolID}
+	}
+	typed, absolute := occ.Name, false
+	var scopes []string
+	if tree, err := p.Parse(path, src); err == nil && tree != nil {
+		root := tree.RootNode()
+		pkg, _, _ := p.readHeader(src, lazyPass(src, root))
+		pt := sitter.Point{Row: uint32(occ.Rng.Start.Line - 1), Column: uint32(occ.Rng.Start.Col - 1)}
+		if n := root.NamedDescendantForPointRange(pt, pt); n != nil {
+			if t := n.Parent(); t != nil && t.Type() == "message_or_enum_type" {
+				typed, absolute = protoTypedPrefix(src, t, n)
+			}
+			for s := protoContainer(src, n, pkg); s != ""; s = trimLastDotted(s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	scopes = append(scopes, "")
+
+	imports := idx.Imports(path)
+	rank := func(sid string) int {
+		q := sidQualified(sid)
+		if absolute {
+			if q == typed {
+				return 0
+			}
+		} else {
+			for i, s := range scopes {
+				if q == strings.TrimPrefix(s+"."+typed, ".") {
+					return i
+				}
+			}
+		}
+		d, _ := idx.Definition(sid)
+		file := d.File
+		if file == path {
+			return len(scopes)
+		}
+		for imp := range imports {
+			if file == imp || strings.HasSuffix(file, "/"+imp) {
+				return len(scopes) + 1
+			}
+		}
+		return len(scopes) + 2
+	}
+	out := idx.Lookup("proto", occ.Name)
+	ranks := make(map[string]int, len(out))
+	for _, sid := range out {
+		ranks[sid] = rank(sid) // Once per candidate: ranking reads its definition
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := ranks[out[i]], ranks[out[j]]
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// readHeader returns the package, import paths and string-valued file options of a .proto file.
+func (p *protoAdapter) readHeader(src []byte, qp *queryPass) (string, []string, map[string]string) {
+	var pkg string
+	var imports []string
+	options := map[string]string{}
+	qp.each(p.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		switch {
+		case getByName(src, capts, p.qImport, "package") != "":
+			pkg = getByName(src, capts, p.qImport, "package")
+		case getByName(src, capts, p.qImport, "path") != "":
+			imports = append(imports, strings.Trim(getByName(src, capts, p.qImport, "path"), `"'`))
+		case getByName(src, capts, p.qImport, "option") != "":
+			options[getByName(src, capts, p.qImport, "option")] = strings.Trim(getByName(src, capts, p.qImport, "value"), `"'`)
+		}
+	})
+	return pkg, imports, options
+}
+
+// protoContainer returns the qualified scope of a node: the package followed by enclosing
+// messages, enums and services. Oneofs don't open a scope; their fields belong to the message.
+func protoContainer(src []byte, decl *sitter.Node, pkg string) string {
+	var parts []string
+	for n := decl.Parent(); n != nil; n = n.Parent() {
+		var nameNode string
+		switch n.Type() {
+		case "message":
+			nameNode = "message_name"
+		case "enum":
+			nameNode = "enum_name"
+		case "service":
+			nameNode = "service_name"
+		default:
+			continue
+		}
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			if ch := n.NamedChild(i); ch.Type() == nameNode {
+				parts = append([]string{ch.Content(src)}, parts...)
+				break
+			}
+		}
+	}
+	if pkg != "" {
+		parts = append([]string{pkg}, parts...)
+	}
+	return strings.Join(parts, ".")
+}
+
+// protoTypedPrefix returns the dotted type name written up to and including identifier id,
+// and whether it was fully qualified with a leading dot (.acme.common.Tag).
+func protoTypedPrefix(src []byte, typ, id *sitter.Node) (string, bool) {
+	var parts []string
+	for i := 0; i < int(typ.NamedChildCount()); i++ {
+		ch := typ.NamedChild(i)
+		parts = append(parts, ch.Content(src))
+		if ch.StartByte() == id.StartByte() {
+			break
+		}
+	}
+	return strings.Join(parts, "."), typ.ChildCount() > 0 && typ.Child(0).Type() == "."
+}
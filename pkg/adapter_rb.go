@@ -1 +1,26 @@
 package xref
+
+import "github.com/smacker/go-tree-sitter/ruby"
+
+// rbSpec declares Ruby: methods keyed by their class or module, top-level methods, classes,
+// modules, constants and variables, and files loaded with require. Locals of methods and
+// lambdas, parameters included, are scoped to them; blocks share the enclosing method's.
+var rbSpec = AdapterSpec{
+	Lang:       "rb",
+	Extensions: []string{".rb", ".rake", ".gemspec"},
+	Filenames:  []string{"Rakefile", "Gemfile"},
+	Grammar:    ruby.GetLanguage(),
+	Defs: []DefCapture{
+		{Name: "mname", Kind: "method", Container: "owner"},
+		{Name: "fname", Kind: "func"},
+		{Name: "cname", Kind: "class"},
+		{Name: "modname", Kind: "module"},
+		{Name: "kname", Kind: "const"},
+		{Name: "vname", Kind: "var"},
+	},
+	Imports: ImportSpec{DefaultAlias: ImportPath},
+}
+
+func newRbAdapter() (LanguageAdapter, error) {
+	return NewSpecAdapter(rbSpec)
+}
@@ -0,0 +1,394 @@
+package xref
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// AdapterSpec declares a language for NewSpecAdapter: the files it handles, its grammar, and
+// what the captures of its queries mean. The queries are defs.scm and refs.scm, plus
+// imports.scm and scopes.scm if present. They are read from Queries or, when that is nil, from
+// the embedded queries/<Lang> folder, which ReloadQueries can override.
+//
+// defs.scm matches definitions: a name capture listed in Defs, and Range spanning the
+// definition. refs.scm captures each referenced name as Ref, spanned by Range. imports.scm is
+// described by Imports. scopes.scm captures @scope nodes (function bodies, lambdas, ...):
+// definitions inside a scope are local to it, and references inside resolve to the innermost
+// enclosing scope's definition of the name. Other references resolve to a top-level definition
+// in the same file, else to any file's.
+type AdapterSpec struct {
+	Lang       string   // the language of symbols and symbol IDs, e.g. "rb"
+	Extensions []string // file name suffixes, matched case-insensitively, e.g. ".rb"
+	Filenames  []string // whole file names, e.g. "Rakefile"
+	Grammar    *sitter.Language
+	Queries    fs.FS // holding the .scm files; nil for the embedded queries/<Lang>
+
+	// Defs maps the name captures of defs.scm to kinds. A match defines the name of the first
+	// capture, in this order, that it has.
+	Defs  []DefCapture
+	Ref   string // capture of a referenced name in refs.scm; "id" if empty
+	Range string // capture spanning a definition or reference; "rng" if empty
+
+	Imports ImportSpec
+}
+
+// DefCapture maps a name capture of defs.scm to the kind of definition it names.
+type DefCapture struct {
+	Name string // capture holding the defined name, e.g. "fname"
+	Kind string // DefLocation.Kind, e.g. "func"
+	// Container optionally names a capture holding the enclosing type (a method's class),
+	// which then qualifies the symbol ID.
+	Container string
+}
+
+// matchDef returns the name a defs.scm match defines and the first of defs, in order, whose
+// capture holds it. The name is "" if none does.
+func matchDef(src []byte, capts []sitter.QueryCapture, q *sitter.Query, defs []DefCapture) (string, DefCapture) {
+	for _, d := range defs {
+		if name := getByName(src, capts, q, d.Name); name != "" {
+			return name, d
+		}
+	}
+	return "", DefCapture{}
+}
+
+// ImportSpec describes the captures of imports.scm. An import binds an alias (the Alias
+// capture, or one derived from the path) to the imported path in FileIndex.Imports.
+type ImportSpec struct {
+	Path  string // capture of the imported path or module; "path" if empty
+	Alias string // capture of the name the import binds; "alias" if empty
+	Range string // capture spanning the import; "rng" if empty
+	Trim  string // characters trimmed from both ends of the path, e.g. quotes
+
+	// DefaultAlias derives the alias of imports without an Alias capture.
+	DefaultAlias ImportAlias
+	Separator    string // between the components of a path, for DefaultAlias
+}
+
+// ImportAlias is how an import without an alias capture binds a name.
+type ImportAlias int
+
+const (
+	ImportUnbound ImportAlias = iota // it binds nothing and is skipped
+	ImportFirst                      // the first component: import a.b binds a (Python)
+	ImportLast                       // the last component: import "a/b" binds b (Go)
+	ImportPath                       // the whole path (Ruby's require)
+)
+
+// alias returns the name an import of path binds.
+func (s ImportSpec) alias(path string) string {
+	sep := s.Separator
+	if sep == "" {
+		sep = "/"
+	}
+	switch s.DefaultAlias {
+	case ImportFirst:
+		first, _, _ := strings.Cut(path, sep)
+		return first
+	case ImportLast:
+		return path[strings.LastIndex(path, sep)+len(sep):]
+	case ImportPath:
+		return path
+	}
+	return ""
+}
+
+// specAdapter is a LanguageAdapter driven by an AdapterSpec.
+type specAdapter struct {
+	spec                          AdapterSpec
+	queries                       *querySet
+	qDefs, qRefs, qImport, qScope *sitter.Query // qImport and qScope are nil without their files
+}
+
+// NewSpecAdapter creates an adapter for the language declared by spec, compiling its queries.
+func NewSpecAdapter(spec AdapterSpec) (LanguageAdapter, error) {
+	if spec.Lang == "" || spec.Grammar == nil {
+		return nil, errors.New("xref: adapter spec needs a Lang and a Grammar")
+	}
+	if len(spec.Defs) == 0 {
+		return nil, fmt.Errorf("xref: adapter spec %s maps no definition captures", spec.Lang)
+	}
+	withDefault := func(s *string, def string) {
+		if *s == "" {
+			*s = def
+		}
+	}
+	withDefault(&spec.Ref, "id")
+	withDefault(&spec.Range, "rng")
+	withDefault(&spec.Imports.Path, "path")
+	withDefault(&spec.Imports.Alias, "alias")
+	withDefault(&spec.Imports.Range, "rng")
+	return (&specAdapter{spec: spec}).withQueries(nil)
+}
+
+// withQueries returns a copy of the adapter with its queries read from src, or from the spec's
+// own Queries if set.
+func (a *specAdapter) withQueries(src *querySource) (LanguageAdapter, error) {
+	if a.spec.Queries != nil {
+		var err error
+		if src, err = specQuerySource(a.spec); err != nil {
+			return nil, err
+		}
+	}
+	files := []string{"defs.scm", "refs.scm"}
+	for _, file := range []string{"imports.scm", "scopes.scm"} {
+		if src.has(a.spec.Lang, file) {
+			files = append(files, file)
+		}
+	}
+	qs, err := loadQuerySet(src, a.spec.Lang, a.spec.Grammar, files...)
+	if err != nil {
+		return nil, err
+	}
+	na := &specAdapter{spec: a.spec, queries: qs, qDefs: qs.parts[0], qRefs: qs.parts[1]}
+	for i, file := range files[2:] {
+		switch file {
+		case "imports.scm":
+			na.qImport = qs.parts[2+i]
+		case "scopes.scm":
+			na.qScope = qs.parts[2+i]
+		}
+	}
+	return na, nil
+}
+
+// specQuerySource reads the .scm files of a spec's Queries.
+func specQuerySource(spec AdapterSpec) (*querySource, error) {
+	src := &querySource{files: map[string][]byte{}, version: querySetVersion(), noEmbedded: true}
+	for _, file := range []string{"defs.scm", "refs.scm", "imports.scm", "scopes.scm"} {
+		b, err := fs.ReadFile(spec.Queries, file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s queries: %w", spec.Lang, err)
+		}
+		src.files[spec.Lang+"/"+file] = b
+	}
+	return src, nil
+}
+
+func (a *specAdapter) querySets() []*querySet { return []*querySet{a.queries} }
+
+func (a *specAdapter) Lang() string { return a.spec.Lang }
+
+func (a *specAdapter) CanHandle(path string) bool {
+	l := strings.ToLower(path)
+	for _, ext := range a.spec.Extensions {
+		if strings.HasSuffix(l, strings.ToLower(ext)) {
+			return true
+		}
+	}
+	base := filepath.Base(path)
+	for _, name := range a.spec.Filenames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Language returns the tree-sitter grammar, for incremental reparsing of open documents.
+func (a *specAdapter) Language(_ string) *sitter.Language { return a.spec.Grammar }
+
+func (a *specAdapter) Parse(_ string, src []byte) (*sitter.Tree, error) {
+	return parse(a.spec.Lang, a.spec.Grammar, src)
+}
+
+// Extract runs the spec's queries in one pass. Definitions in a scope get the scope's position
+// in their symbol ID, so same-named locals of different scopes stay apart, and references are
+// resolved to them on the spot.
+func (a *specAdapter) Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error) {
+	lang, spec := a.spec.Lang, a.spec
+	fi := &FileIndex{Lang: lang, File: path, Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+	if tree == nil {
+		return fi, nil // Return empty index if parsing failed
+	}
+	qp := a.queries.run(src, tree.RootNode()) // One walk of the tree for all queries
+
+	if a.qImport != nil {
+		im := spec.Imports
+		qp.each(a.qImport, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+			ipath := strings.Trim(getByName(src, capts, a.qImport, im.Path), im.Trim)
+			if ipath == "" {
+				return
+			}
+			alias := getByName(src, capts, a.qImport, im.Alias)
+			if alias == "" {
+				alias = im.alias(ipath)
+			}
+			if alias != "" {
+				fi.Imports[alias] = ipath
+				fi.Occurrences = append(fi.Occurrences, Occurrence{Name: alias, KindHint: "import", Rng: rangeByName(src, capts, a.qImport, im.Range)})
+			}
+		})
+	}
+
+	var sc *specScopes
+	if a.qScope != nil {
+		sc = &specScopes{locals: map[int]map[string]string{}, names: map[Range]bool{}}
+		qp.each(a.qScope, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+			if r := rangeByName(src, capts, a.qScope, "scope"); r != (Range{}) {
+				sc.ranges = append(sc.ranges, r)
+			}
+		})
+		sc.nest()
+	}
+
+	qp.each(a.qDefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		name, d := matchDef(src, capts, a.qDefs, spec.Defs)
+		if name == "" {
+			return
+		}
+		var container string
+		if d.Container != "" {
+			container = getByName(src, capts, a.qDefs, d.Container)
+		}
+		rng := rangeByName(src, capts, a.qDefs, spec.Range)
+		scope := -1
+		if sc != nil {
+			if scope = sc.enclosing(rng); scope >= 0 {
+				container = strings.TrimSuffix(scopeContainer(sc.ranges[scope])+"."+container, ".")
+			}
+		}
+		sid := symbolID(lang, path, container, name)
+		if scope >= 0 {
+			if sc.locals[scope] == nil {
+				sc.locals[scope] = map[string]string{}
+			}
+			if _, ok := sc.locals[scope][name]; !ok {
+				sc.locals[scope][name] = sid
+			}
+			sc.names[rangeByName(src, capts, a.qDefs, d.Name)] = true
+		}
+		fi.Defs[sid] = DefLocation{Lang: lang, File: path, Rng: rng, Name: name, Kind: d.Kind}
+		fi.Occurrences = append(fi.Occurrences, Occurrence{Name: name, KindHint: "def", Rng: rng, SymbolID: sid})
+	})
+
+	qp.each(a.qRefs, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		id := getByName(src, capts, a.qRefs, spec.Ref)
+		if id == "" {
+			return
+		}
+		occ := Occurrence{Name: id, KindHint: "ref", Rng: rangeByName(src, capts, a.qRefs, spec.Range)}
+		if sc != nil {
+			if sid := sc.resolve(id, occ.Rng); sid != "" {
+				occ.SymbolID = sid
+				if !sc.names[occ.Rng] { // Not the definition's own name
+					fi.Refs[sid] = append(fi.Refs[sid], RefLocation{Lang: lang, File: path, Rng: occ.Rng})
+				}
+			}
+		}
+		fi.Occurrences = append(fi.Occurrences, occ)
+	})
+	return fi, nil
+}
+
+// ResolveAt returns the symbol a scoped reference was resolved to during extraction, else the
+// first top-level definition of the name in the file, else every top-level definition of it.
+func (a *specAdapter) ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string {
+	if a.qScope == nil {
+		if local := idx.FileLookup(path, a.spec.Lang, occ.Name); len(local) > 0 {
+			return local[:1]
+		}
+		return idx.Lookup(a.spec.Lang, occ.Name)
+	}
+	if occ.SymbolID != "" {
+		return []string{occ.SymbolID}
+	}
+	if local := a.topLevel(idx.FileLookup(path, a.spec.Lang, occ.Name)); len(local) > 0 {
+		return local[:1]
+	}
+	return a.topLevel(idx.Lookup(a.spec.Lang, occ.Name))
+}
+
+// topLevel drops the definitions local to a scope.
+func (a *specAdapter) topLevel(sids []string) []string {
+	out := sids[:0:0]
+	for _, sid := range sids {
+		if !strings.Contains(sid, "::@") {
+			out = append(out, sid)
+		}
+	}
+	return out
+}
+
+// scopeContainer names a scope in the symbol IDs of its definitions: "@line:col" of its start.
+// Paths don't contain "::@", so topLevel can tell local symbols by it.
+func scopeContainer(r Range) string {
+	return fmt.Sprintf("@%d:%d", r.Start.Line, r.Start.Col)
+}
+
+// specScopes are the scopes of a file, sorted by start (enclosing ones first), with the
+// definitions local to each.
+type specScopes struct {
+	ranges []Range
+	outer  []int                     // index of the innermost scope enclosing each, or -1
+	locals map[int]map[string]string // scope -> name -> symbol ID of its first definition
+	names  map[Range]bool            // name ranges of local definitions
+}
+
+// nest sorts the scopes and links each to the innermost one enclosing it.
+func (s *specScopes) nest() {
+	sort.SliceStable(s.ranges, func(i, j int) bool {
+		a, b := s.ranges[i], s.ranges[j]
+		if a.Start != b.Start {
+			return beforeOrEq(a.Start, b.Start)
+		}
+		return !beforeOrEq(a.End, b.End) // Enclosing (longer) scopes first
+	})
+	s.outer = make([]int, len(s.ranges))
+	var stack []int
+	for i, r := range s.ranges {
+		for len(stack) > 0 && !rangeWithin(r, s.ranges[stack[len(stack)-1]]) {
+			stack = stack[:len(stack)-1]
+		}
+		s.outer[i] = -1
+		if len(stack) > 0 {
+			s.outer[i] = stack[len(stack)-1]
+		}
+		stack = append(stack, i)
+	}
+}
+
+// innermost returns the innermost scope containing r, or -1. Scopes are syntax nodes, so they
+// nest: walking out from the last scope starting before r finds it.
+func (s *specScopes) innermost(r Range) int {
+	i := sort.Search(len(s.ranges), func(i int) bool { return !beforeOrEq(s.ranges[i].Start, r.Start) }) - 1
+	for i >= 0 && !rangeWithin(r, s.ranges[i]) {
+		i = s.outer[i]
+	}
+	return i
+}
+
+// enclosing returns the scope a definition spanning r belongs to: the innermost scope
+// containing it other than the definition itself (a function is not local to its own body).
+func (s *specScopes) enclosing(r Range) int {
+	i := s.innermost(r)
+	for i >= 0 && s.ranges[i] == r {
+		i = s.outer[i]
+	}
+	return i
+}
+
+// resolve returns the local definition a reference to name at r resolves to, searching from the
+// innermost enclosing scope outwards, or "".
+func (s *specScopes) resolve(name string, r Range) string {
+	for i := s.innermost(r); i >= 0; i = s.outer[i] {
+		if sid, ok := s.locals[i][name]; ok {
+			return sid
+		}
+	}
+	return ""
+}
+
+// rangeWithin reports whether r lies within outer.
+func rangeWithin(r, outer Range) bool {
+	return beforeOrEq(outer.Start, r.Start) && beforeOrEq(r.End, outer.End)
+}
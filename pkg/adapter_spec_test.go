@@ -0,0 +1,103 @@
+package xref
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/smacker/go-tree-sitter/lua"
+)
+
+// luaSpec declares Lua purely as a spec, with queries of its own: functions and variables,
+// locals scoped to functions, and modules bound by local x = require("x"). The grammar starts
+// a function where the statement before it ends, hence its scope positions.
+var luaSpec = AdapterSpec{
+	Lang:       "lua",
+	Extensions: []string{".lua"},
+	Grammar:    lua.GetLanguage(),
+	Queries: fstest.MapFS{
+		"defs.scm": {Data: []byte(`((function_statement name: [(identifier) @fname (function_name (identifier) @fname)]) @rng)
+(parameter_list (identifier) @vname @rng)
+((variable_declaration name: (variable_declarator (identifier) @vname)) @rng)
+`)},
+		"refs.scm":    {Data: []byte("((identifier) @id) @rng\n")},
+		"imports.scm": {Data: []byte(`((variable_declaration name: (variable_declarator (identifier) @alias) value: (function_call prefix: (identifier) @_f args: (function_arguments (string) @path))) @rng (#eq? @_f "require"))` + "\n")},
+		"scopes.scm":  {Data: []byte("(function_statement) @scope\n")},
+	},
+	Defs:    []DefCapture{{Name: "fname", Kind: "func"}, {Name: "vname", Kind: "var"}},
+	Imports: ImportSpec{Trim: `"'`},
+}
+
+// luaFiles has a module import, same-named locals in two functions, and a function called
+// from another file.
+var luaFiles = fstest.MapFS{
+	"lib.lua": {Data: []byte("function add(a, b)\n  return a + b\nend\nfunction greet()\nend\n")},
+	"main.lua": {Data: []byte(`local json = require("json")
+local function add(a, b)
+  local s = a + b
+  return s
+end
+local function twice(s)
+  return add(s, s)
+end
+print(twice(2), json, greet())
+`)},
+}
+
+// TestSpecAdapter defines an adapter for a language xref doesn't ship, Lua, from nothing but an
+// AdapterSpec, and checks what it extracts and how names resolve.
+func TestSpecAdapter(t *testing.T) {
+	a, err := NewSpecAdapter(luaSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithLanguages(), WithAdapter(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(luaFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	var defs []string
+	for sid, d := range e.GetDefinitions() {
+		defs = append(defs, strings.TrimPrefix(sid, "lua::")+" "+d.Kind)
+	}
+	slices.Sort(defs)
+	want := []string{
+		"lib.lua::@1:1.a var", "lib.lua::@1:1.b var", "lib.lua::add func", "lib.lua::greet func",
+		"main.lua::@1:29.a var", "main.lua::@1:29.b var", "main.lua::@1:29.s var", "main.lua::@5:4.s var",
+		"main.lua::add func", "main.lua::json var", "main.lua::twice func",
+	}
+	if !slices.Equal(defs, want) {
+		t.Errorf("definitions\n%q\nwant\n%q", defs, want)
+	}
+	if imps := e.Index.Imports("main.lua"); !maps.Equal(imps, map[string]string{"json": "json"}) {
+		t.Errorf("imports %v, want json", imps)
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      string // symbol ID, without the "lua::" prefix
+	}{
+		{"local of a function", "main.lua", 4, 10, "main.lua::@1:29.s"},
+		{"parameter of another function", "main.lua", 7, 14, "main.lua::@5:4.s"},
+		{"top-level function of the same file", "main.lua", 7, 10, "main.lua::add"},
+		{"top-level function of another file", "main.lua", 9, 23, "lib.lua::greet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cands) == 0 || cands[0] != "lua::"+tt.want {
+				t.Errorf("candidates %q, want %s first", cands, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package xref
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+)
+
+// cgoInjector exposes the C preamble of cgo files: the comment immediately preceding import "C".
+// The region keeps the host layout, with comment markers and #cgo directives blanked out, so
+// positions in the C code map one-to-one onto the Go file.
+type cgoInjector struct{}
+
+func (cgoInjector) CanHandle(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".go")
+}
+
+func (cgoInjector) Regions(_ string, src []byte) ([]Region, error) {
+	if !bytes.Contains(src, []byte(`"C"`)) {
+		return nil, nil // Not a cgo file; skip parsing
+	}
+	tree, err := parse("go", golang.GetLanguage(), src)
+	if err != nil {
+		return nil, err
+	}
+	root := tree.RootNode()
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		imp := root.NamedChild(i)
+		if imp.Type() != "import_declaration" || !importsC(src, imp) {
+			continue
+		}
+		// Collect the comment group directly above the import (no blank line in between)
+		first, last := -1, i
+		for j := i - 1; j >= 0; j-- {
+			c := root.NamedChild(j)
+			if c.Type() != "comment" || root.NamedChild(last).StartPoint().Row-c.EndPoint().Row > 1 {
+				break
+			}
+			first, last = j, j
+		}
+		if first < 0 {
+			return nil, nil // import "C" without a preamble
+		}
+		start, end := root.NamedChild(first), root.NamedChild(i-1)
+		body := bytes.Clone(src[start.StartByte():end.EndByte()])
+		for j := first; j < i; j++ {
+			c := root.NamedChild(j)
+			blankCgoComment(body[c.StartByte()-start.StartByte() : c.EndByte()-start.StartByte()])
+		}
+		sp := start.StartPoint()
+		return []Region{{Lang: "c", Start: Pos{int(sp.Row) + 1, int(sp.Column) + 1}, Src: body}}, nil
+	}
+	return nil, nil
+}
+
+// importsC reports whether an import declaration imports the pseudo-package "C".
+func importsC(src []byte, imp *sitter.Node) bool {
+	for i := 0; i < int(imp.NamedChildCount()); i++ {
+		spec := imp.NamedChild(i)
+		if spec.Type() == "import_spec_list" {
+			return importsC(src, spec)
+		}
+		if p := spec.ChildByFieldName("path"); p != nil && p.Content(src) == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// blankCgoComment overwrites the comment markers (//, /* and */) and #cgo directive lines of a
+// comment with spaces in place, leaving the C code at its original columns.
+func blankCgoComment(c []byte) {
+	if bytes.HasPrefix(c, []byte("/*")) {
+		copy(c, "  ")
+		if bytes.HasSuffix(c, []byte("*/")) {
+			copy(c[len(c)-2:], "  ")
+		}
+	} else {
+		copy(c, "  ")
+	}
+	for off := 0; off < len(c); {
+		line := c[off:]
+		if n := bytes.IndexByte(line, '\n'); n >= 0 {
+			line = line[:n]
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("#cgo")) {
+			for k := range line {
+				line[k] = ' '
+			}
+		}
+		off += len(line) + 1
+	}
+}
+
+// cgoName returns the C name referenced by C.xxx at the occurrence (struct_, union_ and enum_
+// prefixes stripped from C.struct_foo and friends), and whether the occurrence is such a reference.
+func cgoName(src []byte, occ Occurrence) (string, bool) {
+	if !bytes.Contains(src, []byte(`"C"`)) {
+		return "", false
+	}
+	tree, err := parse("go", golang.GetLanguage(), src)
+	if err != nil || tree == nil {
+		return "", false
+	}
+	pt := sitter.Point{Row: uint32(occ.Rng.Start.Line - 1), Column: uint32(occ.Rng.Start.Col - 1)}
+	n := tree.RootNode().NamedDescendantForPointRange(pt, pt)
+	if n == nil || n.Parent() == nil {
+		return "", false
+	}
+	var pkg *sitter.Node
+	switch parent := n.Parent(); parent.Type() {
+	case "selector_expression":
+		pkg = parent.ChildByFieldName("operand")
+	case "qualified_type":
+		pkg = parent.ChildByFieldName("package")
+	}
+	if pkg == nil || pkg.Content(src) != "C" {
+		return "", false
+	}
+	name := n.Content(src)
+	for _, prefix := range []string{"struct_", "union_", "enum_"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return rest, true
+		}
+	}
+	return name, true
+}
+
+// resolveCgo returns the C definitions a C.xxx reference in a Go file can name: those in the
+// file's own preamble and in C files and headers of the package directory. Definitions come
+// before declarations (prototypes), and the preamble before the directory.
+func resolveCgo(file, name string, idx Store) []string {
+	dir := filepath.Dir(file)
+	var out []string
+	for _, sid := range idx.Lookup("c", name) {
+		if d, _ := idx.Definition(sid); d.File == file || filepath.Dir(d.File) == dir {
+			out = append(out, sid)
+		}
+	}
+	rank := func(sid string) int {
+		d, _ := idx.Definition(sid)
+		r := 0
+		if d.Decl {
+			r += 2
+		}
+		if d.File != file {
+			r++
+		}
+		return r
+	}
+	ranks := make(map[string]int, len(out))
+	for _, sid := range out {
+		ranks[sid] = rank(sid) // Once per candidate: ranking reads its definition
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := ranks[out[i]], ranks[out[j]]
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
@@ -0,0 +1,75 @@
+package xref
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// cgoFiles is a cgo package whose preamble, split over a block and a line comment, defines C
+// functions and includes a header declaring what a C file of the package defines. Another
+// directory defines the same C names.
+var cgoFiles = fstest.MapFS{
+	"foo/foo.h":    {Data: []byte("struct point { int x, y; };\nint triple(int x);\n")},
+	"foo/triple.c": {Data: []byte("#include \"foo.h\"\nint triple(int x) { return 3*x; }\n")},
+	"bar/bar.c":    {Data: []byte("int triple(int x) { return x+x+x; }\nint add(int a, int b) { return a + b; }\n")},
+	"foo/foo.go": {Data: []byte(`package foo
+
+/*
+#include "foo.h"
+static int add(int a, int b) { return a + b; }
+*/
+// #cgo LDFLAGS: -lm
+// int twice(int x) { return 2*x; }
+import "C"
+
+func F() { C.add(1, 2); var s C.struct_point; C.twice(3) }
+func G() { C.triple(1) }
+`)},
+}
+
+// TestCgoPreamble checks that the preamble keeps its place in the Go file, with comment
+// markers and #cgo directives blanked out.
+func TestCgoPreamble(t *testing.T) {
+	regions, err := cgoInjector{}.Regions("foo/foo.go", cgoFiles["foo/foo.go"].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "  \n#include \"foo.h\"\nstatic int add(int a, int b) { return a + b; }\n  \n                    \n   int twice(int x) { return 2*x; }"
+	if len(regions) != 1 || regions[0].Lang != "c" || regions[0].Start != (Pos{3, 1}) || string(regions[0].Src) != want {
+		t.Errorf("regions %q, want one of C at 3:1 with %q", regions, want)
+	}
+}
+
+// TestCgoResolveAt checks that C.xxx references in Go resolve to C definitions in the preamble
+// and the package directory, definitions before prototypes, and not to those of other directories.
+func TestCgoResolveAt(t *testing.T) {
+	e, err := New(WithLanguages("go", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(cgoFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		line, col int
+		want      []string
+	}{
+		{"function in the block comment", 11, 14, []string{"c::foo/foo.go#1::add"}},
+		{"struct in an included header", 11, 33, []string{"c::foo/foo.h::point"}},
+		{"function in the line comment", 11, 50, []string{"c::foo/foo.go#1::twice"}},
+		{"definition before prototype", 12, 14, []string{"c::foo/triple.c::triple", "c::foo/foo.h::triple"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cands, err := e.FindDefinitionAt("foo/foo.go", tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(cands, tt.want) {
+				t.Errorf("candidates %q, want %q", cands, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,534 @@
+package xref
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KV is an ordered key-value store a KVStore keeps the index in. DiskKV implements it on disk;
+// a thin wrapper makes an embedded database (bbolt, Pebble, ...) one as well. Implementations
+// must be safe for concurrent use.
+type KV interface {
+	// Get returns the value of a key, and false if the key is not set. The value must not be
+	// modified.
+	Get(key string) ([]byte, bool, error)
+	// Scan calls fn for each key with the prefix, in key order, until fn returns false. fn must
+	// neither modify the value nor write to the KV.
+	Scan(prefix string, fn func(key string, value []byte) bool) error
+	// Apply performs a batch of writes atomically.
+	Apply(writes []KVWrite) error
+	Close() error
+}
+
+// KVWrite is one write of a batch: Key is set to Value, or deleted if Delete is set.
+type KVWrite struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+const (
+	kvSegmentMagic  = "XREFKVS\x01"
+	kvMemLimit      = 8 << 20 // bytes of buffered writes that trigger a flush to a new segment
+	kvIndexInterval = 32      // records per key of a segment's in-memory sparse index
+	kvEntryOverhead = 48      // rough memory cost of a buffered entry beyond its key and value
+	kvMaxValue      = 1 << 30 // sanity limit for a value read back from a segment
+)
+
+// ErrKVClosed is returned by the methods of a closed DiskKV.
+var ErrKVClosed = errors.New("xref: key-value store is closed")
+
+// DiskKV is a KV kept in a directory as a log-structured merge tree. Writes are buffered in
+// memory and flushed as sorted, immutable segment files; segments are merged as they pile up,
+// so their number stays logarithmic in the data size. Only every 32nd key of a segment is held
+// in memory, so the data can be much larger than RAM. Buffered writes reach disk when they grow
+// past a few megabytes, on Flush and on Close: a crash loses the batches since the last flush,
+// never part of one. DiskKV is safe for concurrent use, but not shared between processes.
+type DiskKV struct {
+	dir string
+
+	mu      sync.RWMutex
+	mem     map[string]kvEntry // writes since the last flush
+	memSize int
+	segs    []*kvSegment // oldest first
+	next    uint64       // sequence number of the next flushed segment
+	closed  bool
+}
+
+type kvEntry struct {
+	value   []byte
+	deleted bool // a tombstone, hiding the key in older segments
+}
+
+// kvSegment is an open segment file: records sorted by key, a sparse index of every
+// kvIndexInterval-th key and its offset, then the index offset and the magic. A segment merged
+// from others is named after the range of sequence numbers it covers, so leftovers of a merge
+// interrupted by a crash are recognized and removed on open.
+type kvSegment struct {
+	f       *os.File
+	lo, hi  uint64
+	size    int64
+	dataEnd int64
+	keys    []string // sparse index
+	offs    []int64
+}
+
+// OpenDiskKV opens, creating it if needed, the key-value store in dir.
+func OpenDiskKV(dir string) (*DiskKV, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type span struct{ lo, hi uint64 }
+	var spans []span
+	for _, d := range entries {
+		name := d.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name)) // An unfinished flush or merge
+			continue
+		}
+		var s span
+		if _, err := fmt.Sscanf(name, "%016x-%016x.seg", &s.lo, &s.hi); err == nil {
+			spans = append(spans, s)
+		}
+	}
+	kv := &DiskKV{dir: dir, mem: map[string]kvEntry{}}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].lo < spans[j].lo || spans[i].lo == spans[j].lo && spans[i].hi > spans[j].hi
+	})
+	for i, s := range spans {
+		if i > 0 && s.hi <= kv.segs[len(kv.segs)-1].hi {
+			os.Remove(kv.segmentPath(s.lo, s.hi)) // Merged into the previous (wider) segment
+			continue
+		}
+		seg, err := openSegment(kv.segmentPath(s.lo, s.hi), s.lo, s.hi)
+		if err != nil {
+			kv.closeSegments()
+			return nil, err
+		}
+		kv.segs = append(kv.segs, seg)
+		kv.next = s.hi + 1
+	}
+	return kv, nil
+}
+
+func (kv *DiskKV) segmentPath(lo, hi uint64) string {
+	return filepath.Join(kv.dir, fmt.Sprintf("%016x-%016x.seg", lo, hi))
+}
+
+// Get returns the value of a key, and false if the key is not set.
+func (kv *DiskKV) Get(key string) ([]byte, bool, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if kv.closed {
+		return nil, false, ErrKVClosed
+	}
+	if e, ok := kv.mem[key]; ok {
+		return e.value, !e.deleted, nil
+	}
+	for i := len(kv.segs) - 1; i >= 0; i-- {
+		e, ok, err := kv.segs[i].get(key)
+		if err != nil || ok {
+			return e.value, ok && !e.deleted, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Scan calls fn for each key with the prefix, in key order, until fn returns false. Writes wait
+// until the scan is done, so fn must not write to the KV.
+func (kv *DiskKV) Scan(prefix string, fn func(key string, value []byte) bool) error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	curs := []*kvCursor{kv.memCursor(prefix)}
+	for i := len(kv.segs) - 1; i >= 0; i-- {
+		curs = append(curs, kv.segs[i].cursor(prefix))
+	}
+	return mergeCursors(curs, func(key string, e kvEntry) bool {
+		return e.deleted || fn(key, e.value)
+	})
+}
+
+// Apply performs a batch of writes atomically, flushing the buffered writes when they have grown
+// past the limit.
+func (kv *DiskKV) Apply(writes []KVWrite) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	for _, w := range writes {
+		if old, ok := kv.mem[w.Key]; ok {
+			kv.memSize -= len(w.Key) + len(old.value) + kvEntryOverhead
+		}
+		e := kvEntry{deleted: w.Delete}
+		if !w.Delete {
+			e.value = append([]byte{}, w.Value...) // Stored as is: callers may reuse their buffers
+		}
+		kv.mem[w.Key] = e
+		kv.memSize += len(w.Key) + len(e.value) + kvEntryOverhead
+	}
+	if kv.memSize >= kvMemLimit {
+		return kv.flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered writes to disk.
+func (kv *DiskKV) Flush() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	return kv.flush()
+}
+
+// Close flushes the buffered writes and closes the store.
+func (kv *DiskKV) Close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return nil
+	}
+	err := kv.flush()
+	kv.closeSegments()
+	kv.closed = true
+	return err
+}
+
+func (kv *DiskKV) closeSegments() {
+	for _, s := range kv.segs {
+		s.f.Close()
+	}
+	kv.segs = nil
+}
+
+// flush writes the buffered writes as a new segment, then merges segments while the newest is
+// at least as large as the one before it. The caller must hold the write lock.
+func (kv *DiskKV) flush() error {
+	if len(kv.mem) == 0 {
+		return nil
+	}
+	seg, err := kv.writeSegment(kv.next, kv.next, []*kvCursor{kv.memCursor("")}, false)
+	if err != nil {
+		return err
+	}
+	kv.segs = append(kv.segs, seg)
+	kv.next++
+	kv.mem, kv.memSize = map[string]kvEntry{}, 0
+
+	for n := len(kv.segs); n >= 2 && kv.segs[n-2].size <= kv.segs[n-1].size; n = len(kv.segs) {
+		older, newer := kv.segs[n-2], kv.segs[n-1]
+		// Tombstones only hide older segments; merging into the oldest, they can go
+		merged, err := kv.writeSegment(older.lo, newer.hi, []*kvCursor{newer.cursor(""), older.cursor("")}, n == 2)
+		if err != nil {
+			return err
+		}
+		kv.segs = append(kv.segs[:n-2], merged)
+		for _, s := range []*kvSegment{older, newer} {
+			s.f.Close()
+			os.Remove(s.f.Name())
+		}
+	}
+	return nil
+}
+
+// writeSegment writes the merged contents of cursors (newest first) as the segment covering
+// sequence numbers lo to hi, and opens it.
+func (kv *DiskKV) writeSegment(lo, hi uint64, curs []*kvCursor, dropDeleted bool) (*kvSegment, error) {
+	tmp, err := os.CreateTemp(kv.dir, "segment-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	bw := bufio.NewWriter(tmp)
+	var off int64
+	var buf [binary.MaxVarintLen64]byte
+	put := func(b []byte) {
+		bw.Write(b)
+		off += int64(len(b))
+	}
+	uvarint := func(v uint64) { put(buf[:binary.PutUvarint(buf[:], v)]) }
+
+	var keys []string
+	var offs []int64
+	n := 0
+	err = mergeCursors(curs, func(key string, e kvEntry) bool {
+		if e.deleted && dropDeleted {
+			return true
+		}
+		if n%kvIndexInterval == 0 {
+			keys, offs = append(keys, key), append(offs, off)
+		}
+		n++
+		uvarint(uint64(len(key)))
+		put([]byte(key))
+		if e.deleted {
+			uvarint(0)
+		} else {
+			uvarint(uint64(len(e.value)) + 1)
+			put(e.value)
+		}
+		return true
+	})
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	indexOff := off
+	uvarint(uint64(len(keys)))
+	for i, k := range keys {
+		uvarint(uint64(len(k)))
+		put([]byte(k))
+		uvarint(uint64(offs[i]))
+	}
+	binary.LittleEndian.PutUint64(buf[:8], uint64(indexOff))
+	put(buf[:8])
+	put([]byte(kvSegmentMagic))
+
+	err = bw.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	path := kv.segmentPath(lo, hi)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openSegment(path, lo, hi)
+}
+
+func openSegment(path string, lo, hi uint64) (*kvSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readSegmentIndex(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.lo, s.hi = lo, hi
+	return s, nil
+}
+
+func readSegmentIndex(f *os.File) (*kvSegment, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	trailer := make([]byte, 8+len(kvSegmentMagic))
+	if size < int64(len(trailer)) {
+		return nil, errors.New("truncated segment")
+	}
+	if _, err := f.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != kvSegmentMagic {
+		return nil, errors.New("not a segment, or truncated")
+	}
+	s := &kvSegment{f: f, size: size, dataEnd: int64(binary.LittleEndian.Uint64(trailer))}
+	if s.dataEnd < 0 || s.dataEnd > size-int64(len(trailer)) {
+		return nil, errors.New("corrupt segment trailer")
+	}
+	br := bufio.NewReader(io.NewSectionReader(f, s.dataEnd, size-int64(len(trailer))-s.dataEnd))
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	for ; n > 0; n-- {
+		key, err := readKVString(br)
+		if err != nil {
+			return nil, err
+		}
+		off, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		s.keys, s.offs = append(s.keys, key), append(s.offs, int64(off))
+	}
+	return s, nil
+}
+
+func readKVString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	if n > maxIndexString {
+		return "", fmt.Errorf("key of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readKVRecord reads the record at the reader's position.
+func readKVRecord(br *bufio.Reader) (string, kvEntry, error) {
+	key, err := readKVString(br)
+	if err != nil {
+		return "", kvEntry{}, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", kvEntry{}, err
+	}
+	if n == 0 {
+		return key, kvEntry{deleted: true}, nil
+	}
+	if n-1 > kvMaxValue {
+		return "", kvEntry{}, fmt.Errorf("value of %d bytes", n-1)
+	}
+	v := make([]byte, n-1)
+	if _, err := io.ReadFull(br, v); err != nil {
+		return "", kvEntry{}, err
+	}
+	return key, kvEntry{value: v}, nil
+}
+
+// reader reads the records from offset off on.
+func (s *kvSegment) reader(off int64) *bufio.Reader {
+	return bufio.NewReaderSize(io.NewSectionReader(s.f, off, s.dataEnd-off), 4096)
+}
+
+// get finds a key (or its tombstone) by reading the records after the last sparse index key not
+// after it.
+func (s *kvSegment) get(key string) (kvEntry, bool, error) {
+	i := sort.SearchStrings(s.keys, key)
+	if i == len(s.keys) || s.keys[i] != key {
+		i--
+	}
+	if i < 0 {
+		return kvEntry{}, false, nil
+	}
+	br := s.reader(s.offs[i])
+	for n := 0; n < kvIndexInterval; n++ {
+		k, e, err := readKVRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return kvEntry{}, false, err
+		}
+		if k >= key {
+			return e, k == key, nil
+		}
+	}
+	return kvEntry{}, false, nil
+}
+
+// kvCursor iterates over the entries of one source in key order.
+type kvCursor struct {
+	key  string
+	e    kvEntry
+	ok   bool
+	err  error
+	next func() (string, kvEntry, bool, error)
+}
+
+func (c *kvCursor) advance() {
+	c.key, c.e, c.ok, c.err = c.next()
+}
+
+// memCursor iterates over the buffered writes with a prefix. The caller must hold the lock.
+func (kv *DiskKV) memCursor(prefix string) *kvCursor {
+	var keys []string
+	for k := range kv.mem {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	c := &kvCursor{next: func() (string, kvEntry, bool, error) {
+		if len(keys) == 0 {
+			return "", kvEntry{}, false, nil
+		}
+		k := keys[0]
+		keys = keys[1:]
+		return k, kv.mem[k], true, nil
+	}}
+	c.advance()
+	return c
+}
+
+// cursor iterates over the records of the segment with a prefix.
+func (s *kvSegment) cursor(prefix string) *kvCursor {
+	i := max(sort.SearchStrings(s.keys, prefix)-1, 0)
+	var br *bufio.Reader
+	if i < len(s.offs) {
+		br = s.reader(s.offs[i])
+	}
+	c := &kvCursor{next: func() (string, kvEntry, bool, error) {
+		for br != nil {
+			k, e, err := readKVRecord(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", kvEntry{}, false, err
+			}
+			if k < prefix {
+				continue
+			}
+			if !strings.HasPrefix(k, prefix) {
+				break
+			}
+			return k, e, true, nil
+		}
+		br = nil
+		return "", kvEntry{}, false, nil
+	}}
+	c.advance()
+	return c
+}
+
+// mergeCursors calls fn with the entries of cursors, ordered newest first, in key order; of the
+// entries of a key, the newest wins. It stops when fn returns false.
+func mergeCursors(curs []*kvCursor, fn func(key string, e kvEntry) bool) error {
+	for {
+		var first *kvCursor
+		for _, c := range curs {
+			if c.err != nil {
+				return c.err
+			}
+			if c.ok && (first == nil || c.key < first.key) {
+				first = c
+			}
+		}
+		if first == nil {
+			return nil
+		}
+		key, e := first.key, first.e
+		for _, c := range curs {
+			if c.ok && c.key == key {
+				c.advance()
+			}
+		}
+		if !fn(key, e) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package xref
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// checkKV compares every key of a KV, by Get and by Scan, with the expected contents.
+func checkKV(t *testing.T, kv KV, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		got, ok, err := kv.Get(k)
+		if err != nil || !ok || string(got) != v {
+			t.Fatalf("Get(%q) = %q, %v, %v; want %q", k, got, ok, err, v)
+		}
+	}
+	for _, prefix := range []string{"", "a", "b/", "b/1"} {
+		var keys []string
+		err := kv.Scan(prefix, func(k string, v []byte) bool {
+			if want[k] != string(v) {
+				t.Errorf("Scan(%q): %q = %q, want %q", prefix, k, v, want[k])
+			}
+			keys = append(keys, k)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wantKeys []string
+		for k := range want {
+			if strings.HasPrefix(k, prefix) {
+				wantKeys = append(wantKeys, k)
+			}
+		}
+		slices.Sort(wantKeys)
+		if !slices.Equal(keys, wantKeys) {
+			t.Fatalf("Scan(%q) = %q, want %q", prefix, keys, wantKeys)
+		}
+	}
+}
+
+// TestDiskKV applies batches of writes, flushing after some of them, and checks the contents
+// after each step and after reopening the store.
+func TestDiskKV(t *testing.T) {
+	type step struct {
+		set   map[string]string
+		del   []string
+		flush bool
+	}
+	batch := func(prefix string, n int, value string) map[string]string {
+		m := map[string]string{}
+		for i := range n {
+			m[fmt.Sprintf("%s%03d", prefix, i)] = value
+		}
+		return m
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "buffered only",
+			steps: []step{{set: map[string]string{"a": "1", "b/1": "2"}}, {del: []string{"a"}}},
+		},
+		{
+			name: "flushed",
+			steps: []step{
+				{set: map[string]string{"a": "1", "b/1": "2", "b/2": "3"}, flush: true},
+				{set: map[string]string{"b/1": "new"}},
+			},
+		},
+		{
+			name: "tombstones hide older segments",
+			steps: []step{
+				{set: batch("b/1", 50, "old"), flush: true},
+				{set: batch("a", 10, "x"), del: []string{"b/100", "b/149"}, flush: true},
+				{del: []string{"b/101"}},
+			},
+		},
+		{
+			name: "overwrites across merges",
+			steps: []step{
+				{set: batch("b/1", 100, "v1"), flush: true},
+				{set: batch("b/1", 100, "v2"), flush: true},
+				{set: batch("b/1", 50, "v3"), flush: true},
+				{set: batch("b/2", 10, "w"), flush: true},
+				{del: []string{"b/1000", "b/2001"}, flush: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			kv, err := OpenDiskKV(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{}
+			for _, s := range tt.steps {
+				var writes []KVWrite
+				for _, k := range slices.Sorted(maps.Keys(s.set)) {
+					writes = append(writes, KVWrite{Key: k, Value: []byte(s.set[k])})
+					want[k] = s.set[k]
+				}
+				for _, k := range s.del {
+					writes = append(writes, KVWrite{Key: k, Delete: true})
+					delete(want, k)
+				}
+				if err := kv.Apply(writes); err != nil {
+					t.Fatal(err)
+				}
+				if s.flush {
+					if err := kv.Flush(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				checkKV(t, kv, want)
+			}
+			if err := kv.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := kv.Get("a"); err != ErrKVClosed {
+				t.Errorf("Get after Close: %v, want ErrKVClosed", err)
+			}
+			kv, err = OpenDiskKV(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer kv.Close()
+			checkKV(t, kv, want)
+		})
+	}
+}
+
+// TestDiskKVCompaction checks that segments are merged as flushes pile up, that merging into the
+// oldest segment drops tombstones, and that reopening removes leftovers of an interrupted merge.
+func TestDiskKVCompaction(t *testing.T) {
+	dir := t.TempDir()
+	kv, err := OpenDiskKV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	const flushes = 64
+	for i := range flushes {
+		key := fmt.Sprintf("b/%03d", i)
+		writes := []KVWrite{{Key: key, Value: []byte("v")}}
+		want[key] = "v"
+		if i%2 == 1 {
+			prev := fmt.Sprintf("b/%03d", i-1)
+			writes = append(writes, KVWrite{Key: prev, Delete: true})
+			delete(want, prev)
+		}
+		if err := kv.Apply(writes); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		// Each segment is at least twice as large as the next, so there are few of them
+		if n := len(kv.segs); n > 8 {
+			t.Fatalf("after %d flushes: %d segments", i+1, n)
+		}
+	}
+	checkKV(t, kv, want)
+	oldest := kv.segs[0]
+	for i := 0; i < flushes; i += 2 {
+		if e, ok, _ := oldest.get(fmt.Sprintf("b/%03d", i)); ok && e.deleted {
+			t.Errorf("oldest segment keeps the tombstone of b/%03d", i)
+		}
+	}
+	segs := len(kv.segs)
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash mid-merge leaves a temporary file and the segments the merge covered
+	names, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(names) != segs {
+		t.Fatalf("%d segment files, want %d", len(names), segs)
+	}
+	os.WriteFile(filepath.Join(dir, "segment-1.tmp"), []byte("partial"), 0o644)
+	covered, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lo, hi uint64
+	if _, err := fmt.Sscanf(filepath.Base(names[0]), "%016x-%016x.seg", &lo, &hi); err != nil || hi == lo {
+		t.Fatalf("oldest segment %s is not a merged one", names[0])
+	}
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.seg", lo, lo)), covered, 0o644)
+
+	kv, err = OpenDiskKV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	checkKV(t, kv, want)
+	if len(kv.segs) != segs {
+		t.Errorf("reopened with %d segments, want %d", len(kv.segs), segs)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(left) > 0 {
+		t.Errorf("leftovers not removed: %q", left)
+	}
+}
+
+// TestDiskStoreReopen indexes files into a disk store and queries them after reopening it,
+// without indexing again.
+func TestDiskStoreReopen(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/a.go": {Data: []byte("package a\n\nfunc Hello() string { return \"hi\" }\n")},
+		"b/b.go": {Data: []byte("package b\n\nimport \"example.com/a\"\n\nfunc Use() string { return a.Hello() }\n")},
+	}
+	dir := t.TempDir()
+	open := func() (*Engine, *KVStore) {
+		st, err := OpenDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := New(WithLanguages("go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Store = st
+		return e, st
+	}
+	e, st := open()
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	before := e.GetDefinitions()
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, st = open()
+	defer st.Close()
+	if got := e.GetDefinitions(); !maps.Equal(got, before) {
+		t.Errorf("reopened definitions %v, want %v", got, before)
+	}
+	d, _, err := e.FindDefinitionAt("b/b.go", 5, 30)
+	if err != nil || d.File != "a/a.go" || d.Name != "Hello" {
+		t.Errorf("definition of a.Hello: %v, %v; want Hello in a/a.go", d, err)
+	}
+	if err := st.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDiskStoreFollowsGeneratedCode checks that definitions in generated code lead to the
+// proto they were generated from with a disk store, as with the in-memory index.
+func TestDiskStoreFollowsGeneratedCode(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proto/acme/users/v1/users.proto": {Data: []byte("syntax = \"proto3\";\npackage acme.users.v1;\n" +
+			"option go_package = \"github.com/acme/api/users/v1;usersv1\";\nmessage User {\n  string user_id = 1;\n}\n")},
+		"gen/users.pb.go": {Data: []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: acme/users/v1/users.proto\n\n" +
+			"package usersv1\n\ntype User struct{ UserId string }\n\nfunc (x *User) GetUserId() string { return x.UserId }\n")},
+		"main.go": {Data: []byte("package main\n\nfunc main() {\n\tvar u *usersv1.User\n\t_ = u.GetUserId()\n}\n")},
+	}
+	for _, disk := range []bool{false, true} {
+		e, err := New(WithLanguages("go", "proto"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disk {
+			st, err := OpenDiskStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			e.Store = st
+		}
+		if err := e.IndexFS(fsys, "."); err != nil {
+			t.Fatal(err)
+		}
+		d, _, err := e.FindDefinitionAt("main.go", 5, 8)
+		if err != nil || d.File != "proto/acme/users/v1/users.proto" || d.Name != "user_id" {
+			t.Errorf("disk store %t: definition of GetUserId: %v, %v; want user_id in users.proto", disk, d, err)
+		}
+	}
+}
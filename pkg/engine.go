@@ -1,13 +1,23 @@
 package xref
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"maps"
-	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	sitter "github.com/smacker/go-tree-sitter"
 )
@@ -28,70 +38,319 @@ type FileIndex struct {
 	Refs        map[string][]RefLocation // SymbolID -> refs (optional)
 	Occurrences []Occurrence
 	Imports     map[string]string // alias -> path/module (adapter-specific)
+	Package     string            // declared package/namespace, if the language has one
+	Generated   string            // for generated code, the source file named in its header (e.g. users.proto)
+	// SyntaxErrors are the ranges of ERROR and MISSING nodes tree-sitter recovered from; the
+	// file was extracted around them.
+	SyntaxErrors []Range
 }
 
+// ProjectIndex is the global symbol database: definitions, references, name lookups and the
+// occurrences of every file. Strings (files, names, kinds, symbol ID parts) are interned and
+// ranges packed, so a symbol costs a few hundred bytes however long its path is (about 630 in
+// BenchmarkIndexMemory, counting its references and occurrences). Read it through its methods,
+// which are safe for concurrent use with indexing.
 type ProjectIndex struct {
 	mu         sync.RWMutex
-	Defs       map[string]DefLocation
-	Refs       map[string][]RefLocation
-	NameLookup map[string][]string // lang:name -> []SymbolID
-	FileOcc    map[string][]Occurrence
+	strs       stringTable
+	defs       map[symKey]packedDef
+	refs       map[symKey][]packedRef
+	nameLookup map[nameKey][]symKey         // lang + name -> symbols
+	fileOcc    map[strID][]packedOcc        // file -> occurrences, sorted by position (see sortOccurrences)
+	imports    map[string]map[string]string // file -> FileIndex.Imports
+	generated  map[string]string            // generated file -> FileIndex.Generated
+	packages   map[string]string            // file -> FileIndex.Package
+	links      map[symKey]symKey            // generated symbol -> source symbol (e.g. .pb.go getter -> .proto field)
+
+	// What each file contributed, so re-indexing can retract it without scanning the whole index
+	fileDefs  map[strID][]symKey             // file -> symbols defined there
+	fileNames map[strID]map[nameKey][]symKey // file -> lang + name -> symbols defined there, for same-file resolution
+	fileRefs  map[strID][]symKey             // file -> symbols with references there
+	occRefs   map[symKey][]occRef            // symbol -> occurrences of other files resolved to it
+	stamps    map[string]fileStamp           // file -> size and modification time when it was indexed
+
+	// What generated-code links depend on, so only the affected ones are redone (see linkGenerated)
+	protos   map[string][]string // .proto base name -> .proto files so named
+	genFiles map[string][]strID  // .proto base name -> generated files that may come from a .proto so named
+	genProto map[strID]string    // generated file -> its key in genFiles
+	relink   map[strID]struct{}  // generated files whose links are out of date
+
+	updateCount atomic.Uint64 // calls of replace, see storeCache
 }
 
 func newProjectIndex() *ProjectIndex {
 	return &ProjectIndex{
-		Defs:       map[string]DefLocation{},
-		Refs:       map[string][]RefLocation{},
-		NameLookup: map[string][]string{},
-		FileOcc:    map[string][]Occurrence{},
+		strs:       newStringTable(),
+		defs:       map[symKey]packedDef{},
+		refs:       map[symKey][]packedRef{},
+		nameLookup: map[nameKey][]symKey{},
+		fileOcc:    map[strID][]packedOcc{},
+		imports:    map[string]map[string]string{},
+		generated:  map[string]string{},
+		packages:   map[string]string{},
+		links:      map[symKey]symKey{},
+		fileDefs:   map[strID][]symKey{},
+		fileNames:  map[strID]map[nameKey][]symKey{},
+		fileRefs:   map[strID][]symKey{},
+		occRefs:    map[symKey][]occRef{},
+		stamps:     map[string]fileStamp{},
+		protos:     map[string][]string{},
+		genFiles:   map[string][]strID{},
+		genProto:   map[strID]string{},
+		relink:     map[strID]struct{}{},
 	}
 }
 
 // merge combines a file's symbol index into the global project index.
-// Thread-safe operation that updates definitions, references, name lookups, and file occurrences.
-// Called during indexing to build the complete cross-reference database.
+// Updates definitions, references, name lookups, and file occurrences; the caller must hold
+// the write lock (see replace, which retracts the file's previous contribution first).
 func (pi *ProjectIndex) merge(fi *FileIndex) {
-	pi.mu.Lock()
-	defer pi.mu.Unlock()
+	file := pi.strs.intern(fi.File)
 
 	// Add all definitions from this file to the global definition map
+	pi.fileDefs[file] = slices.Grow(pi.fileDefs[file], len(fi.Defs))
+	names := pi.fileNames[file]
+	if names == nil && len(fi.Defs) > 0 {
+		names = map[nameKey][]symKey{}
+		pi.fileNames[file] = names
+	}
 	for sid, d := range fi.Defs {
-		pi.Defs[sid] = d
-		// Build reverse lookup: language:name -> []symbolID for fast name-based searches
-		key := d.Lang + ":" + d.Name
-		pi.NameLookup[key] = append(pi.NameLookup[key], sid)
+		k := pi.internSID(sid)
+		pd := pi.packDef(d)
+		pi.defs[k] = pd
+		// Build reverse lookup: language + name -> symbols for fast name-based searches
+		nk := nameKey{pd.lang, pd.name}
+		pi.nameLookup[nk] = append(pi.nameLookup[nk], k)
+		pi.fileDefs[file] = append(pi.fileDefs[file], k)
+		names[nk] = append(names[nk], k)
 	}
 
 	// Merge reference locations for each symbol
 	for sid, refs := range fi.Refs {
-		pi.Refs[sid] = append(pi.Refs[sid], refs...)
+		k := pi.internSID(sid)
+		for _, r := range refs {
+			pi.refs[k] = append(pi.refs[k], packedRef{file: pi.strs.intern(r.File), lang: pi.strs.intern(r.Lang), rng: packRange(r.Rng)})
+		}
+		pi.fileRefs[file] = append(pi.fileRefs[file], k)
+	}
+
+	// Store all raw occurrences for this file (used for cursor-based lookups), sized exactly:
+	// they are most of the index
+	pi.fileOcc[file] = slices.Grow(pi.fileOcc[file], len(fi.Occurrences))
+	for _, o := range fi.Occurrences {
+		pi.fileOcc[file] = append(pi.fileOcc[file], packedOcc{
+			name: pi.strs.intern(o.Name), kind: pi.strs.intern(o.KindHint), sym: pi.internSID(o.SymbolID), rng: packRange(o.Rng),
+		})
 	}
+	sortOccurrences(pi.fileOcc[file])
 
-	// Store all raw occurrences for this file (used for cursor-based lookups)
-	pi.FileOcc[fi.File] = append(pi.FileOcc[fi.File], fi.Occurrences...)
+	// Keep each file's imports so resolvers can honor project-wide directives (e.g. C# global using).
+	// A host file with several embedded regions contributes one FileIndex per region.
+	if len(fi.Imports) > 0 {
+		if pi.imports[fi.File] == nil {
+			pi.imports[fi.File] = map[string]string{}
+		}
+		maps.Copy(pi.imports[fi.File], fi.Imports)
+	}
+	if fi.Generated != "" {
+		pi.generated[fi.File] = fi.Generated
+	}
+	if fi.Package != "" {
+		pi.packages[fi.File] = fi.Package
+	}
+	pi.trackLinks(fi.File, file)
 }
 
-// New creates a new cross-reference engine with the specified language adapters.
-// If no adapters are provided, it automatically registers Go, TypeScript, and Python adapters.
-// Returns an Engine ready for indexing and querying code symbols.
-func New(adapters ...LanguageAdapter) (*Engine, error) {
-	if len(adapters) == 0 {
-		// Initialize default language adapters for Go, TypeScript, and Python
-		py, err := newPyAdapter()
-		if err != nil {
-			return nil, err
+// noteResolved records the occurrences of a file resolved to symbols defined elsewhere, so
+// invalidate finds them without scanning every file. The caller must hold the write lock, and
+// call it once the file's FileIndexes are all merged: merging sorts the file's occurrences.
+func (pi *ProjectIndex) noteResolved(file strID) {
+	for i, o := range pi.fileOcc[file] {
+		if o.sym == (symKey{}) {
+			continue
 		}
-		ts, err := newTsAdapter()
-		if err != nil {
-			return nil, err
+		if d, ok := pi.defs[o.sym]; ok && d.file == file {
+			continue // Retracted together with the occurrence
 		}
-		g, err := newGoAdapter()
-		if err != nil {
-			return nil, err
+		pi.occRefs[o.sym] = append(pi.occRefs[o.sym], occRef{file, int32(i)})
+	}
+}
+
+// replace atomically retracts everything the given files contributed to the index and merges
+// fis in their place; with no fis the files are simply removed. Symbols that disappear are
+// invalidated across the index, so no reference or link keeps pointing at them.
+func (pi *ProjectIndex) replace(files []string, fis []*FileIndex) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	defer pi.updateCount.Add(1)
+
+	removed := map[symKey]struct{}{}
+	for _, f := range files {
+		pi.retract(f, removed)
+	}
+	merged := map[strID]struct{}{}
+	for _, fi := range fis {
+		pi.merge(fi)
+		merged[pi.strs.intern(fi.File)] = struct{}{}
+		for sid := range fi.Defs {
+			delete(removed, pi.internSID(sid)) // Still defined: references to it stay valid
+		}
+	}
+	for f := range merged {
+		pi.noteResolved(f)
+	}
+	pi.invalidate(removed)
+}
+
+// retract removes a file's definitions, references, occurrences and imports from the index,
+// adding the symbols it defined to removed. The caller must hold the write lock.
+func (pi *ProjectIndex) retract(file string, removed map[symKey]struct{}) {
+	delete(pi.imports, file)
+	delete(pi.generated, file)
+	delete(pi.packages, file)
+	delete(pi.stamps, file)
+	f, ok := pi.strs.find(file)
+	if !ok {
+		return // Never indexed
+	}
+	pi.untrackLinks(file, f)
+	for _, k := range pi.fileDefs[f] {
+		d, ok := pi.defs[k]
+		if !ok || d.file != f {
+			continue // Already retracted, or redefined by another file
+		}
+		removed[k] = struct{}{}
+		delete(pi.defs, k)
+		delete(pi.links, k)
+		nk := nameKey{d.lang, d.name}
+		ids := slices.DeleteFunc(pi.nameLookup[nk], func(id symKey) bool { return id == k })
+		if len(ids) == 0 {
+			delete(pi.nameLookup, nk)
+		} else {
+			pi.nameLookup[nk] = ids
+		}
+	}
+	for _, k := range pi.fileRefs[f] {
+		refs := slices.DeleteFunc(pi.refs[k], func(r packedRef) bool { return r.file == f })
+		if len(refs) == 0 {
+			delete(pi.refs, k)
+		} else {
+			pi.refs[k] = refs
+		}
+	}
+	for _, o := range pi.fileOcc[f] {
+		if refs, ok := pi.occRefs[o.sym]; ok {
+			if refs = slices.DeleteFunc(refs, func(r occRef) bool { return r.file == f }); len(refs) == 0 {
+				delete(pi.occRefs, o.sym)
+			} else {
+				pi.occRefs[o.sym] = refs
+			}
+		}
+	}
+	delete(pi.fileDefs, f)
+	delete(pi.fileNames, f)
+	delete(pi.fileRefs, f)
+	delete(pi.fileOcc, f)
+}
+
+// invalidate drops every trace of symbols that no longer exist: their reference lists, links
+// from generated code, and occurrences in other files resolved to them, which fall back to
+// being resolved afresh. Links to them are redone by the next linkGenerated. The caller must
+// hold the write lock.
+func (pi *ProjectIndex) invalidate(removed map[symKey]struct{}) {
+	for k := range removed {
+		delete(pi.refs, k)
+		delete(pi.links, k)
+		for _, r := range pi.occRefs[k] {
+			if occs := pi.fileOcc[r.file]; int(r.i) < len(occs) && occs[r.i].sym == k {
+				occs[r.i].sym = symKey{}
+			}
 		}
-		adapters = []LanguageAdapter{g, ts, py}
+		delete(pi.occRefs, k)
 	}
-	return &Engine{Index: newProjectIndex(), Adapters: adapters}, nil
+}
+
+// Definition returns the definition of a symbol ID.
+func (pi *ProjectIndex) Definition(sid string) (DefLocation, bool) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.definition(sid)
+}
+
+// Lookup returns the IDs of the symbols named name in lang (as reported by the adapter's Lang).
+func (pi *ProjectIndex) Lookup(lang, name string) []string {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.lookup(lang, name)
+}
+
+// References returns the references recorded for a symbol ID.
+func (pi *ProjectIndex) References(sid string) []RefLocation {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.references(sid)
+}
+
+// Occurrences returns every symbol occurrence recorded for a file, sorted by position.
+func (pi *ProjectIndex) Occurrences(file string) []Occurrence {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.occurrences(file)
+}
+
+// Imports returns a copy of the imports a file declared (adapter-specific: alias -> path).
+func (pi *ProjectIndex) Imports(file string) map[string]string {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return maps.Clone(pi.imports[file])
+}
+
+// Package returns the package or namespace a file declared, if any.
+func (pi *ProjectIndex) Package(file string) string {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.packages[file]
+}
+
+// Link returns the symbol a generated symbol was generated from, e.g. the .proto field behind a
+// .pb.go getter.
+func (pi *ProjectIndex) Link(sid string) (string, bool) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.link(sid)
+}
+
+// Files returns every indexed file, sorted.
+func (pi *ProjectIndex) Files() []string {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.files()
+}
+
+// PutFile replaces everything file contributed to the index with fis.
+func (pi *ProjectIndex) PutFile(file string, fis []*FileIndex) error {
+	pi.replace([]string{file}, fis)
+	return nil
+}
+
+// DeleteFile removes everything file contributed to the index.
+func (pi *ProjectIndex) DeleteFile(file string) error {
+	pi.replace([]string{file}, nil)
+	return nil
+}
+
+// FileLookup returns the symbols named name in lang defined in file, in source order.
+func (pi *ProjectIndex) FileLookup(file, lang, name string) []string {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.fileLookup(file, lang, name)
+}
+
+// OccurrenceAt returns the innermost occurrence of a file containing pos.
+func (pi *ProjectIndex) OccurrenceAt(file string, pos Pos) (Occurrence, bool) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.occurrenceAt(file, pos)
 }
 
 func (e *Engine) IndexRoot(root string) error {
@@ -102,17 +361,123 @@ func (e *Engine) IndexRoot(root string) error {
 // Uses concurrent processing with file discovery and parsing happening in parallel.
 // Automatically skips common VCS and cache directories (.git, node_modules, __pycache__, etc.).
 func (e *Engine) IndexPaths(paths ...string) error {
+	_, err := e.indexFS(context.Background(), osFS{}, paths, IndexOptions{})
+	return err
+}
+
+// IndexOptions configures IndexPathsContext.
+type IndexOptions struct {
+	// Workers is the number of files parsed in parallel; 0 means Engine.Workers, or else
+	// runtime.GOMAXPROCS(0).
+	Workers int
+	// Progress, if set, receives a snapshot of the counters while indexing runs (at most every
+	// ProgressInterval) and once more when it ends. Calls never overlap.
+	Progress func(IndexProgress)
+	// ProgressInterval is the minimum time between Progress calls; 0 means 100ms.
+	ProgressInterval time.Duration
+}
+
+// workers returns the number of files to parse in parallel: n if set, else Workers if set,
+// else GOMAXPROCS.
+func (e *Engine) workers(n int) int {
+	if n <= 0 {
+		n = e.Workers
+	}
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// logger returns Logger, or a logger that discards everything.
+func (e *Engine) logger() *slog.Logger {
+	if e.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return e.Logger
+}
+
+// IndexProgress counts the files of an indexing run.
+type IndexProgress struct {
+	Discovered int   // files found while walking the paths
+	Parsed     int   // files extracted into the index
+	Skipped    int   // files no adapter or injector handles
+	Failed     int   // files that could not be read
+	Bytes      int64 // bytes of source processed
+	Done       bool  // set on the final report
+}
+
+// IndexPathsContext is IndexPaths with options and cancellation, returning a report of the files
+// that failed or only partially parsed. When ctx is cancelled, walking and parsing stop promptly
+// and ctx.Err() is returned with the report so far; files indexed up to then stay indexed.
+func (e *Engine) IndexPathsContext(ctx context.Context, opts IndexOptions, paths ...string) (*IndexReport, error) {
+	return e.indexFS(ctx, osFS{}, paths, opts)
+}
+
+// IndexFS indexes files and directories of an arbitrary file system (an embed.FS, a zip archive
+// via archive/zip, an fstest.MapFS, ...) with the same walking, ignore rules and concurrency as
+// IndexPaths. Roots and indexed paths are fs.FS paths ("." for the whole tree), and later queries
+// such as FindDefinitionAt read those files back through fsys.
+func (e *Engine) IndexFS(fsys fs.FS, roots ...string) error {
+	_, err := e.indexFS(context.Background(), fsys, roots, IndexOptions{})
+	return err
+}
+
+// indexCounters are the live counters behind IndexProgress.
+type indexCounters struct {
+	discovered, parsed, skipped, failed, bytes atomic.Int64
+}
+
+func (c *indexCounters) snapshot(done bool) IndexProgress {
+	return IndexProgress{
+		Discovered: int(c.discovered.Load()), Parsed: int(c.parsed.Load()), Skipped: int(c.skipped.Load()),
+		Failed: int(c.failed.Load()), Bytes: c.bytes.Load(), Done: done,
+	}
+}
+
+func (e *Engine) indexFS(ctx context.Context, fsys fs.FS, paths []string, opts IndexOptions) (*IndexReport, error) {
+	began := time.Now()
 	var wg sync.WaitGroup
+	var counts indexCounters
 	fileCh := make(chan string, 512)
 
+	var reportMu sync.Mutex
+	report := &IndexReport{Unsupported: map[string]int{}, Excluded: map[string]int{}}
+
+	// send hands a file to the workers, giving up when indexing is cancelled. Time spent waiting
+	// for a worker to take it is not walking, so it's left out of Timing.Walk.
+	var waited time.Duration // Only the producer sends
+	send := func(path string) bool {
+		defer since(&waited, time.Now())
+		select {
+		case fileCh <- path:
+			counts.discovered.Add(1)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	// Producer goroutine: discovers all files to be indexed
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(fileCh)
+		defer func(start time.Time) {
+			report.Timing.Walk = time.Since(start) - waited // Only the producer writes Walk
+		}(time.Now())
 		seenDir := map[string]struct{}{} // Prevent duplicate directory processing
+		w := e.newWalker(fsys)
 		for _, p := range paths {
-			fi, err := os.Stat(p)
+			if ctx.Err() != nil {
+				return
+			}
+			fi, err := fs.Stat(fsys, p)
 			if err != nil {
+				counts.failed.Add(1)
+				reportMu.Lock()
+				report.Files = append(report.Files, FileReport{File: p, Err: err, Failed: true})
+				reportMu.Unlock()
 				continue
 			}
 			if fi.IsDir() {
@@ -121,62 +486,96 @@ func (e *Engine) IndexPaths(paths ...string) error {
 					continue
 				}
 				seenDir[root] = struct{}{}
-				// Recursively walk directory tree, skipping common ignore patterns
-				filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-					if err != nil {
-						return nil
-					}
-					if d.IsDir() {
-						base := strings.ToLower(filepath.Base(path))
-						// Skip VCS directories, Python caches, and package managers
-						switch base {
-						case ".git", ".hg", ".svn", "__pycache__", ".mypy_cache", ".pytest_cache", "venv", ".venv", "node_modules":
-							return filepath.SkipDir
-						}
-						return nil
-					}
-					// Send file path to processing workers
-					fileCh <- path
-					return nil
-				})
-			} else {
-				// Single file case
-				fileCh <- p
+				// Recursively walk directory tree, skipping ignored files and common ignore patterns,
+				// and send file paths to processing workers
+				if !w.walk(root, send) {
+					return
+				}
+			} else if !send(p) { // Single file case
+				return
 			}
 		}
-		close(fileCh)
 	}()
 
+	// Progress reporter: periodic snapshots until the workers are done
+	stopProgress := make(chan struct{})
+	var pw sync.WaitGroup
+	if opts.Progress != nil {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		pw.Add(1)
+		go func() {
+			defer pw.Done()
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-stopProgress:
+					return
+				case <-t.C:
+					opts.Progress(counts.snapshot(false))
+				}
+			}
+		}()
+	}
+
 	// Consumer workers: parse files and extract symbols concurrently
 	var cw sync.WaitGroup
-	workers := 4 // Process 4 files simultaneously for optimal performance
-	for range workers {
+	for range e.workers(opts.Workers) {
 		cw.Add(1)
 		go func() {
 			defer cw.Done()
 			for path := range fileCh {
-				// Read file contents
-				src, err := os.ReadFile(path)
-				if err != nil {
-					continue
+				if ctx.Err() != nil {
+					continue // Drain so the producer can finish
 				}
-				// Find appropriate language adapter based on file extension
-				adapter := e.pickAdapter(path)
-				if adapter == nil {
-					continue // Skip unsupported file types
+				e.setSourceFS(path, fsys)
+				st, stamped := e.statSource(path) // Before reading: a later change leaves the file stale
+				tr := &extractTrace{}
+				var fis []*FileIndex
+				var readErr error
+				if e.tooLarge(st, stamped) {
+					tr.excluded = "too large"
+				} else {
+					var n int
+					fis, n, readErr = e.extractPath(fsys, path, tr)
+					counts.bytes.Add(int64(n))
+					if readErr != nil {
+						tr.errs = append(tr.errs, readErr)
+					}
 				}
-				// Parse source code into syntax tree using tree-sitter
-				tree, err := adapter.Parse(path, src)
-				if err != nil || tree == nil {
-					continue // Skip files that failed to parse
+				supported := len(fis) > 0 || e.handles(path)
+				if supported && readErr == nil {
+					// Thread-safely replace the file's previous contribution (if any) in the global project
+					// index. A file that now yields nothing (too large, binary, excluded by build
+					// constraints, unparsable) is dropped, rather than left as it was last indexed.
+					start := time.Now()
+					err := e.store().PutFile(path, fis)
+					since(&tr.timing.Merge, start)
+					if err != nil {
+						tr.errs = append(tr.errs, fmt.Errorf("%s: store: %w", path, err))
+						fis = nil
+					} else if stamped {
+						e.stamp(path, st)
+					}
 				}
-				// Extract symbols (defs, refs, imports) using language-specific queries
-				fi, err := adapter.Extract(path, src, tree)
-				if err != nil {
-					continue
+				switch {
+				case len(fis) > 0:
+					counts.parsed.Add(1)
+				case len(tr.errs) > 0:
+					counts.failed.Add(1)
+				default:
+					counts.skipped.Add(1) // Unsupported file type, or nothing to extract
 				}
-				// Thread-safely merge file index into global project index
-				e.Index.merge(fi)
+				if len(tr.errs) > 0 {
+					e.logger().Warn("indexing file failed", "file", path, "err", errors.Join(tr.errs...))
+				}
+				reportMu.Lock()
+				report.reportFile(path, fis, tr, supported)
+				report.Timing.add(tr.timing)
+				reportMu.Unlock()
 			}
 		}()
 	}
@@ -184,10 +583,39 @@ func (e *Engine) IndexPaths(paths ...string) error {
 	// Wait for both producer and all consumers to complete
 	wg.Wait()
 	cw.Wait()
-	return nil
+	close(stopProgress)
+	pw.Wait()
+
+	// Link generated code (e.g. .pb.go) back to the definitions it was generated from
+	start := time.Now()
+	e.Index.linkGenerated()
+	since(&report.Timing.Link, start)
+
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].File < report.Files[j].File })
+	report.Counts = counts.snapshot(true)
+	report.Timing.Total = time.Since(began)
+	c := report.Counts
+	e.logger().Info("indexed", "files", c.Discovered, "parsed", c.Parsed, "skipped", c.Skipped,
+		"failed", c.Failed, "bytes", c.Bytes, "duration", report.Timing.Total)
+	if opts.Progress != nil {
+		opts.Progress(report.Counts)
+	}
+	return report, ctx.Err()
+}
+
+// skipDir reports whether a directory is left out of indexing and watching:
+// VCS directories, Python caches, and package managers.
+func skipDir(path string) bool {
+	switch strings.ToLower(filepath.Base(path)) {
+	case ".git", ".hg", ".svn", "__pycache__", ".mypy_cache", ".pytest_cache", "venv", ".venv", "node_modules":
+		return true
+	}
+	return false
 }
 
 func (e *Engine) pickAdapter(path string) LanguageAdapter {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
 	for _, a := range e.Adapters {
 		if a.CanHandle(path) {
 			return a
@@ -196,68 +624,379 @@ func (e *Engine) pickAdapter(path string) LanguageAdapter {
 	return nil
 }
 
+func (e *Engine) pickInjector(path string) Injector {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
+	for _, inj := range e.Injectors {
+		if inj.CanHandle(path) {
+			return inj
+		}
+	}
+	return nil
+}
+
+// adapterByLang returns the registered adapter for a language name (as reported by Lang), or nil.
+func (e *Engine) adapterByLang(lang string) LanguageAdapter {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
+	for _, a := range e.Adapters {
+		if a.Lang() == lang {
+			return a
+		}
+	}
+	return nil
+}
+
+// extractPath reads a file of fsys and extracts its symbols (an open document's text takes
+// precedence), returning them with the file's size. Files of a git revision reuse what was
+// extracted from the same blob before.
+func (e *Engine) extractPath(fsys fs.FS, path string, tr *extractTrace) ([]*FileIndex, int, error) {
+	key, cacheable := blobKeyOf(fsys, path)
+	key.queries = e.extractVersion()
+	if cacheable {
+		e.docMu.Lock()
+		_, open := e.docs[path]
+		e.docMu.Unlock()
+		cacheable = !open
+	}
+	if cacheable {
+		if ent, ok := e.Blobs.get(key); ok {
+			tr.excluded = ent.excluded
+			return ent.fis, int(key.size), nil
+		}
+	}
+	start := time.Now()
+	src, err := e.readSource(path)
+	since(&tr.timing.Read, start)
+	if err != nil {
+		return nil, 0, err
+	}
+	if isBinary(src) {
+		tr.excluded = "binary"
+		return nil, len(src), nil
+	}
+	// Find appropriate language adapter based on file extension
+	fis := e.extract(path, src, tr)
+	if cacheable && len(tr.errs) == 0 {
+		e.Blobs.put(key, blobEntry{fis, tr.excluded})
+	}
+	return fis, len(src), nil
+}
+
+// extractFile parses a file and extracts its symbols: one FileIndex from the file's adapter and
+// one per embedded region. It returns nothing for unsupported files or files that fail to parse;
+// tr records why, and how long parsing and extraction took.
+func (e *Engine) extractFile(path string, src []byte, tr *extractTrace) []*FileIndex {
+	if !e.buildMatches(path, src) {
+		tr.excluded = "build constraints"
+		return nil
+	}
+	var fis []*FileIndex
+	// Embedded regions: all of a host file (.vue, .md, ...), or part of one (cgo preambles)
+	if inj := e.pickInjector(path); inj != nil {
+		fis = e.extractInjected(path, src, inj, tr)
+	}
+	adapter := e.pickAdapter(path)
+	if adapter == nil {
+		return fis
+	}
+	fi, err := extractWith(adapter, path, src, tr)
+	if err != nil {
+		tr.errs = append(tr.errs, err)
+		return fis
+	}
+	fi.Generated = generatedSource(src)
+	return append(fis, fi)
+}
+
+// excludes returns why indexing leaves out a file with the given contents, or "" if it doesn't:
+// over Walk.MaxFileSize, binary, or excluded by the Build context. It applies to text that
+// doesn't come from a walk, such as an open document's.
+func (e *Engine) excludes(path string, src []byte) string {
+	switch {
+	case e.tooLarge(fileStamp{Size: int64(len(src))}, true):
+		return "too large"
+	case isBinary(src):
+		return "binary"
+	case !e.buildMatches(path, src):
+		return "build constraints"
+	}
+	return ""
+}
+
+// buildMatches reports whether the Build context lets a Go file through: its name suffixes,
+// //go:build lines and cgo use. Other files, and every file without a Build context, match.
+func (e *Engine) buildMatches(path string, src []byte) bool {
+	if e.Build == nil || !strings.HasSuffix(path, ".go") {
+		return true
+	}
+	bc := *e.Build
+	bc.OpenFile = func(string) (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(src)), nil }
+	ok, err := bc.MatchFile(filepath.Dir(path), filepath.Base(path))
+	return ok || err != nil // A header that doesn't parse is left to the adapter
+}
+
+// extractWith parses src with an adapter and extracts its symbols, recording syntax errors in
+// the FileIndex.
+func extractWith(adapter LanguageAdapter, path string, src []byte, tr *extractTrace) (*FileIndex, error) {
+	// Parse source code into syntax tree using tree-sitter
+	start := time.Now()
+	tree, err := adapter.Parse(path, src)
+	since(&tr.timing.Parse, start)
+	if err != nil {
+		return nil, fmt.Errorf("%s: parse: %w", adapter.Lang(), err)
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("%s: parse: no syntax tree", adapter.Lang())
+	}
+	// Extract symbols (defs, refs, imports) using language-specific queries
+	start = time.Now()
+	fi, err := adapter.Extract(path, src, tree)
+	since(&tr.timing.Extract, start)
+	if err != nil {
+		return nil, fmt.Errorf("%s: extract: %w", adapter.Lang(), err)
+	}
+	fi.SyntaxErrors = syntaxErrors(tree.RootNode())
+	return fi, nil
+}
+
+// extractInjected extracts the embedded regions of a host file. Each region is parsed and extracted
+// by the adapter for its language under the host path, its symbol IDs are given the region's
+// index (see regionFile), then its ranges are shifted onto the host file so symbols in e.g. a
+// Vue <script> block take part in cross-file resolution.
+func (e *Engine) extractInjected(path string, src []byte, inj Injector, tr *extractTrace) []*FileIndex {
+	regions, err := inj.Regions(path, src)
+	if err != nil {
+		tr.errs = append(tr.errs, fmt.Errorf("embedded regions: %w", err))
+		return nil
+	}
+	var fis []*FileIndex
+	for i, r := range regions {
+		adapter := e.adapterByLang(r.Lang)
+		if adapter == nil {
+			continue // No adapter registered for the embedded language
+		}
+		fi, err := extractWith(adapter, path, r.Src, tr)
+		if err != nil {
+			tr.errs = append(tr.errs, fmt.Errorf("region at %d:%d: %w", r.Start.Line, r.Start.Col, err))
+			continue
+		}
+		fis = append(fis, shiftFileIndex(qualifyRegion(fi, path, i+1), r.Start))
+	}
+	return fis
+}
+
+// UpdateFile re-indexes a single file after it changed on disk, using the path it was indexed
+// under. The file's previous definitions, references and occurrences are retracted and the new
+// ones merged in one atomic step; references elsewhere to symbols that no longer exist are
+// invalidated. A file that no adapter handles is simply removed from the index.
+func (e *Engine) UpdateFile(path string) error {
+	if err := e.updateFile(path); err != nil {
+		return err
+	}
+	e.Index.linkGenerated()
+	return nil
+}
+
+// updateFile is UpdateFile without relinking generated code, for callers that batch updates.
+func (e *Engine) updateFile(path string) error {
+	st, stamped := e.statSource(path)
+	var fis []*FileIndex
+	if !e.tooLarge(st, stamped) {
+		src, err := e.readSource(path)
+		if err != nil {
+			return err
+		}
+		if !isBinary(src) {
+			fis = e.extract(path, src, &extractTrace{})
+		}
+	}
+	if err := e.store().PutFile(path, fis); err != nil {
+		return err
+	}
+	if stamped {
+		e.stamp(path, st)
+	}
+	return nil
+}
+
+// RemoveFile retracts everything a deleted file contributed to the index.
+func (e *Engine) RemoveFile(path string) error {
+	e.setSourceFS(path, nil)
+	e.forget(path)
+	if err := e.store().DeleteFile(path); err != nil {
+		return err
+	}
+	e.Index.linkGenerated()
+	return nil
+}
+
+// tooLarge reports whether a file is over Walk.MaxFileSize, by its stamp.
+func (e *Engine) tooLarge(st fileStamp, stamped bool) bool {
+	return e.Walk.MaxFileSize > 0 && stamped && st.Size > e.Walk.MaxFileSize
+}
+
+// handles reports whether any adapter or injector indexes the file.
+func (e *Engine) handles(path string) bool {
+	return e.pickAdapter(path) != nil || e.pickInjector(path) != nil
+}
+
+// RenameFile moves a file's contribution from oldPath to newPath, re-indexing it from newPath.
+// Symbol IDs include the file, so references to the old IDs are invalidated.
+func (e *Engine) RenameFile(oldPath, newPath string) error {
+	if err := e.updateFile(newPath); err != nil {
+		return err
+	}
+	if oldPath != newPath {
+		e.forget(oldPath)
+		if err := e.store().DeleteFile(oldPath); err != nil {
+			return err
+		}
+	}
+	e.Index.linkGenerated()
+	return nil
+}
+
 // FindDefinitionAt performs "go to definition" lookup for the symbol at the specified cursor position.
 // Returns the definition location, candidate symbol IDs considered, and any error.
 // The lookup process: 1) Find occurrence at cursor, 2) Resolve to symbol candidates, 3) Return first matching definition.
 func (e *Engine) FindDefinitionAt(file string, line, col int) (DefLocation, []string, error) {
+	cands, err := e.candidatesAt(file, line, col)
+	if err != nil {
+		return DefLocation{}, cands, err
+	}
+
+	// Look up the first candidate that has a known definition in our index
+	idx := e.store()
+	for _, sid := range cands {
+		if def, ok := idx.Definition(sid); ok {
+			// Generated code jumps to the schema it was generated from
+			if to, ok := e.link(sid); ok {
+				if src, ok := idx.Definition(to); ok {
+					return src, cands, nil
+				}
+			}
+			return def, cands, nil
+		}
+	}
+	return DefLocation{}, cands, errors.New("definition not found")
+}
+
+// link returns the proto definition a generated symbol was generated from. The in-memory index
+// keeps these links up to date as files change; other stores have them worked out on demand.
+func (e *Engine) link(sid string) (string, bool) {
+	if e.Store == nil {
+		return e.Index.Link(sid)
+	}
+	return storeLink(e.Store, sid, func(file string) string {
+		src, _ := e.readSource(file)
+		return generatedSource(src)
+	})
+}
+
+// FindDeclarationAt performs "go to declaration" lookup for the symbol at the specified cursor position.
+// It prefers a declaration-only location (such as a C prototype in a header) and falls back to the
+// definition for languages that don't separate the two.
+func (e *Engine) FindDeclarationAt(file string, line, col int) (DefLocation, []string, error) {
+	cands, err := e.candidatesAt(file, line, col)
+	if err != nil {
+		return DefLocation{}, cands, err
+	}
+
+	idx := e.store()
+	for _, sid := range cands {
+		if def, ok := idx.Definition(sid); ok && def.Decl {
+			return def, cands, nil
+		}
+	}
+	for _, sid := range cands {
+		if def, ok := idx.Definition(sid); ok {
+			return def, cands, nil
+		}
+	}
+	return DefLocation{}, cands, errors.New("declaration not found")
+}
+
+// candidatesAt finds the occurrence at the cursor and lets its language adapter resolve it
+// to candidate symbol IDs, in the adapter's priority order.
+func (e *Engine) candidatesAt(file string, line, col int) ([]string, error) {
 	// Normalize the file path to match how it's stored in the index
 	normalizedFile := filepath.ToSlash(strings.TrimPrefix(file, "./"))
 
-	// Get all symbol occurrences for this file from the index
-	occs := e.GetFileOccurrences(normalizedFile)
-
 	// Find the specific occurrence that contains the cursor position
-	occ, ok := pickOccurrence(occs, line, col)
+	idx := e.store()
+	occ, ok := idx.OccurrenceAt(normalizedFile, Pos{Line: line, Col: col})
 	if !ok {
-		return DefLocation{}, nil, errors.New("no identifier at position")
+		return nil, errors.New("no identifier at position")
+	}
+
+	// Embedded region: resolve with the region's adapter, in region coordinates
+	src, _ := e.readSource(file)
+	if inj := e.pickInjector(file); inj != nil {
+		regions, _ := inj.Regions(file, src)
+		for i, r := range regions {
+			if a := e.adapterByLang(r.Lang); a != nil && r.contains(line, col) {
+				occ.Rng = Range{Start: unshiftPos(occ.Rng.Start, r.Start), End: unshiftPos(occ.Rng.End, r.Start)}
+				return e.resolve(a, file, r.Src, occ, regionStore{idx, normalizedFile, i + 1}), nil
+			}
+		}
 	}
 
 	// Get the language adapter for this file type
 	adapter := e.pickAdapter(file)
 	if adapter == nil {
-		return DefLocation{}, nil, errors.New("no adapter for file")
+		return nil, errors.New("no adapter for file")
 	}
 
 	// Let the language adapter resolve the occurrence to candidate symbol IDs
-	src, _ := os.ReadFile(file)
-	cands := adapter.ResolveAt(file, src, occ, e.Index)
+	return e.resolve(adapter, file, src, occ, idx), nil
+}
 
-	// Look up the first candidate that has a known definition in our index
-	e.Index.mu.RLock()
-	defer e.Index.mu.RUnlock()
-	for _, sid := range cands {
-		if def, ok := e.Index.Defs[sid]; ok {
-			return def, cands, nil
+// fsResolver is implemented by adapters whose resolution reads project files that aren't
+// indexed, such as PHP's composer.json, so they read them where the file came from.
+type fsResolver interface {
+	resolveIn(fsys fs.FS, path string, src []byte, occ Occurrence, idx Store) []string
+}
+
+// forgetter is implemented by adapters that cache project files that aren't indexed, such as
+// PHP's composer.json, so removing or renaming a file or directory drops what they hold of it.
+type forgetter interface {
+	forget(path string)
+}
+
+// forget tells the adapters that cache unindexed files that path, a file or directory, is gone.
+func (e *Engine) forget(path string) {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
+	for _, a := range e.Adapters {
+		if f, ok := a.(forgetter); ok {
+			f.forget(path)
 		}
 	}
-	return DefLocation{}, cands, errors.New("definition not found")
+}
+
+// resolve lets an adapter resolve an occurrence of file to candidate symbol IDs.
+func (e *Engine) resolve(a LanguageAdapter, file string, src []byte, occ Occurrence, idx Store) []string {
+	if r, ok := a.(fsResolver); ok {
+		return r.resolveIn(e.sourceFS(file), file, src, occ, idx)
+	}
+	return a.ResolveAt(file, src, occ, idx)
 }
 
 func (e *Engine) FindReferences(symbolID string) ([]RefLocation, error) {
-	e.Index.mu.RLock()
-	defer e.Index.mu.RUnlock()
-	refs := e.Index.Refs[symbolID]
-	cp := make([]RefLocation, len(refs))
-	copy(cp, refs)
-	return cp, nil
+	return e.store().References(symbolID), nil
 }
 
 func (e *Engine) GetDefinitions() map[string]DefLocation {
-	e.Index.mu.RLock()
-	defer e.Index.mu.RUnlock()
-	out := make(map[string]DefLocation, len(e.Index.Defs))
-	maps.Copy(out, e.Index.Defs)
+	out := map[string]DefLocation{}
+	e.eachDef(func(sid string, d DefLocation) { out[sid] = d })
 	return out
 }
 
 // GetDefinitionTree returns a list of all definitions in the project, sorted by file.
 func (e *Engine) GetDefinitionTree() []DefLocation {
-	e.Index.mu.RLock()
-	defer e.Index.mu.RUnlock()
-	out := make([]DefLocation, 0, len(e.Index.Defs))
-	for _, v := range e.Index.Defs {
-		out = append(out, v)
-	}
+	var out []DefLocation
+	e.eachDef(func(_ string, d DefLocation) { out = append(out, d) })
 	// Sort by file name, then by def kind, then by def name
 	sort.Slice(out, func(i, j int) bool {
 		if out[i].File != out[j].File {
@@ -272,25 +1011,67 @@ func (e *Engine) GetDefinitionTree() []DefLocation {
 }
 
 func (e *Engine) GetFileOccurrences(file string) []Occurrence {
-	e.Index.mu.RLock()
-	defer e.Index.mu.RUnlock()
-	cp := make([]Occurrence, len(e.Index.FileOcc[file]))
-	copy(cp, e.Index.FileOcc[file])
-	return cp
+	return e.store().Occurrences(file)
 }
 
-// pickOccurrence finds the symbol occurrence that contains the given cursor position.
-// Used by FindDefinitionAt to identify which symbol the user is asking about.
-// Returns the occurrence and true if found, or empty occurrence and false if not found.
-func pickOccurrence(occs []Occurrence, line, col int) (Occurrence, bool) {
-	pt := Pos{Line: line, Col: col}
-	// Check each occurrence to see if the cursor position falls within its range
-	for _, o := range occs {
-		if beforeOrEq(o.Rng.Start, pt) && beforeOrEq(pt, o.Rng.End) {
-			return o, true
+// sortOccurrences orders a file's occurrences by start position, enclosing ones first, and
+// links each to the nearest earlier occurrence that ends at or after it, for pickOccurrence.
+func sortOccurrences(occs []packedOcc) {
+	slices.SortStableFunc(occs, func(a, b packedOcc) int {
+		if c := comparePos(a.rng.sl, a.rng.sc, b.rng.sl, b.rng.sc); c != 0 {
+			return c
+		}
+		return comparePos(b.rng.el, b.rng.ec, a.rng.el, a.rng.ec) // Longer first
+	})
+	// Stack of the occurrences no later one ends after, by decreasing end
+	var stack []int32
+	for i := range occs {
+		r := occs[i].rng
+		for len(stack) > 0 {
+			top := occs[stack[len(stack)-1]].rng
+			if comparePos(top.el, top.ec, r.el, r.ec) >= 0 {
+				break
+			}
+			stack = stack[:len(stack)-1]
 		}
+		occs[i].outer = -1
+		if len(stack) > 0 {
+			occs[i].outer = stack[len(stack)-1]
+		}
+		stack = append(stack, int32(i))
+	}
+}
+
+func comparePos(al, ac, bl, bc uint32) int {
+	if al != bl {
+		return cmp.Compare(al, bl)
+	}
+	return cmp.Compare(ac, bc)
+}
+
+// pickOccurrence finds the occurrence that contains the given cursor position among a file's
+// occurrences, as ordered by sortOccurrences. Used by FindDefinitionAt to identify which symbol
+// the user is asking about.
+// Definitions span their whole declaration, so the innermost (latest-starting) occurrence wins:
+// a reference inside a function body is picked over the function itself.
+// The last occurrence starting at or before pos is found by binary search; if it ends before
+// pos, the search follows outer links, each ending later, so it costs the nesting depth at most.
+func pickOccurrence(occs []packedOcc, pos Pos) (int, bool) {
+	l, c := uint32(pos.Line), uint32(pos.Col)
+	i := sort.Search(len(occs), func(i int) bool {
+		return comparePos(occs[i].rng.sl, occs[i].rng.sc, l, c) > 0
+	}) - 1
+	for i >= 0 && comparePos(occs[i].rng.el, occs[i].rng.ec, l, c) < 0 {
+		i = int(occs[i].outer)
+	}
+	if i < 0 {
+		return 0, false
+	}
+	// Of identical ranges, the one indexed first wins
+	for i > 0 && occs[i-1].rng == occs[i].rng {
+		i--
 	}
-	return Occurrence{}, false
+	return i, true
 }
 
 func beforeOrEq(a, b Pos) bool {
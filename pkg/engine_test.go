@@ -0,0 +1,494 @@
+package xref
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// defNames returns the names of the definitions in file.
+func defNames(e *Engine, file string) []string {
+	var out []string
+	for _, d := range e.GetDefinitions() {
+		if d.File == file {
+			out = append(out, d.Name)
+		}
+	}
+	return out
+}
+
+// TestReindexDropsExcludedFile indexes a file, changes it so that indexing now leaves it out,
+// and checks that re-indexing drops what it contributed before and records its new stamp.
+func TestReindexDropsExcludedFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, e *Engine, path string)
+	}{
+		{
+			name: "too large",
+			change: func(t *testing.T, e *Engine, path string) {
+				e.Walk.MaxFileSize = 64
+				writeTree(t, filepath.Dir(path), map[string]string{"a.go": "package a\n\nfunc Gone() {}\n\n" + strings.Repeat("// padding\n", 10)})
+			},
+		},
+		{
+			name: "binary",
+			change: func(t *testing.T, e *Engine, path string) {
+				writeTree(t, filepath.Dir(path), map[string]string{"a.go": "package a\n\nfunc Gone() {}\n\x00\x01"})
+			},
+		},
+		{
+			name: "build constraints",
+			change: func(t *testing.T, e *Engine, path string) {
+				bc := build.Default
+				e.Build = &bc
+				writeTree(t, filepath.Dir(path), map[string]string{"a.go": "//go:build ignore\n\npackage a\n\nfunc Gone() {}\n"})
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, how := range []string{"IndexPaths", "UpdateFile"} {
+			t.Run(tt.name+"/"+how, func(t *testing.T) {
+				dir := t.TempDir()
+				writeTree(t, dir, map[string]string{
+					"a.go": "package a\n\nfunc Gone() {}\n",
+					"b.go": "package a\n\nfunc Kept() { Gone() }\n",
+				})
+				a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+				e, err := New(WithLanguages("go"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := e.IndexPaths(dir); err != nil {
+					t.Fatal(err)
+				}
+				if got := defNames(e, a); len(got) != 1 {
+					t.Fatalf("definitions of a.go: %v, want Gone", got)
+				}
+
+				tt.change(t, e, a)
+				if how == "IndexPaths" {
+					err = e.IndexPaths(dir)
+				} else {
+					err = e.UpdateFile(a)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := defNames(e, a); len(got) != 0 {
+					t.Errorf("definitions of a.go after %s: %v, want none", how, got)
+				}
+				if occs := e.Index.Occurrences(a); len(occs) != 0 {
+					t.Errorf("occurrences of a.go after %s: %v, want none", how, occs)
+				}
+				if got := defNames(e, b); len(got) != 1 {
+					t.Errorf("definitions of b.go: %v, want Kept", got)
+				}
+				info, err := os.Stat(a)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if st, ok := e.Index.stampOf(a); !ok || st.Size != info.Size() {
+					t.Errorf("stamp of a.go: %v, %t; want size %d", st, ok, info.Size())
+				}
+			})
+		}
+	}
+}
+
+// TestPutFileInvalidatesResolvedOccurrences checks that removing a symbol clears occurrences
+// of other files resolved to it, and only those.
+func TestPutFileInvalidatesResolvedOccurrences(t *testing.T) {
+	def := func(file, name string) *FileIndex {
+		sid := symbolID("go", file, "", name)
+		rng := Range{Start: Pos{Line: 1, Col: 1}, End: Pos{Line: 1, Col: 10}}
+		return &FileIndex{
+			Lang: "go", File: file,
+			Defs:        map[string]DefLocation{sid: {Lang: "go", File: file, Name: name, Kind: "func", Rng: rng}},
+			Occurrences: []Occurrence{{Name: name, KindHint: "def", Rng: rng, SymbolID: sid}},
+		}
+	}
+	ref := func(line int, name, sid string) Occurrence {
+		return Occurrence{Name: name, KindHint: "ref", Rng: Range{Start: Pos{Line: line, Col: 1}, End: Pos{Line: line, Col: 5}}, SymbolID: sid}
+	}
+	x, y := symbolID("go", "x.go", "", "X"), symbolID("go", "y.go", "", "Y")
+	pi := newProjectIndex()
+	pi.PutFile("x.go", []*FileIndex{def("x.go", "X")})
+	pi.PutFile("y.go", []*FileIndex{def("y.go", "Y")})
+	pi.PutFile("z.go", []*FileIndex{{
+		Lang: "go", File: "z.go",
+		Refs:        map[string][]RefLocation{x: {{File: "z.go", Lang: "go", Rng: Range{Start: Pos{Line: 3, Col: 1}, End: Pos{Line: 3, Col: 5}}}}},
+		Occurrences: []Occurrence{ref(3, "X", x), ref(2, "Y", y), ref(4, "X", x)},
+	}})
+
+	resolved := func() map[int]string {
+		out := map[int]string{}
+		for _, o := range pi.Occurrences("z.go") {
+			out[o.Rng.Start.Line] = o.SymbolID
+		}
+		return out
+	}
+	if got := resolved(); got[2] != y || got[3] != x || got[4] != x {
+		t.Fatalf("occurrences of z.go resolved to %v", got)
+	}
+
+	pi.PutFile("x.go", []*FileIndex{def("x.go", "X")}) // Still defines X
+	if got := resolved(); got[3] != x || got[4] != x || len(pi.References(x)) != 1 {
+		t.Errorf("after re-indexing x.go unchanged: resolved %v, references %v", got, pi.References(x))
+	}
+
+	pi.DeleteFile("x.go")
+	if got := resolved(); got[2] != y || got[3] != "" || got[4] != "" {
+		t.Errorf("after removing X: resolved %v, want only Y kept", got)
+	}
+	if refs := pi.References(x); len(refs) != 0 {
+		t.Errorf("references to removed X: %v", refs)
+	}
+	if len(pi.occRefs) != 1 {
+		t.Errorf("reverse occurrence map has %d symbols, want only Y", len(pi.occRefs))
+	}
+
+	pi.DeleteFile("z.go")
+	if len(pi.occRefs) != 0 {
+		t.Errorf("reverse occurrence map keeps %v after removing z.go", pi.occRefs)
+	}
+}
+
+// TestOccurrenceAt picks occurrences in nested and sibling ranges, and compares the binary
+// search over sorted occurrences with a scan of all of them at every position of the file.
+func TestOccurrenceAt(t *testing.T) {
+	occ := func(name string, sl, sc, el, ec int) Occurrence {
+		return Occurrence{Name: name, KindHint: "ref", Rng: Range{Start: Pos{sl, sc}, End: Pos{el, ec}}}
+	}
+	// Out of source order, as adapters may list them
+	occs := []Occurrence{
+		occ("c", 4, 10, 4, 12),
+		occ("F", 1, 1, 5, 2),
+		occ("b", 3, 5, 3, 6),
+		occ("G", 3, 1, 4, 20),
+		occ("a", 2, 2, 2, 3),
+		occ("a2", 2, 2, 2, 3), // Same range as a, indexed later
+	}
+	pi := newProjectIndex()
+	pi.PutFile("f.go", []*FileIndex{{Lang: "go", File: "f.go", Occurrences: occs}})
+
+	tests := []struct {
+		name      string
+		line, col int
+		want      string // name of the occurrence, "" for none
+	}{
+		{"before everything", 1, 0, ""},
+		{"start of the outermost", 1, 1, "F"},
+		{"identical ranges, the first indexed", 2, 3, "a"},
+		{"innermost of three", 3, 5, "b"},
+		{"after a nested one ends", 3, 10, "G"},
+		{"after two nested ones end", 4, 25, "F"},
+		{"end of the outermost", 5, 2, "F"},
+		{"after everything", 6, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, ok := pi.OccurrenceAt("f.go", Pos{tt.line, tt.col})
+			if ok != (tt.want != "") || o.Name != tt.want {
+				t.Errorf("occurrence %q, %t; want %q", o.Name, ok, tt.want)
+			}
+		})
+	}
+
+	// The innermost occurrence containing pos, latest-starting then shortest, first indexed on ties
+	scan := func(pos Pos) string {
+		var best *Occurrence
+		for i, o := range occs {
+			if !beforeOrEq(o.Rng.Start, pos) || !beforeOrEq(pos, o.Rng.End) {
+				continue
+			}
+			if best == nil || best.Rng.Start != o.Rng.Start && beforeOrEq(best.Rng.Start, o.Rng.Start) ||
+				best.Rng.Start == o.Rng.Start && beforeOrEq(o.Rng.End, best.Rng.End) && o.Rng.End != best.Rng.End {
+				best = &occs[i]
+			}
+		}
+		if best == nil {
+			return ""
+		}
+		return best.Name
+	}
+	for line := 0; line <= 6; line++ {
+		for col := 0; col <= 30; col++ {
+			pos := Pos{line, col}
+			o, _ := pi.OccurrenceAt("f.go", pos)
+			if want := scan(pos); o.Name != want {
+				t.Errorf("at %d:%d: occurrence %q, want %q", line, col, o.Name, want)
+			}
+		}
+	}
+	if o, ok := pi.OccurrenceAt("other.go", Pos{1, 1}); ok {
+		t.Errorf("occurrence %q in a file that isn't indexed", o.Name)
+	}
+}
+
+// TestFileLookup checks that same-file lookups return a file's definitions of a name in source
+// order, and leave out other files, other languages and symbols another file has redefined.
+func TestFileLookup(t *testing.T) {
+	def := func(lang, file, sid, name string, line int) *FileIndex {
+		return &FileIndex{Lang: lang, File: file, Defs: map[string]DefLocation{
+			sid: {Lang: lang, File: file, Name: name, Kind: "func", Rng: Range{Start: Pos{line, 1}, End: Pos{line, 10}}},
+		}}
+	}
+	pi := newProjectIndex()
+	pi.PutFile("a.java", []*FileIndex{
+		def("java", "a.java", "java::a.java::A.add(String)", "add", 5),
+		def("java", "a.java", "java::a.java::A.add(int)", "add", 2),
+		def("java", "a.java", "java::a.java::A.add(long)", "add", 9),
+		def("java", "a.java", "java::a.java::A.sub", "sub", 3),
+	})
+	pi.PutFile("b.java", []*FileIndex{def("java", "b.java", "java::b.java::B.add", "add", 1)})
+	pi.PutFile("a.kt", []*FileIndex{def("kotlin", "a.kt", "kotlin::a.kt::add", "add", 1)})
+
+	want := []string{"java::a.java::A.add(int)", "java::a.java::A.add(String)", "java::a.java::A.add(long)"}
+	if got := pi.FileLookup("a.java", "java", "add"); !slices.Equal(got, want) {
+		t.Errorf("add in a.java: %q, want %q", got, want)
+	}
+	for _, q := range [][3]string{{"a.java", "kotlin", "add"}, {"a.java", "java", "mul"}, {"c.java", "java", "add"}} {
+		if got := pi.FileLookup(q[0], q[1], q[2]); len(got) != 0 {
+			t.Errorf("%s in %s (%s): %q, want none", q[2], q[0], q[1], got)
+		}
+	}
+
+	// A symbol ID defined again by another file belongs to that file now
+	pi.PutFile("c.java", []*FileIndex{def("java", "c.java", "java::a.java::A.add(long)", "add", 1)})
+	if got := pi.FileLookup("a.java", "java", "add"); !slices.Equal(got, want[:2]) {
+		t.Errorf("add in a.java once c.java redefines add(long): %q, want %q", got, want[:2])
+	}
+	pi.DeleteFile("a.java")
+	if got := pi.FileLookup("a.java", "java", "add"); len(got) != 0 {
+		t.Errorf("add in a.java after removing it: %q", got)
+	}
+}
+
+// TestLinkGeneratedRelinksAffectedFiles changes .proto and generated files and checks that the
+// links follow, and that only the generated files named after a changed .proto are relinked.
+func TestLinkGeneratedRelinksAffectedFiles(t *testing.T) {
+	proto := func(field string) string {
+		return "syntax = \"proto3\";\npackage acme;\nmessage User {\n  string " + field + " = 1;\n}\n"
+	}
+	getter := func(name string) string {
+		return "// source: users.proto\n\npackage acme\n\ntype User struct{}\n\nfunc (x *User) " + name + "() string { return \"\" }\n"
+	}
+	fsys := fstest.MapFS{
+		"users.proto":     {Data: []byte(proto("user_id"))},
+		"gen/users.pb.go": {Data: []byte(getter("GetUserId"))},
+		"orders.proto":    {Data: []byte("syntax = \"proto3\";\npackage acme;\nmessage Order {\n  string order_id = 1;\n}\n")},
+		"gen/orders.pb.go": {Data: []byte("package acme\n\ntype Order struct{}\n\n" +
+			"func (x *Order) GetOrderId() string { return \"\" }\n")},
+	}
+	e, err := New(WithLanguages("go", "proto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	link := func(sid string) string {
+		to, _ := e.Index.Link(sid)
+		return to
+	}
+	getUserID, getUID := "go::gen/users.pb.go::User.GetUserId", "go::gen/users.pb.go::User.GetUid"
+	getOrderID := "go::gen/orders.pb.go::Order.GetOrderId"
+	if link(getUserID) != "proto::users.proto::acme.User.user_id" || link(getOrderID) != "proto::orders.proto::acme.Order.order_id" {
+		t.Fatalf("links %q, %q", link(getUserID), link(getOrderID))
+	}
+
+	reindex := func(path, src string) {
+		t.Helper()
+		fsys[path] = &fstest.MapFile{Data: []byte(src)}
+		if err := e.IndexFS(fsys, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fis := e.extractFile("users.proto", []byte(proto("uid")), &extractTrace{})
+	e.Index.PutFile("users.proto", fis)
+	if n := len(e.Index.relink); n != 1 {
+		t.Errorf("changing users.proto put %d generated files up for relinking, want users.pb.go only", n)
+	}
+	if to := link(getUserID); to != "" {
+		t.Errorf("GetUserId still links to %q after user_id was removed", to)
+	}
+	reindex("users.proto", proto("uid"))
+	reindex("gen/users.pb.go", getter("GetUid"))
+	if to := link(getUID); to != "proto::users.proto::acme.User.uid" {
+		t.Errorf("GetUid links to %q", to)
+	}
+	if to := link(getOrderID); to != "proto::orders.proto::acme.Order.order_id" {
+		t.Errorf("GetOrderId links to %q after an unrelated change", to)
+	}
+
+	if err := e.RemoveFile("users.proto"); err != nil {
+		t.Fatal(err)
+	}
+	if to := link(getUID); to != "" {
+		t.Errorf("GetUid links to %q after users.proto was removed", to)
+	}
+	reindex("users.proto", proto("uid"))
+	if to := link(getUID); to != "proto::users.proto::acme.User.uid" {
+		t.Errorf("GetUid links to %q after users.proto came back", to)
+	}
+}
+
+// TestIndexProgress indexes a tree with files indexing skips (unsupported, too large, binary)
+// and a symlink, and checks the final progress report, with and without following symlinks.
+func TestIndexProgress(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":      "package a\n\nfunc A() {}\n",
+		"b.go":      "package a\n\nfunc B() {}\n",
+		"sub/c.go":  "package sub\n\nfunc C() {}\n",
+		"notes.txt": "not code\n",
+		"big.go":    "package a\n\n" + strings.Repeat("// padding\n", 20),
+		"bin.go":    "package a\x00\n",
+	}
+	writeTree(t, dir, files)
+	if err := os.Symlink(filepath.Join(dir, "a.go"), filepath.Join(dir, "link.go")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	var read int64 // Bytes of the files read: all but the one over the size limit
+	for name, src := range files {
+		if name != "big.go" {
+			read += int64(len(src))
+		}
+	}
+	tests := []struct {
+		name   string
+		follow bool
+		want   IndexProgress
+	}{
+		{"symlinks skipped", false, IndexProgress{Discovered: 6, Parsed: 3, Skipped: 3, Bytes: read, Done: true}},
+		{"symlinks followed", true, IndexProgress{Discovered: 7, Parsed: 4, Skipped: 3, Bytes: read + int64(len(files["a.go"])), Done: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(WithLanguages("go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Walk = WalkOptions{MaxFileSize: 100, FollowSymlinks: tt.follow}
+			var calls []IndexProgress
+			var inCall atomic.Bool
+			progress := func(p IndexProgress) {
+				if !inCall.CompareAndSwap(false, true) {
+					t.Error("overlapping Progress calls")
+				}
+				calls = append(calls, p)
+				inCall.Store(false)
+			}
+			if _, err := e.IndexPathsContext(context.Background(), IndexOptions{Progress: progress, ProgressInterval: time.Millisecond}, dir); err != nil {
+				t.Fatal(err)
+			}
+			n := len(calls)
+			if n == 0 || calls[n-1] != tt.want {
+				t.Fatalf("final progress %+v, want %+v", calls[n-1:], tt.want)
+			}
+			for _, p := range calls[:n-1] {
+				if p.Done {
+					t.Errorf("progress %+v before the final one is done", p)
+				}
+			}
+		})
+	}
+}
+
+// slowFS is a file system whose ReadFile takes a while and records the most calls in progress
+// at once, and which runs onRead before reading each Go file.
+type slowFS struct {
+	fstest.MapFS
+	onRead       func(name string)
+	mu           sync.Mutex
+	active, peak int
+}
+
+func (s *slowFS) ReadFile(name string) ([]byte, error) {
+	if strings.HasSuffix(name, ".go") {
+		s.mu.Lock()
+		s.active++
+		s.peak = max(s.peak, s.active)
+		s.mu.Unlock()
+		if s.onRead != nil {
+			s.onRead(name)
+		}
+		time.Sleep(2 * time.Millisecond)
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}
+	return s.MapFS.ReadFile(name)
+}
+
+// goFiles returns n small Go files, f00.go to f(n-1).go.
+func goFiles(n int) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := range n {
+		fsys[fmt.Sprintf("f%02d.go", i)] = &fstest.MapFile{Data: fmt.Appendf(nil, "package p\n\nfunc F%d() {}\n", i)}
+	}
+	return fsys
+}
+
+// TestIndexWorkers checks that no more files are read at once than there are workers.
+func TestIndexWorkers(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		fsys := &slowFS{MapFS: goFiles(40)}
+		e, err := New(WithLanguages("go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.indexFS(context.Background(), fsys, []string{"."}, IndexOptions{Workers: workers}); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.peak > workers || (workers > 1 && fsys.peak == 1) {
+			t.Errorf("%d workers read up to %d files at once", workers, fsys.peak)
+		}
+		if n := len(e.Index.Files()); n != 40 {
+			t.Errorf("%d workers indexed %d files, want 40", workers, n)
+		}
+	}
+}
+
+// TestIndexCancel cancels indexing before it starts and while files are read, and checks that
+// it stops there and returns the context's error.
+func TestIndexCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.indexFS(ctx, goFiles(10), []string{"."}, IndexOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled before starting: error %v, want context.Canceled", err)
+	}
+	if n := len(e.Index.Files()); n != 0 {
+		t.Errorf("cancelled before starting: indexed %d files", n)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	reads := 0
+	fsys := &slowFS{MapFS: goFiles(40), onRead: func(string) {
+		if reads++; reads == 3 {
+			cancel()
+		}
+	}}
+	if _, err := e.indexFS(ctx, fsys, []string{"."}, IndexOptions{Workers: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled while reading: error %v, want context.Canceled", err)
+	}
+	if n := len(e.Index.Files()); n != 3 {
+		t.Errorf("cancelled while reading the third file: indexed %d files, want 3", n)
+	}
+}
@@ -0,0 +1,325 @@
+package xref
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	extractMagic         = "XREFFIX\x00"
+	extractFormatVersion = 5
+)
+
+// ExtractCache stores the per-file results of adapter Parse and Extract on disk, keyed by what
+// they depend on: the adapter languages involved, the query-set version, the file's content
+// hash and its path (which symbol IDs embed). Unchanged files skip tree-sitter entirely on cold
+// starts and branch switches. When the cache grows past its size limit, the least recently used
+// entries are evicted. It is safe for concurrent use, but not shared between processes.
+type ExtractCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*extractEntry // key -> entry
+	size    int64
+}
+
+type extractEntry struct {
+	size int64
+	used time.Time
+}
+
+// OpenExtractCache opens (creating it if needed) the extraction cache in dir, limited to about
+// maxBytes on disk; a limit of 0 or less means unlimited.
+func OpenExtractCache(dir string, maxBytes int64) (*ExtractCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &ExtractCache{dir: dir, maxBytes: maxBytes, entries: map[string]*extractEntry{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".fix" {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			c.entries[strings.TrimSuffix(d.Name(), ".fix")] = &extractEntry{size: info.Size(), used: info.ModTime()}
+			c.size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Size returns the bytes the cache occupies on disk.
+func (c *ExtractCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *ExtractCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".fix")
+}
+
+// get returns the cached results for key, and why the file was excluded if it was. Unreadable
+// or corrupt entries are dropped.
+func (c *ExtractCache) get(key string) ([]*FileIndex, string, bool) {
+	c.mu.Lock()
+	ent, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, "", false
+	}
+	b, err := os.ReadFile(c.path(key))
+	var fis []*FileIndex
+	var excluded string
+	if err == nil {
+		fis, excluded, err = decodeFileIndexes(b)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.remove(key)
+		return nil, "", false
+	}
+	now := time.Now()
+	ent.used = now
+	os.Chtimes(c.path(key), now, now) // Persist recency for eviction after a restart
+	return fis, excluded, true
+}
+
+// put stores results for key. Failing to write only costs a future cache miss.
+func (c *ExtractCache) put(key string, fis []*FileIndex, excluded string) {
+	var buf bytes.Buffer
+	if encodeFileIndexes(&buf, fis, excluded) != nil {
+		return
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fix-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.size
+	}
+	c.entries[key] = &extractEntry{size: int64(buf.Len()), used: time.Now()}
+	c.size += int64(buf.Len())
+	c.evict()
+}
+
+// evict removes least recently used entries until the cache is a tenth under its limit, so
+// eviction doesn't run again on every insert. The caller must hold mu.
+func (c *ExtractCache) evict() {
+	if c.maxBytes <= 0 || c.size <= c.maxBytes {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.entries[keys[i]].used.Before(c.entries[keys[j]].used) })
+	for _, k := range keys {
+		if c.size <= c.maxBytes*9/10 {
+			break
+		}
+		c.remove(k)
+	}
+}
+
+// remove deletes an entry. The caller must hold mu.
+func (c *ExtractCache) remove(key string) {
+	if ent, ok := c.entries[key]; ok {
+		c.size -= ent.size
+		delete(c.entries, key)
+	}
+	os.Remove(c.path(key))
+}
+
+// extractKey returns the cache key of a file's extraction results, or false if no adapter or
+// injector handles the file.
+func (e *Engine) extractKey(path string, src []byte) (string, bool) {
+	h := sha256.New()
+	h.Write([]byte(e.extractVersion()))
+	h.Write([]byte{0})
+	handled := false
+	if a := e.pickAdapter(path); a != nil {
+		h.Write([]byte(a.Lang()))
+		handled = true
+	}
+	h.Write([]byte{0})
+	if e.pickInjector(path) != nil {
+		// Embedded regions can be in any language
+		h.Write([]byte(strings.Join(e.adapterLangs(), ",")))
+		handled = true
+	}
+	if !handled {
+		return "", false
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	sum := sha256.Sum256(src)
+	h.Write(sum[:])
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// extract is extractFile behind the engine's extraction cache, if it has one. Results of files
+// that failed to parse are not cached, so a transient failure isn't replayed.
+func (e *Engine) extract(path string, src []byte, tr *extractTrace) []*FileIndex {
+	if e.Extracts == nil {
+		return e.extractFile(path, src, tr)
+	}
+	key, ok := e.extractKey(path, src)
+	if !ok {
+		return nil
+	}
+	if fis, excluded, ok := e.Extracts.get(key); ok {
+		tr.excluded = excluded
+		return fis
+	}
+	fis := e.extractFile(path, src, tr)
+	if len(tr.errs) == 0 {
+		e.Extracts.put(key, fis, tr.excluded)
+	}
+	return fis
+}
+
+// encodeFileIndexes writes extraction results, and why the file was excluded if it was, in the
+// string-table format of index files.
+func encodeFileIndexes(buf *bytes.Buffer, fis []*FileIndex, excluded string) error {
+	iw := newIndexWriter()
+	iw.str(excluded)
+	iw.uvarint(uint64(len(fis)))
+	for _, fi := range fis {
+		iw.str(fi.Lang)
+		iw.str(fi.File)
+		iw.str(fi.Package)
+		iw.str(fi.Generated)
+
+		keys := make([]string, 0, len(fi.Imports))
+		for k := range fi.Imports {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		iw.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			iw.str(k)
+			iw.str(fi.Imports[k])
+		}
+
+		sids := make([]string, 0, len(fi.Defs))
+		for sid := range fi.Defs {
+			sids = append(sids, sid)
+		}
+		sort.Strings(sids)
+		iw.uvarint(uint64(len(sids)))
+		for _, sid := range sids {
+			d := fi.Defs[sid]
+			iw.str(sid)
+			iw.str(d.Lang)
+			iw.str(d.File)
+			iw.str(d.Name)
+			iw.str(d.Kind)
+			iw.bool(d.Decl)
+			iw.rng(d.Rng)
+		}
+
+		sids = sids[:0]
+		for sid := range fi.Refs {
+			sids = append(sids, sid)
+		}
+		sort.Strings(sids)
+		iw.uvarint(uint64(len(sids)))
+		for _, sid := range sids {
+			iw.str(sid)
+			iw.uvarint(uint64(len(fi.Refs[sid])))
+			for _, r := range fi.Refs[sid] {
+				iw.str(r.Lang)
+				iw.str(r.File)
+				iw.rng(r.Rng)
+			}
+		}
+
+		iw.uvarint(uint64(len(fi.Occurrences)))
+		for _, o := range fi.Occurrences {
+			iw.str(o.Name)
+			iw.str(o.KindHint)
+			iw.str(o.SymbolID)
+			iw.rng(o.Rng)
+		}
+
+		iw.uvarint(uint64(len(fi.SyntaxErrors)))
+		for _, r := range fi.SyntaxErrors {
+			iw.rng(r)
+		}
+	}
+	return iw.writeTo(buf, extractMagic, extractFormatVersion)
+}
+
+func decodeFileIndexes(b []byte) ([]*FileIndex, string, error) {
+	ir, err := newIndexReader(bytes.NewReader(b), extractMagic, extractFormatVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	excluded := ir.str()
+	var fis []*FileIndex
+	for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+		fi := &FileIndex{Lang: ir.str(), File: ir.str(), Package: ir.str(), Generated: ir.str(),
+			Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			k := ir.str()
+			fi.Imports[k] = ir.str()
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			sid := ir.str()
+			fi.Defs[sid] = DefLocation{Lang: ir.str(), File: ir.str(), Name: ir.str(), Kind: ir.str(), Decl: ir.bool(), Rng: ir.rng()}
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			sid := ir.str()
+			for m := ir.uvarint(); m > 0 && ir.err == nil; m-- {
+				fi.Refs[sid] = append(fi.Refs[sid], RefLocation{Lang: ir.str(), File: ir.str(), Rng: ir.rng()})
+			}
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: ir.str(), KindHint: ir.str(), SymbolID: ir.str(), Rng: ir.rng()})
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			fi.SyntaxErrors = append(fi.SyntaxErrors, ir.rng())
+		}
+		fis = append(fis, fi)
+	}
+	if ir.err != nil {
+		return nil, "", ir.err
+	}
+	return fis, excluded, nil
+}
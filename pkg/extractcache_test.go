@@ -0,0 +1,51 @@
+package xref
+
+import (
+	"context"
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExtractCacheKeepsExclusions checks that files skipped for their build constraints are
+// reported as such when their extraction comes from the cache.
+func TestExtractCacheKeepsExclusions(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":         "package a\n\nfunc A() {}\n",
+		"a_windows.go": "package a\n\nfunc W() {}\n",
+		"ignored.go":   "//go:build ignore\n\npackage a\n\nfunc I() {}\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, err := OpenExtractCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := build.Default
+	bc.GOOS = "linux"
+	for run := 1; run <= 2; run++ {
+		e, err := New(WithLanguages("go"), WithBuildContext(&bc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Extracts = cache
+		report, err := e.IndexPathsContext(context.Background(), IndexOptions{}, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := report.Excluded["build constraints"]; n != 2 {
+			t.Errorf("run %d: %d files excluded by build constraints, want 2", run, n)
+		}
+		if defs := e.GetDefinitions(); len(defs) != 1 {
+			t.Errorf("run %d: got %d definitions, want only A", run, len(defs))
+		}
+	}
+	if len(cache.entries) != len(files) {
+		t.Errorf("cache has %d entries, want one per file", len(cache.entries))
+	}
+}
@@ -0,0 +1,380 @@
+package xref
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobCache remembers what was extracted from git blobs, keyed by object hash, so indexing
+// another revision of a repository only parses the files that changed. A cache can be shared by
+// the engines of several revisions (they should use the same adapters) and is safe for
+// concurrent use.
+type BlobCache struct {
+	mu sync.Mutex
+	m  map[blobKey]blobEntry
+}
+
+// blobEntry is what was extracted from a blob, or why it was excluded (see extractTrace).
+type blobEntry struct {
+	fis      []*FileIndex
+	excluded string
+}
+
+// blobKey identifies an extraction result. Symbol IDs embed the file path, so a blob that moved
+// is extracted again under its new path.
+type blobKey struct {
+	hash, path string
+	queries    string // Engine.extractVersion: other queries extract other results
+	size       int64  // not part of the identity, but fixed by the hash
+}
+
+// NewBlobCache creates an empty blob cache.
+func NewBlobCache() *BlobCache {
+	return &BlobCache{m: map[blobKey]blobEntry{}}
+}
+
+// Len returns the number of cached blobs.
+func (c *BlobCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.m)
+}
+
+func (c *BlobCache) get(k blobKey) (blobEntry, bool) {
+	if c == nil {
+		return blobEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.m[k]
+	return ent, ok
+}
+
+func (c *BlobCache) put(k blobKey, ent blobEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[k] = ent
+}
+
+// IndexRevision indexes the tree of a commit in the git repository at repo without checking it
+// out, reading blobs from the object database with the local git plumbing. Roots are paths in the
+// tree (the whole tree by default); indexed files are named by their slash-separated path from
+// the repository root, and later queries read them back from the same commit.
+//
+// Results are cached per blob in e.Blobs, so indexing a second revision only parses the files
+// that differ. Files indexed from another revision of the same repository that this call did not
+// index are removed, so one engine can move between branches.
+func (e *Engine) IndexRevision(repo, rev string, roots ...string) error {
+	g, err := openGitRevision(repo, rev)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	if err := g.startBatch(); err != nil {
+		return err
+	}
+	_, err = e.indexFS(context.Background(), g, roots, IndexOptions{})
+	g.stopBatch()
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	e.docMu.Lock()
+	for p, fsys := range e.sources {
+		if old, ok := fsys.(*gitFS); ok && old != g && old.repo == g.repo {
+			stale = append(stale, p)
+		}
+	}
+	e.docMu.Unlock()
+	if len(stale) > 0 {
+		for _, p := range stale {
+			e.setSourceFS(p, nil)
+			if err := e.store().DeleteFile(p); err != nil {
+				return err
+			}
+		}
+		e.Index.linkGenerated()
+	}
+	return nil
+}
+
+// blobKeyOf returns the cache key of a file when fsys is a git revision.
+func blobKeyOf(fsys fs.FS, name string) (blobKey, bool) {
+	g, ok := fsys.(*gitFS)
+	if !ok {
+		return blobKey{}, false
+	}
+	b, ok := g.files[name]
+	if !ok {
+		return blobKey{}, false
+	}
+	return blobKey{hash: b.hash, path: name, size: b.size}, true
+}
+
+// gitFS is the read-only tree of one commit, listed once with git ls-tree. Blob contents are read
+// through a git cat-file --batch process while indexing, and one at a time afterwards.
+type gitFS struct {
+	repo   string
+	commit string
+	files  map[string]gitBlob       // slash path -> blob
+	dirs   map[string][]fs.DirEntry // slash path ("." for the root) -> sorted entries
+
+	mu    sync.Mutex
+	batch *exec.Cmd
+	in    io.WriteCloser
+	out   *bufio.Reader
+}
+
+type gitBlob struct {
+	hash string
+	size int64
+	mode fs.FileMode
+}
+
+// openGitRevision resolves rev to a commit and lists its tree. Submodules and symbolic links are
+// left out: their contents are not blobs of this repository.
+func openGitRevision(repo, rev string) (*gitFS, error) {
+	out, err := gitOutput(repo, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	g := &gitFS{repo: repo, commit: strings.TrimSpace(string(out)), files: map[string]gitBlob{}, dirs: map[string][]fs.DirEntry{}}
+	out, err = gitOutput(repo, "ls-tree", "-r", "-z", "--long", "--full-tree", g.commit)
+	if err != nil {
+		return nil, err
+	}
+	g.dirs["."] = nil
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		meta, name, ok := strings.Cut(string(rec), "\t")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(meta)
+		if len(f) != 4 || f[1] != "blob" || f[0] == "120000" {
+			continue
+		}
+		size, _ := strconv.ParseInt(f[3], 10, 64)
+		mode := fs.FileMode(0o644)
+		if f[0] == "100755" {
+			mode = 0o755
+		}
+		b := gitBlob{hash: f[2], size: size, mode: mode}
+		g.files[name] = b
+		g.addEntry(name, gitInfo{name: path.Base(name), size: size, mode: mode})
+	}
+	for _, entries := range g.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return g, nil
+}
+
+// addEntry adds a file to its directory, creating the directories above it as needed.
+func (g *gitFS) addEntry(name string, info gitInfo) {
+	dir := path.Dir(name)
+	_, known := g.dirs[dir]
+	g.dirs[dir] = append(g.dirs[dir], fs.FileInfoToDirEntry(info))
+	if !known {
+		g.addEntry(dir, gitInfo{name: path.Base(dir), mode: fs.ModeDir | 0o755})
+	}
+}
+
+func gitOutput(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// startBatch starts the git cat-file --batch process that serves blob reads while indexing.
+func (g *gitFS) startBatch() error {
+	cmd := exec.Command("git", "-C", g.repo, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.batch, g.in, g.out = cmd, in, bufio.NewReader(out)
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *gitFS) stopBatch() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.batch == nil {
+		return
+	}
+	g.in.Close()
+	g.batch.Wait()
+	g.batch, g.in, g.out = nil, nil, nil
+}
+
+// readBlob returns a blob's contents, from the batch process if it is running.
+func (g *gitFS) readBlob(hash string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.batch == nil {
+		return gitOutput(g.repo, "cat-file", "blob", hash)
+	}
+	if _, err := io.WriteString(g.in, hash+"\n"); err != nil {
+		return nil, err
+	}
+	// <object> SP <type> SP <size> LF <contents> LF, or <object> SP missing LF
+	header, err := g.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(header)
+	if len(f) != 3 {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(f[2])
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	buf := make([]byte, size+1)
+	if _, err := io.ReadFull(g.out, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+func (g *gitFS) Open(name string) (fs.File, error) {
+	info, err := g.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &gitDir{info: info, entries: g.dirs[name]}, nil
+	}
+	return &gitFile{g: g, name: name, info: info}, nil
+}
+
+func (g *gitFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if b, ok := g.files[name]; ok {
+		return gitInfo{name: path.Base(name), size: b.size, mode: b.mode}, nil
+	}
+	if _, ok := g.dirs[name]; ok {
+		return gitInfo{name: path.Base(name), mode: fs.ModeDir | 0o755}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (g *gitFS) ReadFile(name string) ([]byte, error) {
+	b, ok := g.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	src, err := g.readBlob(b.hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return src, nil
+}
+
+func (g *gitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := g.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+// gitInfo describes a file or directory of a commit. Git records no modification times.
+type gitInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i gitInfo) Name() string       { return i.name }
+func (i gitInfo) Size() int64        { return i.size }
+func (i gitInfo) Mode() fs.FileMode  { return i.mode }
+func (i gitInfo) ModTime() time.Time { return time.Time{} }
+func (i gitInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i gitInfo) Sys() any           { return nil }
+
+type gitFile struct {
+	g    *gitFS
+	name string
+	info fs.FileInfo
+	r    *bytes.Reader // loaded on first Read
+}
+
+func (f *gitFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *gitFile) Close() error               { return nil }
+
+func (f *gitFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		src, err := f.g.ReadFile(f.name)
+		if err != nil {
+			return 0, err
+		}
+		f.r = bytes.NewReader(src)
+	}
+	return f.r.Read(p)
+}
+
+type gitDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *gitDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *gitDir) Close() error               { return nil }
+
+func (d *gitDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *gitDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(n, len(rest))]
+	}
+	d.off += len(rest)
+	return rest, nil
+}
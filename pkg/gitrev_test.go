@@ -0,0 +1,97 @@
+package xref
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+// TestIndexRevision indexes two commits of a repository, one after the other and then from a
+// second engine sharing the blob cache, and checks the files and definitions of each, that only
+// blobs not seen before are added to the cache, and that cached results are used.
+func TestIndexRevision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=xref", "-c", "user.email=xref@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	writeTree(t, repo, map[string]string{
+		"a/a.go": "package a\n\nfunc Hello() {}\n",
+		"b/b.go": "package b\n\nfunc Use() { a.Hello() }\n",
+		"c/c.go": "package c\n\nfunc Old() {}\n",
+	})
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	git("tag", "first")
+	writeTree(t, repo, map[string]string{
+		"a/a.go": "package a\n\n// Hello greets.\nfunc Hello() {}\n",
+		"d/d.go": "package d\n\nfunc New() { a.Hello() }\n",
+	})
+	git("rm", "-q", "c/c.go")
+	git("add", "-A")
+	git("commit", "-q", "-m", "second")
+	// The work tree differs from both commits, which must not be read
+	writeTree(t, repo, map[string]string{"a/a.go": "package a\n"})
+
+	e, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(rev string, wantFiles []string, wantBlobs, helloLine int) {
+		t.Helper()
+		if got := e.Index.Files(); !slices.Equal(got, wantFiles) {
+			t.Errorf("%s: indexed %q, want %q", rev, got, wantFiles)
+		}
+		if n := e.Blobs.Len(); n != wantBlobs {
+			t.Errorf("%s: %d blobs cached, want %d", rev, n, wantBlobs)
+		}
+		d, _, err := e.FindDefinitionAt("b/b.go", 3, 16)
+		if err != nil || d.File != "a/a.go" || d.Rng.Start.Line != helloLine {
+			t.Errorf("%s: Hello defined in %s at line %d (%v), want a/a.go at line %d", rev, d.File, d.Rng.Start.Line, err, helloLine)
+		}
+	}
+
+	if err := e.IndexRevision(repo, "first"); err != nil {
+		t.Fatal(err)
+	}
+	check("first", []string{"a/a.go", "b/b.go", "c/c.go"}, 3, 3)
+	if err := e.IndexRevision(repo, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	check("HEAD", []string{"a/a.go", "b/b.go", "d/d.go"}, 5, 4) // New blobs of a/a.go and d/d.go only
+
+	shared, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared.Blobs = e.Blobs
+	e = shared
+	if err := e.IndexRevision(repo, "first"); err != nil {
+		t.Fatal(err)
+	}
+	check("first, shared cache", []string{"a/a.go", "b/b.go", "c/c.go"}, 5, 3)
+
+	// Cached results stand in for extraction: one saying c/c.go is binary keeps it out
+	for k := range e.Blobs.m {
+		if k.path == "c/c.go" {
+			e.Blobs.m[k] = blobEntry{excluded: "binary"}
+		}
+	}
+	if err := e.IndexRevision(repo, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.Index.Files(), []string{"a/a.go", "b/b.go"}; !slices.Equal(got, want) {
+		t.Errorf("with c/c.go cached as binary: indexed %q, want %q", got, want)
+	}
+
+	if err := e.IndexRevision(repo, "no-such-revision"); err == nil {
+		t.Error("indexing an unknown revision succeeded")
+	}
+}
@@ -8,11 +8,12 @@ import (
 // The visitor receives query captures and a function to resolve capture names by ID.
 // This is the core mechanism for extracting symbols from parsed code.
 func execQuery(src []byte, root *sitter.Node, q *sitter.Query, visit func([]sitter.QueryCapture, func(id uint32) string)) {
-	cur := sitter.NewQueryCursor()
-	defer cur.Close()
+	cur := cursorPool.Get().(*sitter.QueryCursor)
+	defer cursorPool.Put(cur)
 	
 	// Execute the query against the syntax tree starting from root
 	cur.Exec(q, root)
+	filter := hasPredicates(q)
 	
 	// Process each match found by the query
 	for {
@@ -20,6 +21,12 @@ func execQuery(src []byte, root *sitter.Node, q *sitter.Query, visit func([]sitt
 		if !ok {
 			break
 		}
+		// Drop matches failing the query's predicates (#eq?, #match?, ...)
+		if filter {
+			if m = cur.FilterPredicates(m, src); len(m.Captures) == 0 {
+				continue
+			}
+		}
 		// Call visitor with the captured nodes and name resolver
 		visit(m.Captures, q.CaptureNameForId)
 	}
@@ -61,3 +68,15 @@ func firstNonEmptyBy(src []byte, caps []sitter.QueryCapture, q *sitter.Query, na
 	}
 	return ""
 }
+
+// nodeByName returns the syntax node of a named capture from tree-sitter query results.
+// Adapters use it when they need to walk the tree around a capture (containers, parameters).
+// Returns nil if the named capture is not found.
+func nodeByName(caps []sitter.QueryCapture, q *sitter.Query, name string) *sitter.Node {
+	for _, c := range caps {
+		if q.CaptureNameForId(c.Index) == name {
+			return c.Node
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package xref
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is one pattern of a .gitignore-style file.
+type ignoreRule struct {
+	pattern  string // slash-separated glob, without the leading "!", leading "/" or trailing "/"
+	negate   bool   // "!pattern" re-includes what an earlier pattern excluded
+	dirOnly  bool   // "pattern/" matches directories only
+	anchored bool   // a pattern with a slash matches relative to its file's directory, else any base name
+}
+
+// parseIgnore parses the contents of a .gitignore-style file.
+func parseIgnore(b []byte) []ignoreRule {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line = trimTrailingSpaces(line); line == "" {
+			continue
+		}
+		var r ignoreRule
+		switch {
+		case line[0] == '!':
+			r.negate = true
+			line = line[1:]
+		case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		r.anchored = strings.Contains(line, "/")
+		r.pattern = strings.TrimPrefix(line, "/")
+		if r.pattern != "" {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// trimTrailingSpaces drops the trailing spaces of an ignore file line, up to one escaped with a
+// backslash ("foo\ "), which is kept without its backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		body := line[:len(line)-1]
+		if n := len(body) - len(strings.TrimRight(body, `\`)); n%2 == 1 {
+			return body[:len(body)-1] + " "
+		}
+		line = body
+	}
+	return line
+}
+
+// match reports whether the rule matches rel, a slash-separated path relative to the directory
+// of the rule's file.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return matchGlob(r.pattern, path.Base(rel))
+	}
+	return matchGlob(r.pattern, rel)
+}
+
+// matchGlob matches a slash-separated path against a glob in which "*", "?" and "[...]" match
+// within one path segment (as in path.Match) and a "**" segment matches any number of segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return true // Trailing "**" matches everything inside
+			}
+			for i := range name {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchAnyGlob reports whether rel matches one of the user's include or exclude globs. Globs
+// without a slash match the base name at any depth, like .gitignore patterns.
+func matchAnyGlob(globs []string, rel string) bool {
+	for _, g := range globs {
+		g = filepath.ToSlash(g)
+		if !strings.Contains(g, "/") {
+			if matchGlob(g, path.Base(rel)) {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.TrimPrefix(g, "/"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// globalIgnoreRules are the rules of git's global excludes file: core.excludesFile, or
+// $XDG_CONFIG_HOME/git/ignore by default.
+var globalIgnoreRules = sync.OnceValue(func() []ignoreRule {
+	file := ""
+	if out, err := exec.Command("git", "config", "--global", "--get", "core.excludesFile").Output(); err == nil {
+		file = strings.TrimSpace(string(out))
+		if rest, ok := strings.CutPrefix(file, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				file = filepath.Join(home, rest)
+			}
+		}
+	}
+	if file == "" {
+		if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+			file = filepath.Join(dir, "git", "ignore")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			file = filepath.Join(home, ".config", "git", "ignore")
+		}
+	}
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return parseIgnore(b)
+})
+
+// isBinary reports whether src looks like a binary file: as git decides, by a NUL byte in its
+// first 8000 bytes.
+func isBinary(src []byte) bool {
+	return bytes.IndexByte(src[:min(len(src), 8000)], 0) >= 0
+}
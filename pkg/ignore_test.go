@@ -0,0 +1,109 @@
+package xref
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// TestIgnoreFiles walks trees with .gitignore and .xrefignore files and checks which files are
+// let through.
+func TestIgnoreFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // path -> contents; other files are empty
+		want  []string          // files walked, ignore files included
+	}{
+		{
+			name:  "negation",
+			files: map[string]string{".gitignore": "*.log\n!keep.log\n", "a.log": "", "keep.log": "", "a.go": "", "sub/b.log": ""},
+			want:  []string{".gitignore", "a.go", "keep.log"},
+		},
+		{
+			name:  "later rules win",
+			files: map[string]string{".gitignore": "!keep.log\n*.log\n", "a.log": "", "keep.log": ""},
+			want:  []string{".gitignore"},
+		},
+		{
+			name:  "leading slash anchors",
+			files: map[string]string{".gitignore": "/build\n", "build/x.go": "", "sub/build/y.go": ""},
+			want:  []string{".gitignore", "sub/build/y.go"},
+		},
+		{
+			name:  "inner slash anchors",
+			files: map[string]string{".gitignore": "docs/*.md\n", "docs/a.md": "", "docs/api/b.md": "", "sub/docs/c.md": ""},
+			want:  []string{".gitignore", "docs/api/b.md", "sub/docs/c.md"},
+		},
+		{
+			name:  "anchored to the ignore file's directory",
+			files: map[string]string{"sub/.gitignore": "/gen.go\n", "gen.go": "", "sub/gen.go": "", "sub/deeper/gen.go": ""},
+			want:  []string{"gen.go", "sub/.gitignore", "sub/deeper/gen.go"},
+		},
+		{
+			name:  "trailing slash matches directories only",
+			files: map[string]string{".gitignore": "out/\n", "out/x.go": "", "sub/out": ""},
+			want:  []string{".gitignore", "sub/out"},
+		},
+		{
+			name: "double star",
+			files: map[string]string{".gitignore": "a/**/z.go\n**/gen/**\n", "a/z.go": "", "a/b/z.go": "", "a/b/c/z.go": "",
+				"b/z.go": "", "gen/x.go": "", "pkg/gen/y.go": "", "pkg/gen.go": ""},
+			want: []string{".gitignore", "b/z.go", "pkg/gen.go"},
+		},
+		{
+			name:  "re-include under an excluded directory's contents",
+			files: map[string]string{".gitignore": "vendor/*\n!vendor/keep/\n", "vendor/x.go": "", "vendor/keep/y.go": ""},
+			want:  []string{".gitignore", "vendor/keep/y.go"},
+		},
+		{
+			name:  "no re-include under an excluded directory",
+			files: map[string]string{".gitignore": "vendor/\n!vendor/keep.go\n", "vendor/keep.go": "", "a.go": ""},
+			want:  []string{".gitignore", "a.go"},
+		},
+		{
+			name:  "deeper ignore file wins",
+			files: map[string]string{".gitignore": "*.log\n", "sub/.gitignore": "!*.log\n", "a.log": "", "sub/b.log": ""},
+			want:  []string{".gitignore", "sub/.gitignore", "sub/b.log"},
+		},
+		{
+			name:  "xrefignore wins over gitignore",
+			files: map[string]string{".gitignore": "*.pb.go\n", ".xrefignore": "!*.pb.go\ntestdata/\n", "a.pb.go": "", "testdata/x.go": ""},
+			want:  []string{".gitignore", ".xrefignore", "a.pb.go"},
+		},
+		{
+			name:  "info exclude",
+			files: map[string]string{".git/info/exclude": "*.tmp\n", "a.tmp": "", "a.go": ""},
+			want:  []string{"a.go"},
+		},
+		{
+			name:  "trailing spaces",
+			files: map[string]string{".gitignore": "bar   \nfoo\\ \n   \n", "bar": "", "foo": "", "foo ": ""},
+			want:  []string{".gitignore", "foo"},
+		},
+		{
+			name:  "escaped specials",
+			files: map[string]string{".gitignore": "\\#notes\n\\!bang\n# comment\n", "#notes": "", "!bang": "", "comment": ""},
+			want:  []string{".gitignore", "comment"},
+		},
+	}
+	e, err := New(WithLanguages())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for name, data := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte(data)}
+			}
+			var got []string
+			e.newWalker(fsys).walk(".", func(p string) bool {
+				got = append(got, p)
+				return true
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("walked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
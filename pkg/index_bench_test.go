@@ -0,0 +1,247 @@
+package xref
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// writeSyntheticCorpus writes a Go code base shaped like a monorepo: deeply nested packages whose
+// files define functions, methods and types that reference each other. It returns the files.
+func writeSyntheticCorpus(tb testing.TB, root string, packages, filesPerPackage, funcsPerFile int) []string {
+	tb.Helper()
+	var files []string
+	for p := range packages {
+		dir := filepath.Join(root, "services", fmt.Sprintf("team%02d", p%7), fmt.Sprintf("component%03d", p), "internal", "pkg")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := range filesPerPackage {
+			var b strings.Builder
+			fmt.Fprintf(&b, "package pkg%d\n\n", p)
+			fmt.Fprintf(&b, "type Service%d struct {\n\tName string\n\tCount int\n}\n\n", f)
+			for i := range funcsPerFile {
+				fmt.Fprintf(&b, "func Handle%dx%d(s *Service%d, n int) int {\n", f, i, f)
+				fmt.Fprintf(&b, "\ttotal := n + s.Count\n")
+				if i > 0 {
+					fmt.Fprintf(&b, "\ttotal += Handle%dx%d(s, n-1)\n", f, i-1)
+				}
+				fmt.Fprintf(&b, "\treturn total\n}\n\n")
+			}
+			fmt.Fprintf(&b, "func (s *Service%d) Run() int { return Handle%dx0(s, s.Count) }\n", f, f)
+			path := filepath.Join(dir, fmt.Sprintf("service_handlers_%03d.go", f))
+			if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+				tb.Fatal(err)
+			}
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// legacyIndex is the layout ProjectIndex had before interning: full strings in every location,
+// occurrence and key. It is kept here as the baseline of BenchmarkIndexMemory.
+type legacyIndex struct {
+	Defs       map[string]DefLocation
+	Refs       map[string][]RefLocation
+	NameLookup map[string][]string
+	FileOcc    map[string][]Occurrence
+	fileDefs   map[string][]string
+	fileRefs   map[string][]string
+}
+
+func (li *legacyIndex) merge(fi *FileIndex) {
+	for sid, d := range fi.Defs {
+		li.Defs[sid] = d
+		key := d.Lang + ":" + d.Name
+		li.NameLookup[key] = append(li.NameLookup[key], sid)
+		li.fileDefs[fi.File] = append(li.fileDefs[fi.File], sid)
+	}
+	for sid, refs := range fi.Refs {
+		li.Refs[sid] = append(li.Refs[sid], refs...)
+		li.fileRefs[fi.File] = append(li.fileRefs[fi.File], sid)
+	}
+	li.FileOcc[fi.File] = append(li.FileOcc[fi.File], fi.Occurrences...)
+}
+
+// heapInUse returns the live heap after a full collection.
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// BenchmarkIndexMemory reports the heap retained per indexed symbol by the interned index and by
+// the previous map-of-strings layout, built from the same extraction results.
+func BenchmarkIndexMemory(b *testing.B) {
+	e, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	files := writeSyntheticCorpus(b, b.TempDir(), 40, 10, 25)
+	extract := func() ([]*FileIndex, int) {
+		var fis []*FileIndex
+		symbols := 0
+		for _, f := range files {
+			src, err := os.ReadFile(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, fi := range e.extractFile(f, src, &extractTrace{}) {
+				symbols += len(fi.Defs)
+				fis = append(fis, fi)
+			}
+		}
+		return fis, symbols
+	}
+
+	measure := func(b *testing.B, build func([]*FileIndex) any) {
+		var perSymbol float64
+		for range b.N {
+			b.StopTimer()
+			before := heapInUse()
+			fis, symbols := extract()
+			b.StartTimer()
+			idx := build(fis)
+			b.StopTimer()
+			fis = nil // Only what the index retains of the extraction results counts
+			after := heapInUse()
+			runtime.KeepAlive(idx)
+			perSymbol = (float64(after) - float64(before)) / float64(symbols)
+			b.StartTimer()
+		}
+		b.ReportMetric(perSymbol, "B/symbol")
+	}
+
+	b.Run("legacy", func(b *testing.B) {
+		measure(b, func(fis []*FileIndex) any {
+			li := &legacyIndex{
+				Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, NameLookup: map[string][]string{},
+				FileOcc: map[string][]Occurrence{}, fileDefs: map[string][]string{}, fileRefs: map[string][]string{},
+			}
+			for _, fi := range fis {
+				li.merge(fi)
+			}
+			return li
+		})
+	})
+	b.Run("interned", func(b *testing.B) {
+		measure(b, func(fis []*FileIndex) any {
+			pi := newProjectIndex()
+			for _, fi := range fis {
+				pi.merge(fi)
+			}
+			return pi
+		})
+	})
+}
+
+// syntheticSource returns a file of funcs functions calling each other, in Go, TypeScript or
+// Python, for benchmarks that need more than one language.
+func syntheticSource(lang string, funcs int) (string, []byte) {
+	var b strings.Builder
+	switch lang {
+	case "go":
+		b.WriteString("package bench\n\nimport \"fmt\"\n\n")
+		for i := range funcs {
+			fmt.Fprintf(&b, "func Handle%d(n int) int {\n\tfmt.Println(n)\n\treturn n + Handle%d(n-1)\n}\n\n", i, max(i-1, 0))
+		}
+		return "bench.go", []byte(b.String())
+	case "ts":
+		b.WriteString("import { log } from \"./log\";\n\n")
+		for i := range funcs {
+			fmt.Fprintf(&b, "export function handle%d(n: number): number {\n  log(n);\n  return n + handle%d(n - 1);\n}\n\n", i, max(i-1, 0))
+		}
+		return "bench.ts", []byte(b.String())
+	case "py":
+		b.WriteString("import logging\n\n")
+		for i := range funcs {
+			fmt.Fprintf(&b, "def handle%d(n):\n    logging.info(n)\n    return n + handle%d(n - 1)\n\n", i, max(i-1, 0))
+		}
+		return "bench.py", []byte(b.String())
+	}
+	return "", nil
+}
+
+// BenchmarkIndexPaths indexes the synthetic corpus end to end and reports files per second.
+func BenchmarkIndexPaths(b *testing.B) {
+	root := b.TempDir()
+	files := writeSyntheticCorpus(b, root, 20, 10, 25)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer() // A fresh engine per run, so nothing is indexed yet
+		e, err := New()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := e.IndexPaths(root); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(files)*b.N)/b.Elapsed().Seconds(), "files/s")
+}
+
+// BenchmarkExtract parses and extracts one file per language on a single goroutine, the work
+// each indexing worker repeats per file.
+func BenchmarkExtract(b *testing.B) {
+	e, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, lang := range []string{"go", "ts", "py"} {
+		b.Run(lang, func(b *testing.B) {
+			path, src := syntheticSource(lang, 50)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			for range b.N {
+				if fis := e.extractFile(path, src, &extractTrace{}); len(fis) != 1 {
+					b.Fatalf("extracted %d file indexes", len(fis))
+				}
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "files/s")
+		})
+	}
+}
+
+// BenchmarkQueryPass compares matching a language's queries in one combined walk of the tree
+// against one walk per query.
+func BenchmarkQueryPass(b *testing.B) {
+	e, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	path, src := syntheticSource("go", 50)
+	g := e.pickAdapter(path).(*goAdapter)
+	tree, err := g.Parse(path, src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	visit := func([]sitter.QueryCapture, func(uint32) string) {}
+	b.Run("separate", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			qp := lazyPass(src, tree.RootNode())
+			for _, q := range g.queries.parts {
+				qp.each(q, visit)
+			}
+		}
+	})
+	b.Run("combined", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			qp := g.queries.run(src, tree.RootNode())
+			for _, q := range g.queries.parts {
+				qp.each(q, visit)
+			}
+		}
+	})
+}
@@ -0,0 +1,324 @@
+package xref
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/html"
+	markdown "github.com/smacker/go-tree-sitter/markdown/tree-sitter-markdown"
+	"github.com/smacker/go-tree-sitter/svelte"
+)
+
+// Region is a span of a host file written in another language, such as the <script> block
+// of a Vue component or a fenced code block in Markdown.
+type Region struct {
+	Lang  string // LanguageAdapter.Lang() that should index the region, e.g. "ts"
+	Start Pos    // position of the region's first byte in the host file (1-based)
+	Src   []byte
+}
+
+// Injector finds embedded regions in host files, such as files no LanguageAdapter handles
+// directly or the cgo preamble of a Go file. The engine routes each region to the adapter for
+// its language and maps the resulting ranges back onto the host file.
+type Injector interface {
+	CanHandle(path string) bool
+	Regions(path string, src []byte) ([]Region, error)
+}
+
+// scriptInjector extracts <script> blocks from markup (HTML, Vue and Svelte single-file components).
+type scriptInjector struct {
+	lang    *sitter.Language
+	folder  string // of the grammar and its injections query
+	queries *querySet
+	q       *sitter.Query
+	exts    []string
+}
+
+// mdInjector extracts fenced code blocks from Markdown.
+type mdInjector struct {
+	queries *querySet
+	q       *sitter.Query
+}
+
+// fenceLangs maps Markdown info strings and script lang attributes to adapter languages.
+// JavaScript goes to the TypeScript adapter, whose grammar is a superset.
+var fenceLangs = map[string]string{
+	"go": "go", "golang": "go",
+	"ts": "ts", "typescript": "ts", "mts": "ts", "cts": "ts",
+	"js": "ts", "javascript": "ts", "mjs": "ts", "cjs": "ts",
+	"py": "py", "python": "py",
+	"java": "java",
+	"c":    "c", "h": "c", "cpp": "c", "c++": "c", "cc": "c", "cxx": "c", "hpp": "c",
+	"cs": "cs", "csharp": "cs", "c#": "cs",
+	"php":    "php",
+	"kotlin": "kt", "kt": "kt", "kts": "kt",
+	"rb": "rb", "ruby": "rb",
+}
+
+// defaultInjectors returns the built-in injectors for .html/.vue, .svelte and Markdown files,
+// and for the C preamble of cgo files.
+func defaultInjectors() ([]Injector, error) {
+	hi, err := (&scriptInjector{lang: html.GetLanguage(), folder: "html", exts: []string{".html", ".htm", ".vue"}}).withQueries(nil)
+	if err != nil {
+		return nil, err
+	}
+	si, err := (&scriptInjector{lang: svelte.GetLanguage(), folder: "svelte", exts: []string{".svelte"}}).withQueries(nil)
+	if err != nil {
+		return nil, err
+	}
+	mi, err := (&mdInjector{}).withQueries(nil)
+	if err != nil {
+		return nil, err
+	}
+	return []Injector{hi, si, mi, cgoInjector{}}, nil
+}
+
+// withQueries returns a copy of the injector with its query read from src.
+func (s *scriptInjector) withQueries(src *querySource) (Injector, error) {
+	qs, err := loadQuerySet(src, s.folder, s.lang, "injections.scm")
+	if err != nil {
+		return nil, err
+	}
+	cp := *s
+	cp.queries, cp.q = qs, qs.parts[0]
+	return &cp, nil
+}
+
+func (s *scriptInjector) querySets() []*querySet { return []*querySet{s.queries} }
+
+func (s *scriptInjector) CanHandle(path string) bool {
+	return slices.Contains(s.exts, strings.ToLower(filepath.Ext(path)))
+}
+
+// Regions returns one region per <script> block whose lang/type names a script language.
+// Blocks without either attribute are JavaScript; JSON, templates and the like are skipped.
+func (s *scriptInjector) Regions(_ string, src []byte) ([]Region, error) {
+	tree, err := parse(s.folder, s.lang, src)
+	if err != nil {
+		return nil, err
+	}
+	var out []Region
+	execQuery(src, tree.RootNode(), s.q, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		tag, body := nodeByName(capts, s.q, "tag"), nodeByName(capts, s.q, "body")
+		if tag == nil || body == nil {
+			return
+		}
+		lang := scriptLang(htmlAttr(src, tag, "lang"), htmlAttr(src, tag, "type"))
+		if lang == "" {
+			return
+		}
+		out = append(out, regionOf(src, body, lang))
+	})
+	return out, nil
+}
+
+// withQueries returns a copy of the injector with its query read from src.
+func (m *mdInjector) withQueries(src *querySource) (Injector, error) {
+	qs, err := loadQuerySet(src, "md", markdown.GetLanguage(), "injections.scm")
+	if err != nil {
+		return nil, err
+	}
+	return &mdInjector{queries: qs, q: qs.parts[0]}, nil
+}
+
+func (m *mdInjector) querySets() []*querySet { return []*querySet{m.queries} }
+
+func (m *mdInjector) CanHandle(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".mdx":
+		return true
+	}
+	return false
+}
+
+// Regions returns one region per fenced code block whose info string names a known language,
+// including fences nested in list items and blockquotes. Code keeps its host columns: the
+// indentation of an indented fence is not removed, and blockquote markers become spaces.
+func (m *mdInjector) Regions(_ string, src []byte) ([]Region, error) {
+	tree, err := parse("md", markdown.GetLanguage(), src)
+	if err != nil {
+		return nil, err
+	}
+	var out []Region
+	execQuery(src, tree.RootNode(), m.q, func(capts []sitter.QueryCapture, _ func(id uint32) string) {
+		lang := fenceLangs[strings.ToLower(getByName(src, capts, m.q, "lang"))]
+		if body := nodeByName(capts, m.q, "body"); lang != "" && body != nil {
+			r := regionOf(src, body, lang)
+			r.Src = blankContinuations(bytes.Clone(r.Src), body, body.StartByte())
+			out = append(out, r)
+		}
+	})
+	return out, nil
+}
+
+// blankContinuations overwrites the block continuations inside a fence's content (the "> "
+// markers of a fence in a blockquote) with spaces in place, so the code keeps its host columns.
+func blankContinuations(buf []byte, n *sitter.Node, base uint32) []byte {
+	for i := 0; i < int(n.NamedChildCount()); i++ {
+		c := n.NamedChild(i)
+		if c.Type() == "block_continuation" {
+			for k := c.StartByte() - base; k < c.EndByte()-base; k++ {
+				if buf[k] != '\n' && buf[k] != '\r' {
+					buf[k] = ' '
+				}
+			}
+			continue
+		}
+		blankContinuations(buf, c, base)
+	}
+	return buf
+}
+
+// scriptLang maps a <script> tag's lang and type attributes to an adapter language, or "" to skip it.
+func scriptLang(lang, typ string) string {
+	if lang != "" {
+		return fenceLangs[strings.ToLower(lang)]
+	}
+	switch strings.ToLower(typ) {
+	case "", "module", "text/javascript", "application/javascript", "text/typescript", "application/typescript":
+		return "ts"
+	}
+	return ""
+}
+
+// htmlAttr returns the unquoted value of an attribute on a start tag, or "".
+func htmlAttr(src []byte, tag *sitter.Node, name string) string {
+	for i := 0; i < int(tag.NamedChildCount()); i++ {
+		attr := tag.NamedChild(i)
+		if attr.Type() != "attribute" || attr.NamedChildCount() == 0 || attr.NamedChild(0).Content(src) != name {
+			continue
+		}
+		if attr.NamedChildCount() < 2 {
+			return ""
+		}
+		return strings.Trim(attr.NamedChild(1).Content(src), `"'`)
+	}
+	return ""
+}
+
+// regionOf returns the region covering node n of the host source.
+func regionOf(src []byte, n *sitter.Node, lang string) Region {
+	sp := n.StartPoint()
+	return Region{Lang: lang, Start: Pos{int(sp.Row) + 1, int(sp.Column) + 1}, Src: src[n.StartByte():n.EndByte()]}
+}
+
+// shiftPos maps a 1-based position inside a region starting at start onto the host file.
+func shiftPos(p, start Pos) Pos {
+	if p.Line == 1 {
+		return Pos{start.Line, start.Col + p.Col - 1}
+	}
+	return Pos{start.Line + p.Line - 1, p.Col}
+}
+
+// unshiftPos is the inverse of shiftPos: it maps a host position into region coordinates.
+func unshiftPos(p, start Pos) Pos {
+	if p.Line == start.Line {
+		return Pos{1, p.Col - start.Col + 1}
+	}
+	return Pos{p.Line - start.Line + 1, p.Col}
+}
+
+// shiftFileIndex moves every range of a region's FileIndex onto the host file.
+func shiftFileIndex(fi *FileIndex, start Pos) *FileIndex {
+	shift := func(r Range) Range { return Range{Start: shiftPos(r.Start, start), End: shiftPos(r.End, start)} }
+	for sid, d := range fi.Defs {
+		d.Rng = shift(d.Rng)
+		fi.Defs[sid] = d
+	}
+	for sid, refs := range fi.Refs {
+		for i := range refs {
+			refs[i].Rng = shift(refs[i].Rng)
+		}
+		fi.Refs[sid] = refs
+	}
+	for i := range fi.Occurrences {
+		fi.Occurrences[i].Rng = shift(fi.Occurrences[i].Rng)
+	}
+	for i := range fi.SyntaxErrors {
+		fi.SyntaxErrors[i] = shift(fi.SyntaxErrors[i])
+	}
+	return fi
+}
+
+// regionFile is the file part of the symbol IDs defined in the nth (1-based) region of a host
+// file, e.g. "README.md#2", so that two regions defining the same name, such as two Markdown
+// fences declaring main, get distinct symbols. Their locations keep the host path.
+func regionFile(path string, n int) string {
+	return strings.TrimPrefix(filepath.ToSlash(path), "./") + "#" + strconv.Itoa(n)
+}
+
+// qualifyRegion moves the symbols a region's adapter defined under the host path to the
+// region's own symbol IDs (see regionFile), along with references and occurrences of them.
+func qualifyRegion(fi *FileIndex, path string, n int) *FileIndex {
+	host := "::" + strings.TrimPrefix(filepath.ToSlash(path), "./") + "::"
+	region := "::" + regionFile(path, n) + "::"
+	qualify := func(sid string) string {
+		if lang, rest, ok := strings.Cut(sid, host); ok && !strings.Contains(lang, "::") {
+			return lang + region + rest
+		}
+		return sid
+	}
+	defs := make(map[string]DefLocation, len(fi.Defs))
+	for sid, d := range fi.Defs {
+		defs[qualify(sid)] = d
+	}
+	fi.Defs = defs
+	if fi.Refs != nil {
+		refs := make(map[string][]RefLocation, len(fi.Refs))
+		for sid, rs := range fi.Refs {
+			refs[qualify(sid)] = rs
+		}
+		fi.Refs = refs
+	}
+	for i := range fi.Occurrences {
+		fi.Occurrences[i].SymbolID = qualify(fi.Occurrences[i].SymbolID)
+	}
+	return fi
+}
+
+// regionFirst returns the symbol IDs with those defined in the nth region of a host file first.
+func regionFirst(sids []string, path string, n int) []string {
+	own := "::" + regionFile(path, n) + "::"
+	out := slices.Clone(sids)
+	sort.SliceStable(out, func(i, j int) bool {
+		return strings.Contains(out[i], own) && !strings.Contains(out[j], own)
+	})
+	return out
+}
+
+// regionStore is the store a region's adapter resolves against. Lookups list the region's own
+// symbols first, so an adapter preferring symbols of the same file picks them over those of
+// the host's other regions.
+type regionStore struct {
+	Store
+	file string
+	n    int
+}
+
+func (s regionStore) Lookup(lang, name string) []string {
+	return regionFirst(s.Store.Lookup(lang, name), s.file, s.n)
+}
+
+func (s regionStore) FileLookup(file, lang, name string) []string {
+	return regionFirst(s.Store.FileLookup(file, lang, name), s.file, s.n)
+}
+
+// contains reports whether the 1-based host position (line, col) falls inside the region.
+func (r Region) contains(line, col int) bool {
+	end := r.Start
+	for _, b := range r.Src {
+		if b == '\n' {
+			end.Line++
+			end.Col = 1
+		} else {
+			end.Col++
+		}
+	}
+	pt := Pos{Line: line, Col: col}
+	return beforeOrEq(r.Start, pt) && beforeOrEq(pt, end)
+}
@@ -0,0 +1,111 @@
+package xref
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// injectFiles has TypeScript in Vue, Svelte and HTML script blocks calling each other, a script
+// block of JSON, and Go in two Markdown fences, one in a blockquote, defining the same names.
+var injectFiles = fstest.MapFS{
+	"web/Card.vue": {Data: []byte(`<template>
+  <div>{{ title }}</div>
+</template>
+<script lang="ts">
+export function formatCard(n: number) { return n }
+</script>
+`)},
+	"web/Counter.svelte": {Data: []byte(`<script lang="ts">
+  let count = formatCard(1);
+</script>
+<button>{count}</button>
+`)},
+	"web/index.html": {Data: []byte(`<html><body>
+<script type="application/json">{"boot": 1}</script>
+<script>
+  function boot() { return formatCard(2) }
+</script>
+</body></html>
+`)},
+	"web/app.ts":    {Data: []byte("boot();\nformatCard(3);\n")},
+	"docs/guide.md": {Data: []byte("# Guide\n\n```go\nfunc Helper() {}\nfunc main() { Helper() }\n```\n\n> ```go\n> func Helper() {}\n> func main() { Helper() }\n> ```\n")},
+}
+
+// TestInjectorRegions checks the embedded regions found in each kind of host file: their
+// language, where they start, and their text.
+func TestInjectorRegions(t *testing.T) {
+	injectors, err := defaultInjectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want []Region
+	}{
+		{"web/Card.vue", []Region{{Lang: "ts", Start: Pos{4, 19}, Src: []byte("\nexport function formatCard(n: number) { return n }\n")}}},
+		{"web/Counter.svelte", []Region{{Lang: "ts", Start: Pos{2, 3}, Src: []byte("let count = formatCard(1);\n")}}},
+		{"web/index.html", []Region{{Lang: "ts", Start: Pos{3, 9}, Src: []byte("\n  function boot() { return formatCard(2) }\n")}}},
+		{"docs/guide.md", []Region{
+			{Lang: "go", Start: Pos{4, 1}, Src: []byte("func Helper() {}\nfunc main() { Helper() }\n")},
+			{Lang: "go", Start: Pos{9, 3}, Src: []byte("func Helper() {}\n  func main() { Helper() }\n  ")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var regions []Region
+			for _, inj := range injectors {
+				if inj.CanHandle(tt.file) {
+					if regions, err = inj.Regions(tt.file, injectFiles[tt.file].Data); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if len(regions) != len(tt.want) {
+				t.Fatalf("%d regions %q, want %d", len(regions), regions, len(tt.want))
+			}
+			for i, r := range regions {
+				if w := tt.want[i]; r.Lang != w.Lang || r.Start != w.Start || string(r.Src) != string(w.Src) {
+					t.Errorf("region %d: %s at %v %q, want %s at %v %q", i+1, r.Lang, r.Start, r.Src, w.Lang, w.Start, w.Src)
+				}
+			}
+		})
+	}
+}
+
+// TestFindDefinitionInEmbeddedCode checks that symbols of embedded regions take part in
+// cross-file resolution, at their positions in the host file, and that same-named symbols of
+// two regions of one file stay apart.
+func TestFindDefinitionInEmbeddedCode(t *testing.T) {
+	e, err := New(WithLanguages("ts", "go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(injectFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      string // symbol ID of the definition
+		wantAt    Pos
+	}{
+		{"from TypeScript into HTML", "web/app.ts", 1, 1, "ts::web/index.html#1::boot", Pos{4, 3}},
+		{"from TypeScript into Vue", "web/app.ts", 2, 1, "ts::web/Card.vue#1::formatCard", Pos{5, 8}},
+		{"from Svelte into Vue", "web/Counter.svelte", 2, 15, "ts::web/Card.vue#1::formatCard", Pos{5, 8}},
+		{"from HTML into Vue", "web/index.html", 4, 28, "ts::web/Card.vue#1::formatCard", Pos{5, 8}},
+		{"within a fence", "docs/guide.md", 5, 15, "go::docs/guide.md#1::Helper", Pos{4, 1}},
+		{"within a fence in a blockquote", "docs/guide.md", 10, 17, "go::docs/guide.md#2::Helper", Pos{9, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cands) == 0 || cands[0] != tt.want || d.Rng.Start != tt.wantAt {
+				t.Errorf("definition at %v (candidates %q), want %s at %v", d.Rng.Start, cands, tt.want, tt.wantAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package xref
+
+import (
+	"iter"
+	"sort"
+	"strings"
+)
+
+// strID identifies an interned string: a file path, name, kind, language or symbol ID part.
+type strID uint32
+
+// stringTable interns the strings of the index so each distinct one is stored once and referred
+// to by a 4-byte ID. ID 0 is the empty string. Strings are never released; their number is
+// bounded by the distinct paths and names ever indexed, which edits rarely grow much.
+type stringTable struct {
+	ids  map[string]strID
+	strs []string
+}
+
+func newStringTable() stringTable {
+	return stringTable{ids: map[string]strID{"": 0}, strs: []string{""}}
+}
+
+// intern returns the ID of s, adding it to the table if needed. The caller must hold the write lock.
+func (t *stringTable) intern(s string) strID {
+	if id, ok := t.ids[s]; ok {
+		return id
+	}
+	s = strings.Clone(s) // Don't pin a larger string s may be a slice of (a symbol ID, a source file)
+	id := strID(len(t.strs))
+	t.ids[s] = id
+	t.strs = append(t.strs, s)
+	return id
+}
+
+// find returns the ID of s without adding it, for lookups under the read lock.
+func (t *stringTable) find(s string) (strID, bool) {
+	id, ok := t.ids[s]
+	return id, ok
+}
+
+func (t *stringTable) str(id strID) string { return t.strs[id] }
+
+// symKey is a symbol ID as a pair of interned parts: the "lang::file::" prefix shared by every
+// symbol of a file, and the qualified name after it. The zero key is the empty symbol ID.
+type symKey struct{ prefix, suffix strID }
+
+// splitSID splits a symbol ID after its "lang::file::" prefix. IDs of another shape are kept
+// whole in the suffix.
+func splitSID(sid string) (string, string) {
+	i := strings.Index(sid, "::")
+	if i < 0 {
+		return "", sid
+	}
+	j := strings.Index(sid[i+2:], "::")
+	if j < 0 {
+		return "", sid
+	}
+	n := i + 2 + j + 2
+	return sid[:n], sid[n:]
+}
+
+// internSID returns the key of a symbol ID, interning its parts. The caller must hold the write lock.
+func (pi *ProjectIndex) internSID(sid string) symKey {
+	prefix, suffix := splitSID(sid)
+	return symKey{pi.strs.intern(prefix), pi.strs.intern(suffix)}
+}
+
+// findSID returns the key of a symbol ID the index has seen.
+func (pi *ProjectIndex) findSID(sid string) (symKey, bool) {
+	prefix, suffix := splitSID(sid)
+	p, ok := pi.strs.find(prefix)
+	if !ok {
+		return symKey{}, false
+	}
+	s, ok := pi.strs.find(suffix)
+	return symKey{p, s}, ok
+}
+
+// sid rebuilds the symbol ID of a key.
+func (pi *ProjectIndex) sid(k symKey) string {
+	if k.prefix == 0 {
+		return pi.strs.str(k.suffix)
+	}
+	return pi.strs.str(k.prefix) + pi.strs.str(k.suffix)
+}
+
+// nameKey is the language and name a symbol is looked up by.
+type nameKey struct{ lang, name strID }
+
+// packedRange is a Range in 16 bytes instead of 32.
+type packedRange struct{ sl, sc, el, ec uint32 }
+
+func packRange(r Range) packedRange {
+	return packedRange{uint32(r.Start.Line), uint32(r.Start.Col), uint32(r.End.Line), uint32(r.End.Col)}
+}
+
+func (p packedRange) unpack() Range {
+	return Range{Start: Pos{Line: int(p.sl), Col: int(p.sc)}, End: Pos{Line: int(p.el), Col: int(p.ec)}}
+}
+
+// packedDef is a DefLocation with interned strings.
+type packedDef struct {
+	file, lang, name, kind strID
+	decl                   bool
+	rng                    packedRange
+}
+
+// packedRef is a RefLocation with interned strings.
+type packedRef struct {
+	file, lang strID
+	rng        packedRange
+}
+
+// packedOcc is an Occurrence with interned strings.
+type packedOcc struct {
+	name, kind strID
+	sym        symKey
+	rng        packedRange
+	outer      int32 // index of the nearest earlier occurrence of the file ending at or after this one, or -1
+}
+
+// occRef locates an occurrence: the file, and its position in the file's sorted occurrences.
+type occRef struct {
+	file strID
+	i    int32
+}
+
+func (pi *ProjectIndex) packDef(d DefLocation) packedDef {
+	return packedDef{
+		file: pi.strs.intern(d.File), lang: pi.strs.intern(d.Lang), name: pi.strs.intern(d.Name),
+		kind: pi.strs.intern(d.Kind), decl: d.Decl, rng: packRange(d.Rng),
+	}
+}
+
+func (pi *ProjectIndex) unpackDef(d packedDef) DefLocation {
+	return DefLocation{
+		File: pi.strs.str(d.file), Lang: pi.strs.str(d.lang), Name: pi.strs.str(d.name),
+		Kind: pi.strs.str(d.kind), Decl: d.decl, Rng: d.rng.unpack(),
+	}
+}
+
+func (pi *ProjectIndex) unpackRef(r packedRef) RefLocation {
+	return RefLocation{File: pi.strs.str(r.file), Lang: pi.strs.str(r.lang), Rng: r.rng.unpack()}
+}
+
+func (pi *ProjectIndex) unpackOcc(o packedOcc) Occurrence {
+	return Occurrence{Name: pi.strs.str(o.name), KindHint: pi.strs.str(o.kind), SymbolID: pi.sid(o.sym), Rng: o.rng.unpack()}
+}
+
+// definition returns the definition of a symbol. The caller must hold the lock.
+func (pi *ProjectIndex) definition(sid string) (DefLocation, bool) {
+	k, ok := pi.findSID(sid)
+	if !ok {
+		return DefLocation{}, false
+	}
+	d, ok := pi.defs[k]
+	if !ok {
+		return DefLocation{}, false
+	}
+	return pi.unpackDef(d), true
+}
+
+// allDefs iterates over every definition; symbol IDs are rebuilt with sid only when needed.
+// The caller must hold the lock.
+func (pi *ProjectIndex) allDefs() iter.Seq2[symKey, DefLocation] {
+	return func(yield func(symKey, DefLocation) bool) {
+		for k, d := range pi.defs {
+			if !yield(k, pi.unpackDef(d)) {
+				return
+			}
+		}
+	}
+}
+
+// fileLookup returns the symbols named name in lang defined in file, in source order.
+// The caller must hold the lock.
+func (pi *ProjectIndex) fileLookup(file, lang, name string) []string {
+	f, ok := pi.strs.find(file)
+	if !ok {
+		return nil
+	}
+	l, ok := pi.strs.find(lang)
+	if !ok {
+		return nil
+	}
+	n, ok := pi.strs.find(name)
+	if !ok {
+		return nil
+	}
+	type local struct {
+		sid string
+		rng packedRange
+	}
+	var found []local
+	for _, k := range pi.fileNames[f][nameKey{l, n}] {
+		if d, ok := pi.defs[k]; ok && d.file == f { // Not redefined by another file since
+			found = append(found, local{pi.sid(k), d.rng})
+		}
+	}
+	// Nearly always a single symbol; order overloads and redeclarations by position
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i].rng, found[j].rng
+		if a.sl != b.sl {
+			return a.sl < b.sl
+		}
+		if a.sc != b.sc {
+			return a.sc < b.sc
+		}
+		return found[i].sid < found[j].sid
+	})
+	out := make([]string, len(found))
+	for i, l := range found {
+		out[i] = l.sid
+	}
+	return out
+}
+
+// lookup returns the symbols named name in lang. The caller must hold the lock.
+func (pi *ProjectIndex) lookup(lang, name string) []string {
+	l, ok := pi.strs.find(lang)
+	if !ok {
+		return nil
+	}
+	n, ok := pi.strs.find(name)
+	if !ok {
+		return nil
+	}
+	keys := pi.nameLookup[nameKey{l, n}]
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = pi.sid(k)
+	}
+	return out
+}
+
+// references returns the references to a symbol. The caller must hold the lock.
+func (pi *ProjectIndex) references(sid string) []RefLocation {
+	k, ok := pi.findSID(sid)
+	if !ok {
+		return nil
+	}
+	refs := pi.refs[k]
+	out := make([]RefLocation, len(refs))
+	for i, r := range refs {
+		out[i] = pi.unpackRef(r)
+	}
+	return out
+}
+
+// occurrences returns the occurrences of a file. The caller must hold the lock.
+func (pi *ProjectIndex) occurrences(file string) []Occurrence {
+	f, ok := pi.strs.find(file)
+	if !ok {
+		return nil
+	}
+	occs := pi.fileOcc[f]
+	out := make([]Occurrence, len(occs))
+	for i, o := range occs {
+		out[i] = pi.unpackOcc(o)
+	}
+	return out
+}
+
+// occurrenceAt returns the innermost occurrence of a file containing pos. The caller must hold
+// the lock.
+func (pi *ProjectIndex) occurrenceAt(file string, pos Pos) (Occurrence, bool) {
+	f, ok := pi.strs.find(file)
+	if !ok {
+		return Occurrence{}, false
+	}
+	occs := pi.fileOcc[f]
+	i, ok := pickOccurrence(occs, pos)
+	if !ok {
+		return Occurrence{}, false
+	}
+	return pi.unpackOcc(occs[i]), true
+}
+
+// link returns the source symbol a generated symbol was linked to. The caller must hold the lock.
+func (pi *ProjectIndex) link(sid string) (string, bool) {
+	k, ok := pi.findSID(sid)
+	if !ok {
+		return "", false
+	}
+	to, ok := pi.links[k]
+	if _, defined := pi.defs[to]; !ok || !defined {
+		return "", false // The source changed since, and the link awaits linkGenerated
+	}
+	return pi.sid(to), true
+}
@@ -0,0 +1,144 @@
+package xref
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// jvmImport is a single Java or Kotlin import as written in the source file.
+type jvmImport struct {
+	path     string // qualified name without the trailing ".*"
+	alias    string // Kotlin "import a.B as C"; empty otherwise
+	static   bool
+	wildcard bool
+	rng      Range
+}
+
+// localName returns the simple name a single (non-wildcard) import introduces.
+func (im jvmImport) localName() string {
+	if im.alias != "" {
+		return im.alias
+	}
+	return lastDotted(im.path)
+}
+
+// jvmImports returns the imports a Java or Kotlin file recorded in its FileIndex.Imports: each
+// name maps to what it imports, under an alias if it differs from the last segment, and
+// on-demand imports are keyed by their path and ".*". They come back sorted by name.
+func jvmImports(imports map[string]string) []jvmImport {
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]jvmImport, 0, len(names))
+	for _, name := range names {
+		im := jvmImport{path: imports[name]}
+		switch {
+		case name == im.path+".*":
+			im.wildcard = true
+		case name != lastDotted(im.path):
+			im.alias = name
+		}
+		out = append(out, im)
+	}
+	return out
+}
+
+// resolveJVM ranks Java and Kotlin definitions for a simple name used in path. Both languages share
+// one package namespace, so candidates come from either: single imports naming the symbol first,
+// then the file's own package, then on-demand imports, and finally any symbol with that name.
+// Packages are matched to files by the package-directory convention under roots.
+func resolveJVM(path, name, pkg string, imps []jvmImport, roots []string, idx Store) []string {
+	// An import alias renames the symbol; look it up under its declared name
+	lookup := name
+	for _, im := range imps {
+		if !im.wildcard && im.alias == name {
+			lookup = lastDotted(im.path)
+		}
+	}
+	named := append(idx.Lookup("java", lookup), idx.Lookup("kt", lookup)...)
+	sort.Strings(named)
+	defs := make(map[string]DefLocation, len(named))
+	for _, sid := range named {
+		if d, ok := idx.Definition(sid); ok {
+			defs[sid] = d
+		}
+	}
+
+	var out []string
+	seen := map[string]struct{}{}
+	add := func(match func(d DefLocation) bool) {
+		for _, sid := range named {
+			if _, ok := seen[sid]; ok {
+				continue
+			}
+			if d, ok := defs[sid]; ok && match(d) {
+				seen[sid] = struct{}{}
+				out = append(out, sid)
+			}
+		}
+	}
+	// inScope matches members of a package, or of a type (static imports, nested types, Kotlin objects)
+	inScope := func(d DefLocation, qualified string) bool {
+		return packageDirMatches(d.File, qualified, roots) || jvmInType(d.File, qualified, roots)
+	}
+
+	// Single imports name a type, a static member or (Kotlin) a top-level declaration
+	for _, im := range imps {
+		if im.wildcard || im.localName() != name {
+			continue
+		}
+		owner := trimLastDotted(im.path)
+		add(func(d DefLocation) bool { return inScope(d, owner) })
+	}
+	// Declarations in the same package are visible without an import
+	add(func(d DefLocation) bool { return packageDirMatches(d.File, pkg, roots) })
+	// On-demand imports: everything in a package, or every (static) member of a type
+	for _, im := range imps {
+		if im.wildcard {
+			add(func(d DefLocation) bool { return inScope(d, im.path) })
+		}
+	}
+	// Fallback: any Java or Kotlin symbol with this name
+	add(func(DefLocation) bool { return true })
+	return out
+}
+
+// jvmInType reports whether file is the source file of the top-level type with the given qualified name.
+func jvmInType(file, qualified string, roots []string) bool {
+	base := strings.TrimSuffix(path.Base(filepath.ToSlash(file)), path.Ext(file))
+	return base == lastDotted(qualified) && packageDirMatches(file, trimLastDotted(qualified), roots)
+}
+
+// packageDirMatches reports whether file lives in the directory for a dotted package name
+// under one of the given source roots (the package-directory convention shared by JVM languages).
+func packageDirMatches(file, pkg string, roots []string) bool {
+	dir := path.Dir(filepath.ToSlash(strings.TrimPrefix(file, "./")))
+	rel := strings.ReplaceAll(pkg, ".", "/")
+	for _, root := range roots {
+		want := path.Join(root, rel)
+		if want == "" {
+			want = "."
+		}
+		if dir == want || (want != "." && strings.HasSuffix(dir, "/"+want)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastDotted returns the final segment of a dotted name ("java.util.List" -> "List").
+func lastDotted(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// trimLastDotted drops the final segment of a dotted name ("java.util.List" -> "java.util").
+func trimLastDotted(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
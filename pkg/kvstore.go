@@ -0,0 +1,426 @@
+package xref
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Key prefixes of a KVStore. Parts of a key are joined with NUL, which paths, names and symbol
+// IDs never contain, so scanning "<prefix><part>\x00" finds exactly the keys under that part.
+const (
+	kvDefKey   = "d" // sid -> DefLocation
+	kvNameKey  = "n" // lang, name, sid -> nothing
+	kvLocalKey = "l" // file, lang, name, sid -> nothing
+	kvRefKey   = "r" // sid, file -> the references of the file to the symbol
+	kvOccKey   = "o" // file -> the file's occurrences, sorted by position
+	kvFileKey  = "f" // file -> kvManifest
+
+	kvValueVersion = 2
+)
+
+// KVStore is a Store kept in a KV, such as DiskKV for indexes too large for RAM. Each definition,
+// name, reference list and file's occurrences is an entry of its own, so a query reads only what
+// it needs. Occurrences keep the symbol IDs they were extracted with; OccurrenceAt drops those
+// whose definition has since disappeared. Read errors make queries come back empty; Err reports
+// the first one. Save, Load and IndexCached are features of the in-memory ProjectIndex only, and
+// generated-code links are not stored but worked out when FindDefinitionAt needs one.
+type KVStore struct {
+	kv KV
+	mu sync.Mutex // serializes writes, which read a file's previous entries first
+
+	errMu sync.Mutex
+	err   error
+
+	updateCount atomic.Uint64 // calls of PutFile, see storeCache
+}
+
+var _ Store = (*KVStore)(nil)
+
+// kvManifest is what a file contributed, so PutFile can retract it.
+type kvManifest struct {
+	defs      []DefLocation // with the symbol IDs below
+	sids      []string
+	refs      []string // symbols the file references
+	imports   map[string]string
+	generated string
+	pkg       string
+}
+
+// NewKVStore returns a Store kept in kv.
+func NewKVStore(kv KV) *KVStore {
+	return &KVStore{kv: kv}
+}
+
+// OpenDiskStore opens, creating it if needed, a KVStore in a DiskKV in dir.
+func OpenDiskStore(dir string) (*KVStore, error) {
+	kv, err := OpenDiskKV(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewKVStore(kv), nil
+}
+
+// Err returns the first error a query ran into.
+func (s *KVStore) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+func (s *KVStore) fail(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Close closes the underlying KV.
+func (s *KVStore) Close() error {
+	return s.kv.Close()
+}
+
+func kvKey(prefix string, parts ...string) string {
+	return prefix + strings.Join(parts, "\x00")
+}
+
+// PutFile replaces everything file contributed with fis, in one batch. Symbols the file no
+// longer defines lose all their references, as in ProjectIndex.
+func (s *KVStore) PutFile(file string, fis []*FileIndex) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.updateCount.Add(1)
+	old, _, err := s.manifest(file)
+	if err != nil {
+		return err
+	}
+	m := kvManifest{imports: map[string]string{}}
+	defs := map[string]DefLocation{}
+	refs := map[string][]RefLocation{}
+	var occs []Occurrence
+	for _, fi := range fis {
+		maps.Copy(defs, fi.Defs)
+		for sid, rs := range fi.Refs {
+			refs[sid] = append(refs[sid], rs...)
+		}
+		occs = append(occs, fi.Occurrences...)
+		maps.Copy(m.imports, fi.Imports)
+		if fi.Generated != "" {
+			m.generated = fi.Generated
+		}
+		if fi.Package != "" {
+			m.pkg = fi.Package
+		}
+	}
+
+	var writes []KVWrite
+	del := func(key string) { writes = append(writes, KVWrite{Key: key, Delete: true}) }
+	set := func(key string, v []byte) { writes = append(writes, KVWrite{Key: key, Value: v}) }
+	removed := map[string]struct{}{}
+	for i, sid := range old.sids {
+		d := old.defs[i]
+		del(kvKey(kvNameKey, d.Lang, d.Name, sid))
+		del(kvKey(kvLocalKey, file, d.Lang, d.Name, sid))
+		if _, ok := defs[sid]; !ok {
+			del(kvKey(kvDefKey, sid))
+			removed[sid] = struct{}{}
+		}
+	}
+	for _, sid := range old.refs {
+		del(kvKey(kvRefKey, sid, file))
+	}
+	// Symbols that disappear take their references along, wherever they are
+	for sid := range removed {
+		err := s.kv.Scan(kvKey(kvRefKey, sid, ""), func(key string, _ []byte) bool {
+			del(key)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	m.sids = slices.Sorted(maps.Keys(defs))
+	for _, sid := range m.sids {
+		d := defs[sid]
+		m.defs = append(m.defs, d)
+		set(kvKey(kvDefKey, sid), encodeKVValue(func(w *indexWriter) { writeKVDef(w, d) }))
+		set(kvKey(kvNameKey, d.Lang, d.Name, sid), nil)
+		set(kvKey(kvLocalKey, file, d.Lang, d.Name, sid), nil)
+	}
+	for _, sid := range slices.Sorted(maps.Keys(refs)) {
+		if _, ok := removed[sid]; ok {
+			continue
+		}
+		m.refs = append(m.refs, sid)
+		rs := refs[sid]
+		set(kvKey(kvRefKey, sid, file), encodeKVValue(func(w *indexWriter) {
+			w.uvarint(uint64(len(rs)))
+			for _, r := range rs {
+				w.str(r.Lang)
+				w.str(r.File)
+				w.rng(r.Rng)
+			}
+		}))
+	}
+	if len(fis) == 0 {
+		del(kvKey(kvOccKey, file))
+		del(kvKey(kvFileKey, file))
+	} else {
+		sortOccurrenceList(occs)
+		set(kvKey(kvOccKey, file), encodeKVValue(func(w *indexWriter) {
+			w.uvarint(uint64(len(occs)))
+			for _, o := range occs {
+				w.str(o.Name)
+				w.str(o.KindHint)
+				w.str(o.SymbolID)
+				w.rng(o.Rng)
+			}
+		}))
+		set(kvKey(kvFileKey, file), encodeKVValue(func(w *indexWriter) { writeKVManifest(w, m) }))
+	}
+	return s.kv.Apply(writes)
+}
+
+// DeleteFile removes everything file contributed.
+func (s *KVStore) DeleteFile(file string) error {
+	return s.PutFile(file, nil)
+}
+
+// Definition returns the definition of a symbol ID.
+func (s *KVStore) Definition(sid string) (DefLocation, bool) {
+	var d DefLocation
+	ok := s.get(kvKey(kvDefKey, sid), func(r *indexReader) { d = readKVDef(r) })
+	return d, ok
+}
+
+// References returns the references to a symbol ID, grouped by file.
+func (s *KVStore) References(sid string) []RefLocation {
+	var out []RefLocation
+	s.scan(kvKey(kvRefKey, sid, ""), func(_ string, v []byte) {
+		s.decode(v, func(r *indexReader) {
+			for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+				out = append(out, RefLocation{Lang: r.str(), File: r.str(), Rng: r.rng()})
+			}
+		})
+	})
+	return out
+}
+
+// Lookup returns the symbols named name in lang.
+func (s *KVStore) Lookup(lang, name string) []string {
+	var out []string
+	prefix := kvKey(kvNameKey, lang, name, "")
+	s.scan(prefix, func(key string, _ []byte) { out = append(out, key[len(prefix):]) })
+	return out
+}
+
+// FileLookup returns the symbols named name in lang defined in file, in source order.
+func (s *KVStore) FileLookup(file, lang, name string) []string {
+	var out []string
+	prefix := kvKey(kvLocalKey, file, lang, name, "")
+	s.scan(prefix, func(key string, _ []byte) { out = append(out, key[len(prefix):]) })
+	if len(out) < 2 {
+		return out
+	}
+	defs := make(map[string]DefLocation, len(out))
+	for _, sid := range out {
+		defs[sid], _ = s.Definition(sid)
+	}
+	sort.SliceStable(out, func(i, j int) bool { // Keys are sorted by symbol ID already
+		a, b := defs[out[i]].Rng.Start, defs[out[j]].Rng.Start
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return out
+}
+
+// Occurrences returns the occurrences of a file, sorted by position.
+func (s *KVStore) Occurrences(file string) []Occurrence {
+	var out []Occurrence
+	s.get(kvKey(kvOccKey, file), func(r *indexReader) {
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			out = append(out, Occurrence{Name: r.str(), KindHint: r.str(), SymbolID: r.str(), Rng: r.rng()})
+		}
+	})
+	return out
+}
+
+// OccurrenceAt returns the innermost occurrence of a file containing pos. Occurrences are
+// sorted by start, enclosing ones first, so that is the last one containing pos; of identical
+// ranges, the first.
+func (s *KVStore) OccurrenceAt(file string, pos Pos) (Occurrence, bool) {
+	occs := s.Occurrences(file)
+	best := -1
+	for i, o := range occs {
+		if !beforeOrEq(o.Rng.Start, pos) {
+			break
+		}
+		if beforeOrEq(pos, o.Rng.End) && (best < 0 || o.Rng != occs[best].Rng) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Occurrence{}, false
+	}
+	occ := occs[best]
+	if occ.SymbolID != "" {
+		if _, ok := s.Definition(occ.SymbolID); !ok {
+			occ.SymbolID = "" // Gone since: resolve afresh
+		}
+	}
+	return occ, true
+}
+
+// Imports returns the imports of a file.
+func (s *KVStore) Imports(file string) map[string]string {
+	m, _, err := s.manifest(file)
+	if err != nil {
+		s.fail(err)
+	}
+	return m.imports
+}
+
+// Package returns the package or namespace a file declared, if any.
+func (s *KVStore) Package(file string) string {
+	m, _, err := s.manifest(file)
+	if err != nil {
+		s.fail(err)
+	}
+	return m.pkg
+}
+
+// Files returns the indexed files, sorted.
+func (s *KVStore) Files() []string {
+	var out []string
+	s.scan(kvFileKey, func(key string, _ []byte) { out = append(out, key[len(kvFileKey):]) })
+	return out
+}
+
+// manifest reads what a file contributed.
+func (s *KVStore) manifest(file string) (kvManifest, bool, error) {
+	var m kvManifest
+	v, ok, err := s.kv.Get(kvKey(kvFileKey, file))
+	if err != nil || !ok {
+		return m, false, err
+	}
+	err = decodeKVValue(v, func(r *indexReader) {
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			m.sids = append(m.sids, r.str())
+			m.defs = append(m.defs, readKVDef(r))
+		}
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			m.refs = append(m.refs, r.str())
+		}
+		m.imports = map[string]string{}
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			k := r.str()
+			m.imports[k] = r.str()
+		}
+		m.generated = r.str()
+		m.pkg = r.str()
+	})
+	return m, err == nil, err
+}
+
+func writeKVManifest(w *indexWriter, m kvManifest) {
+	w.uvarint(uint64(len(m.sids)))
+	for i, sid := range m.sids {
+		w.str(sid)
+		writeKVDef(w, m.defs[i])
+	}
+	w.uvarint(uint64(len(m.refs)))
+	for _, sid := range m.refs {
+		w.str(sid)
+	}
+	keys := slices.Sorted(maps.Keys(m.imports))
+	w.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		w.str(k)
+		w.str(m.imports[k])
+	}
+	w.str(m.generated)
+	w.str(m.pkg)
+}
+
+func writeKVDef(w *indexWriter, d DefLocation) {
+	w.str(d.Lang)
+	w.str(d.File)
+	w.str(d.Name)
+	w.str(d.Kind)
+	w.bool(d.Decl)
+	w.rng(d.Rng)
+}
+
+func readKVDef(r *indexReader) DefLocation {
+	return DefLocation{Lang: r.str(), File: r.str(), Name: r.str(), Kind: r.str(), Decl: r.bool(), Rng: r.rng()}
+}
+
+// get decodes the value of a key with read, reporting whether the key was found.
+func (s *KVStore) get(key string, read func(r *indexReader)) bool {
+	v, ok, err := s.kv.Get(key)
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	return ok && s.decode(v, read)
+}
+
+func (s *KVStore) scan(prefix string, fn func(key string, value []byte)) {
+	err := s.kv.Scan(prefix, func(key string, value []byte) bool {
+		fn(key, value)
+		return true
+	})
+	if err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *KVStore) decode(v []byte, read func(r *indexReader)) bool {
+	if err := decodeKVValue(v, read); err != nil {
+		s.fail(err)
+		return false
+	}
+	return true
+}
+
+// encodeKVValue encodes a value in the index file format, with a string table of its own.
+func encodeKVValue(write func(w *indexWriter)) []byte {
+	w := newIndexWriter()
+	write(w)
+	var b bytes.Buffer
+	w.writeTo(&b, "", kvValueVersion)
+	return b.Bytes()
+}
+
+func decodeKVValue(v []byte, read func(r *indexReader)) error {
+	r, err := newIndexReader(bytes.NewReader(v), "", kvValueVersion)
+	if err != nil {
+		return err
+	}
+	read(r)
+	return r.err
+}
+
+// sortOccurrenceList orders occurrences like sortOccurrences: by start, enclosing ones first.
+func sortOccurrenceList(occs []Occurrence) {
+	slices.SortStableFunc(occs, func(a, b Occurrence) int {
+		if a.Rng.Start != b.Rng.Start {
+			if beforeOrEq(a.Rng.Start, b.Rng.Start) {
+				return -1
+			}
+			return 1
+		}
+		if a.Rng.End == b.Rng.End {
+			return 0
+		}
+		if beforeOrEq(b.Rng.End, a.Rng.End) { // Longer first
+			return -1
+		}
+		return 1
+	})
+}
@@ -0,0 +1,125 @@
+package xref
+
+import (
+	"go/build"
+	"log/slog"
+)
+
+// Option configures an Engine created by New.
+type Option func(*engineConfig)
+
+// engineConfig collects the options of New.
+type engineConfig struct {
+	langs    []string // registered adapters to enable; all of them if langsSet is false
+	langsSet bool
+	adapters []LanguageAdapter
+	workers  int
+	ignore   []string
+	logger   *slog.Logger
+	build    *build.Context
+}
+
+// WithLanguages enables only the registered adapters of these languages, by Lang ("go", "py")
+// or by an alias such as "python". Without it, every registered adapter is enabled;
+// WithLanguages() with no names enables none, leaving those added with WithAdapter.
+func WithLanguages(langs ...string) Option {
+	return func(c *engineConfig) {
+		c.langs = append(c.langs, langs...)
+		c.langsSet = true
+	}
+}
+
+// WithAdapter adds adapters, such as one from NewSpecAdapter or one configured differently
+// from its registered default (NewCAdapter with include paths). They take precedence over the
+// registered adapters, and replace those of the same Lang.
+func WithAdapter(adapters ...LanguageAdapter) Option {
+	return func(c *engineConfig) { c.adapters = append(c.adapters, adapters...) }
+}
+
+// WithWorkers sets the number of files parsed in parallel when IndexOptions doesn't (see
+// Engine.Workers).
+func WithWorkers(n int) Option {
+	return func(c *engineConfig) { c.workers = n }
+}
+
+// WithIgnore skips files and directories matching these globs when indexing and watching, in
+// addition to ignore files (see WalkOptions.Exclude for the syntax).
+func WithIgnore(globs ...string) Option {
+	return func(c *engineConfig) { c.ignore = append(c.ignore, globs...) }
+}
+
+// WithLogger sets the logger the engine reports indexing failures, query reloads and watch
+// errors to. Engines log nothing by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *engineConfig) { c.logger = logger }
+}
+
+// WithBuildContext skips Go files that ctx's build constraints exclude: file name suffixes such
+// as _windows.go, //go:build lines, and cgo files when cgo is disabled. Pass &build.Default for
+// the host's GOOS, GOARCH and tags. Without it, every Go file is indexed.
+func WithBuildContext(ctx *build.Context) Option {
+	return func(c *engineConfig) {
+		if ctx == nil {
+			c.build = nil
+			return
+		}
+		bc := *ctx
+		c.build = &bc
+	}
+}
+
+// New creates a cross-reference engine. By default it has every registered adapter: Go,
+// TypeScript, Python, Java, C/C++, C#, PHP, Kotlin, Protocol Buffers and Ruby, plus any added
+// with Register; opts select languages, add adapters and configure indexing.
+// Returns an Engine ready for indexing and querying code symbols.
+func New(opts ...Option) (*Engine, error) {
+	var cfg engineConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	langs := cfg.langs
+	if !cfg.langsSet {
+		langs = Registered()
+	}
+	adapters := cfg.adapters
+	seen := map[string]bool{}
+	for _, a := range adapters {
+		seen[a.Lang()] = true
+	}
+	for _, name := range langs {
+		lang, factory, err := registeredFactory(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[lang] {
+			continue // Replaced by an added adapter, or listed twice
+		}
+		seen[lang] = true
+		a, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		adapters = append(adapters, a)
+	}
+	injectors, err := defaultInjectors()
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{Index: newProjectIndex(), Adapters: adapters, Injectors: injectors, Blobs: NewBlobCache()}
+	e.Workers = cfg.workers
+	e.Walk.Exclude = cfg.ignore
+	e.Logger = cfg.logger
+	e.Build = cfg.build
+	return e, nil
+}
+
+// NewWithAdapters creates an engine with exactly these adapters, or with every registered
+// adapter if none are given, as New did before it took options.
+//
+// Deprecated: use New(WithLanguages(), WithAdapter(adapters...)), or New() for the defaults.
+func NewWithAdapters(adapters ...LanguageAdapter) (*Engine, error) {
+	if len(adapters) == 0 {
+		return New()
+	}
+	return New(WithLanguages(), WithAdapter(adapters...))
+}
@@ -0,0 +1,207 @@
+package xref
+
+import (
+	"bytes"
+	"errors"
+	"go/build"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// langs returns the Lang of each of the engine's adapters, in order.
+func langs(e *Engine) []string {
+	var out []string
+	for _, a := range e.Adapters {
+		out = append(out, a.Lang())
+	}
+	return out
+}
+
+// TestNewLanguages checks which adapters New enables, from the registry and from WithAdapter.
+func TestNewLanguages(t *testing.T) {
+	lua, err := NewSpecAdapter(luaSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"every registered adapter by default", nil, Registered()},
+		{"a subset, by Lang and by alias", []Option{WithLanguages("go", "python")}, []string{"go", "py"}},
+		{"a language named twice", []Option{WithLanguages("go", "golang")}, []string{"go"}},
+		{"no registered adapter", []Option{WithLanguages()}, nil},
+		{"an added adapter, before registered ones", []Option{WithLanguages("go"), WithAdapter(lua)}, []string{"lua", "go"}},
+		{"added adapters only", []Option{WithLanguages(), WithAdapter(lua)}, []string{"lua"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := langs(e); !slices.Equal(got, tt.want) {
+				t.Errorf("adapters %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	c, err := NewCAdapter("include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithAdapter(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs []LanguageAdapter
+	for _, a := range e.Adapters {
+		if a.Lang() == "c" {
+			cs = append(cs, a)
+		}
+	}
+	if len(cs) != 1 || cs[0] != c {
+		t.Errorf("C adapters %v, want only the added one", cs)
+	}
+
+	if _, err := New(WithLanguages("go", "cobol")); err == nil || !strings.Contains(err.Error(), `"cobol"`) {
+		t.Errorf("error %v for an unregistered language, want one naming it", err)
+	}
+}
+
+// TestRegister registers a language, replaces its factory, and checks what engines created
+// afterwards get. The registry is restored when the test ends.
+func TestRegister(t *testing.T) {
+	registry.mu.Lock()
+	names, factories := slices.Clone(registry.names), maps.Clone(registry.factories)
+	registry.mu.Unlock()
+	t.Cleanup(func() {
+		registry.mu.Lock()
+		registry.names, registry.factories = names, factories
+		registry.mu.Unlock()
+	})
+
+	var made []string
+	factory := func(version string) AdapterFactory {
+		return func() (LanguageAdapter, error) {
+			made = append(made, version)
+			return NewSpecAdapter(luaSpec)
+		}
+	}
+	Register("lua", factory("v1"))
+	if got, want := Registered(), append(slices.Clone(names), "lua"); !slices.Equal(got, want) {
+		t.Fatalf("registered %q, want %q", got, want)
+	}
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := langs(e); len(got) == 0 || got[len(got)-1] != "lua" {
+		t.Errorf("default adapters %q, want lua last", got)
+	}
+	if err := e.IndexFS(luaFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Index.Lookup("lua", "greet")) == 0 {
+		t.Error("the registered adapter indexed no lua function greet")
+	}
+
+	Register("lua", factory("v2"))
+	if got := Registered(); len(got) != len(names)+1 {
+		t.Errorf("registered %q after registering lua again, want it listed once", got)
+	}
+	if _, err := New(WithLanguages("lua")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1", "v2"}; !slices.Equal(made, want) {
+		t.Errorf("factories called %q, want %q", made, want)
+	}
+
+	failed := errors.New("no grammar")
+	Register("lua", func() (LanguageAdapter, error) { return nil, failed })
+	if _, err := New(WithLanguages("lua")); !errors.Is(err, failed) {
+		t.Errorf("error %v, want the factory's", err)
+	}
+}
+
+// TestNewOptions checks that the indexing options reach the engine and take effect.
+func TestNewOptions(t *testing.T) {
+	files := fstest.MapFS{
+		"main.go":            {Data: []byte("package main\n\nfunc main() {}\n")},
+		"sys_windows.go":     {Data: []byte("package main\n\nfunc Windows() {}\n")},
+		"tagged.go":          {Data: []byte("//go:build acme\n\npackage main\n\nfunc Tagged() {}\n")},
+		"vendor/dep/dep.go":  {Data: []byte("package dep\n\nfunc Dep() {}\n")},
+		"api/users.gen.go":   {Data: []byte("package api\n\nfunc Gen() {}\n")},
+		"api/users_proto.go": {Data: []byte("package api\n\nfunc Users() {}\n")},
+	}
+	bc := build.Default
+	bc.GOOS, bc.BuildTags = "linux", []string{"acme"}
+	var log bytes.Buffer
+	e, err := New(
+		WithLanguages("go"),
+		WithWorkers(3),
+		WithIgnore("vendor", "**/*.gen.go"),
+		WithLogger(slog.New(slog.NewTextHandler(&log, nil))),
+		WithBuildContext(&bc),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.BuildTags = nil // New keeps a copy
+	if e.Workers != 3 {
+		t.Errorf("Workers %d, want 3", e.Workers)
+	}
+	r, err := e.indexFS(t.Context(), files, []string{"."}, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range e.GetDefinitions() {
+		got = append(got, d.Name)
+	}
+	slices.Sort(got)
+	if want := []string{"Tagged", "Users", "main"}; !slices.Equal(got, want) {
+		t.Errorf("definitions %q, want %q", got, want)
+	}
+	if n := r.Excluded["build constraints"]; n != 1 {
+		t.Errorf("%d files excluded by build constraints, want sys_windows.go", n)
+	}
+	if !strings.Contains(log.String(), "msg=indexed") {
+		t.Errorf("log %q, want the indexing summary", log.String())
+	}
+
+	if e, err = New(WithLanguages("go"), WithBuildContext(&bc), WithBuildContext(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if e.Build != nil {
+		t.Errorf("Build %+v after WithBuildContext(nil), want none", e.Build)
+	}
+}
+
+// TestNewWithAdapters checks that the constructor kept for callers of the former
+// New(adapters...) enables exactly the adapters passed, or every registered one.
+func TestNewWithAdapters(t *testing.T) {
+	e, err := NewWithAdapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := langs(e), Registered(); !slices.Equal(got, want) {
+		t.Errorf("adapters %q, want %q", got, want)
+	}
+	lua, err := NewSpecAdapter(luaSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err = NewWithAdapters(lua); err != nil {
+		t.Fatal(err)
+	}
+	if got := langs(e); !slices.Equal(got, []string{"lua"}) {
+		t.Errorf("adapters %q, want only lua", got)
+	}
+}
@@ -0,0 +1,169 @@
+package xref
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// TextEdit replaces the text between two positions of a document with NewText. Positions are
+// 1-based lines and byte columns, like every Range in the index; End is exclusive. An edit with
+// a zero Range replaces the whole document.
+type TextEdit struct {
+	Range   Range
+	NewText string
+}
+
+// document is an open editor buffer: its current text and the syntax tree of that text, kept so
+// the next change can be reparsed incrementally.
+type document struct {
+	src    []byte
+	tree   *sitter.Tree
+	parser *sitter.Parser // set to the document's grammar on first parse
+}
+
+// OpenDocument starts tracking an editor buffer for path. Until CloseDocument, the buffer's text
+// overrides the file on disk for indexing and queries, and the file is re-indexed from it.
+func (e *Engine) OpenDocument(path, text string) error {
+	e.docMu.Lock()
+	defer e.docMu.Unlock()
+	if e.docs == nil {
+		e.docs = map[string]*document{}
+	}
+	doc := &document{src: []byte(text)}
+	e.docs[path] = doc
+	return e.reindexDocument(path, doc)
+}
+
+// ChangeDocument applies edits, in order, to an open document. The previous syntax tree is
+// edited to match and the text reparsed incrementally, then only this file is re-extracted.
+func (e *Engine) ChangeDocument(path string, edits ...TextEdit) error {
+	e.docMu.Lock()
+	defer e.docMu.Unlock()
+	doc, ok := e.docs[path]
+	if !ok {
+		return fmt.Errorf("document not open: %s", path)
+	}
+	for _, ed := range edits {
+		if ed.Range == (Range{}) {
+			doc.src, doc.tree = []byte(ed.NewText), nil // Full replacement: nothing to reuse
+			continue
+		}
+		start, err := byteOffset(doc.src, ed.Range.Start)
+		if err != nil {
+			return err
+		}
+		end, err := byteOffset(doc.src, ed.Range.End)
+		if err != nil {
+			return err
+		}
+		if end < start {
+			return errors.New("edit range ends before it starts")
+		}
+		src := make([]byte, 0, len(doc.src)-(end-start)+len(ed.NewText))
+		src = append(src, doc.src[:start]...)
+		src = append(src, ed.NewText...)
+		src = append(src, doc.src[end:]...)
+		if doc.tree != nil {
+			doc.tree.Edit(sitter.EditInput{
+				StartIndex:  uint32(start),
+				OldEndIndex: uint32(end),
+				NewEndIndex: uint32(start + len(ed.NewText)),
+				StartPoint:  pointAt(doc.src, start),
+				OldEndPoint: pointAt(doc.src, end),
+				NewEndPoint: pointAt(src, start+len(ed.NewText)),
+			})
+		}
+		doc.src = src
+	}
+	return e.reindexDocument(path, doc)
+}
+
+// CloseDocument stops tracking a buffer and re-indexes the file from disk (or the file system it
+// was indexed from), or removes it from the index if it no longer exists there.
+func (e *Engine) CloseDocument(path string) error {
+	e.docMu.Lock()
+	delete(e.docs, path)
+	e.docMu.Unlock()
+	if _, err := e.readSource(path); errors.Is(err, fs.ErrNotExist) {
+		return e.RemoveFile(path)
+	}
+	return e.UpdateFile(path)
+}
+
+// reindexDocument reparses an open document, reusing its previous tree when the adapter supports
+// incremental parsing, and replaces the file's contribution to the index. Embedded regions are
+// re-extracted in full. A document indexing would leave out on disk contributes nothing.
+// Generated-code links are left to be redone when the document is closed, or by the next
+// update of the index. The caller must hold docMu.
+func (e *Engine) reindexDocument(path string, doc *document) error {
+	if e.excludes(path, doc.src) != "" {
+		doc.tree = nil // Reparsed from scratch once the document is let through again
+		return e.store().PutFile(path, nil)
+	}
+	adapter := e.pickAdapter(path)
+	inc, ok := adapter.(IncrementalAdapter)
+	if !ok {
+		doc.tree = nil
+		return e.store().PutFile(path, e.extractFile(path, doc.src, &extractTrace{}))
+	}
+
+	var fis []*FileIndex
+	if inj := e.pickInjector(path); inj != nil {
+		fis = e.extractInjected(path, doc.src, inj, &extractTrace{})
+	}
+	if doc.parser == nil {
+		doc.parser = sitter.NewParser()
+		doc.parser.SetLanguage(inc.Language(path))
+	}
+	tree, err := doc.parser.ParseCtx(context.Background(), doc.tree, doc.src)
+	if err != nil {
+		doc.parser.Reset() // Don't resume a failed parse on the next change
+	}
+	if err == nil && tree != nil {
+		doc.tree = tree
+		if fi, err := adapter.Extract(path, doc.src, tree); err == nil {
+			fi.Generated = generatedSource(doc.src)
+			fi.SyntaxErrors = syntaxErrors(tree.RootNode())
+			fis = append(fis, fi)
+		}
+	} else {
+		doc.tree = nil
+	}
+	return e.store().PutFile(path, fis)
+}
+
+// byteOffset converts a 1-based line and byte column into an offset in src. The position just
+// past the last character of a line (or of the text) is valid.
+func byteOffset(src []byte, p Pos) (int, error) {
+	if p.Line < 1 || p.Col < 1 {
+		return 0, fmt.Errorf("invalid position %d:%d", p.Line, p.Col)
+	}
+	off := 0
+	for line := 1; line < p.Line; line++ {
+		i := bytes.IndexByte(src[off:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("position %d:%d beyond end of document", p.Line, p.Col)
+		}
+		off += i + 1
+	}
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[off:], '\n'); i >= 0 {
+		lineEnd = off + i
+	}
+	if off+p.Col-1 > lineEnd {
+		return 0, fmt.Errorf("position %d:%d beyond end of line", p.Line, p.Col)
+	}
+	return off + p.Col - 1, nil
+}
+
+// pointAt returns the tree-sitter point (0-based row and byte column) of an offset in src.
+func pointAt(src []byte, off int) sitter.Point {
+	row := bytes.Count(src[:off], []byte{'\n'})
+	col := off - (bytes.LastIndexByte(src[:off], '\n') + 1)
+	return sitter.Point{Row: uint32(row), Column: uint32(col)}
+}
@@ -0,0 +1,244 @@
+package xref
+
+import (
+	"go/build"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// span is the Range from line l1, column c1 to line l2, column c2.
+func span(l1, c1, l2, c2 int) Range {
+	return Range{Start: Pos{Line: l1, Col: c1}, End: Pos{Line: l2, Col: c2}}
+}
+
+// TestChangeDocumentMatchesReparse applies edits to open documents one change at a time and
+// checks after each that the incrementally reparsed tree and the file's index are those of
+// indexing the resulting text from scratch.
+func TestChangeDocumentMatchesReparse(t *testing.T) {
+	type change struct {
+		edits []TextEdit
+		text  string // the document after the edits
+	}
+	tests := []struct {
+		name, path, text string
+		changes          []change
+	}{
+		{
+			name: "go",
+			path: "p/main.go",
+			text: "package p\n\nimport \"fmt\"\n\nfunc Hello() { fmt.Println(\"hi\") }\n",
+			changes: []change{
+				{
+					edits: []TextEdit{{Range: span(5, 6, 5, 11), NewText: "Greet"}},
+					text:  "package p\n\nimport \"fmt\"\n\nfunc Greet() { fmt.Println(\"hi\") }\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(6, 1, 6, 1), NewText: "\nfunc Use() { Greet() }\n"}},
+					text:  "package p\n\nimport \"fmt\"\n\nfunc Greet() { fmt.Println(\"hi\") }\n\nfunc Use() { Greet() }\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(7, 12, 7, 13), NewText: ""}},
+					text:  "package p\n\nimport \"fmt\"\n\nfunc Greet() { fmt.Println(\"hi\") }\n\nfunc Use()  Greet() }\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(7, 12, 7, 12), NewText: "{"}},
+					text:  "package p\n\nimport \"fmt\"\n\nfunc Greet() { fmt.Println(\"hi\") }\n\nfunc Use() { Greet() }\n",
+				},
+				{
+					edits: []TextEdit{
+						{Range: span(3, 1, 3, 1), NewText: "import \"os\"\n"},
+						{Range: span(6, 6, 6, 11), NewText: "Cwd"},
+						{Range: span(6, 14, 6, 31), NewText: "return os.Getwd()"},
+						{Range: span(6, 11, 6, 11), NewText: " (string, error)"},
+						{Range: span(8, 14, 8, 19), NewText: "Cwd"},
+					},
+					text: "package p\n\nimport \"os\"\nimport \"fmt\"\n\nfunc Cwd() (string, error) { return os.Getwd() }\n\nfunc Use() { Cwd() }\n",
+				},
+				{
+					edits: []TextEdit{{NewText: "package p\n\nfunc Only() {}\n"}},
+					text:  "package p\n\nfunc Only() {}\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(3, 10, 3, 10), NewText: "\n"}},
+					text:  "package p\n\nfunc Only\n() {}\n",
+				},
+			},
+		},
+		{
+			name: "python",
+			path: "m.py",
+			text: "class C:\n    def m(self):\n        return 1\n\ndef f():\n    return C().m()\n",
+			changes: []change{
+				{
+					edits: []TextEdit{{Range: span(4, 1, 4, 1), NewText: "    def n(self):\n        return self.m()\n"}},
+					text:  "class C:\n    def m(self):\n        return 1\n    def n(self):\n        return self.m()\n\ndef f():\n    return C().m()\n",
+				},
+				{
+					edits: []TextEdit{
+						{Range: span(2, 9, 2, 10), NewText: "mm"},
+						{Range: span(5, 21, 5, 22), NewText: "mm"},
+						{Range: span(8, 16, 8, 17), NewText: "mm"},
+					},
+					text: "class C:\n    def mm(self):\n        return 1\n    def n(self):\n        return self.mm()\n\ndef f():\n    return C().mm()\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(4, 1, 7, 1), NewText: ""}},
+					text:  "class C:\n    def mm(self):\n        return 1\ndef f():\n    return C().mm()\n",
+				},
+			},
+		},
+		{
+			name: "markdown fence",
+			path: "README.md",
+			text: "# T\n\n```go\nfunc Doc() {}\n```\n",
+			changes: []change{
+				{
+					edits: []TextEdit{{Range: span(4, 6, 4, 9), NewText: "Docs"}},
+					text:  "# T\n\n```go\nfunc Docs() {}\n```\n",
+				},
+				{
+					edits: []TextEdit{{Range: span(6, 1, 6, 1), NewText: "\n```go\nfunc More() { Docs() }\n```\n"}},
+					text:  "# T\n\n```go\nfunc Docs() {}\n```\n\n```go\nfunc More() { Docs() }\n```\n",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.OpenDocument(tt.path, tt.text); err != nil {
+				t.Fatal(err)
+			}
+			for i, c := range tt.changes {
+				if err := e.ChangeDocument(tt.path, c.edits...); err != nil {
+					t.Fatalf("change %d: %v", i, err)
+				}
+				doc := e.docs[tt.path]
+				if string(doc.src) != c.text {
+					t.Fatalf("change %d: text %q, want %q", i, doc.src, c.text)
+				}
+
+				full, err := New()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := full.IndexFS(fstest.MapFS{tt.path: {Data: []byte(c.text)}}, "."); err != nil {
+					t.Fatal(err)
+				}
+				if doc.tree != nil {
+					want, err := e.pickAdapter(tt.path).Parse(tt.path, doc.src)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got, want := doc.tree.RootNode().String(), want.RootNode().String(); got != want {
+						t.Errorf("change %d: tree\n%s\nwant\n%s", i, got, want)
+					}
+				}
+				if got, want := e.GetDefinitions(), full.GetDefinitions(); !maps.Equal(got, want) {
+					t.Errorf("change %d: definitions %v, want %v", i, got, want)
+				}
+				if got, want := e.Index.Occurrences(tt.path), full.Index.Occurrences(tt.path); !reflect.DeepEqual(got, want) {
+					t.Errorf("change %d: occurrences\n%v\nwant\n%v", i, got, want)
+				}
+				if got, want := e.Index.Imports(tt.path), full.Index.Imports(tt.path); !maps.Equal(got, want) {
+					t.Errorf("change %d: imports %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestDocumentExclusions edits open documents in and out of what indexing lets through and
+// checks that each contributes to the index exactly when indexing it from disk would.
+func TestDocumentExclusions(t *testing.T) {
+	type change struct {
+		edit TextEdit
+		defs []string // names defined in the document after the edit
+	}
+	tests := []struct {
+		name, path, text string
+		defs             []string
+		changes          []change
+	}{
+		{
+			name: "other platform",
+			path: "p/sys_windows.go",
+			text: "package p\n\nfunc Sys() {}\n",
+			changes: []change{
+				{edit: TextEdit{Range: span(3, 6, 3, 9), NewText: "Win"}},
+			},
+		},
+		{
+			name: "build constraint",
+			path: "p/tool.go",
+			text: "//go:build ignore\n\npackage p\n\nfunc Tool() {}\n",
+			changes: []change{
+				{edit: TextEdit{Range: span(5, 6, 5, 10), NewText: "Gen"}},
+				{edit: TextEdit{Range: span(1, 1, 3, 1), NewText: ""}, defs: []string{"Gen"}},
+				{edit: TextEdit{Range: span(1, 1, 1, 1), NewText: "//go:build ignore\n\n"}},
+			},
+		},
+		{
+			name: "binary",
+			path: "p/b.go",
+			text: "package p\n\nfunc B() {}\n",
+			defs: []string{"B"},
+			changes: []change{
+				{edit: TextEdit{Range: span(4, 1, 4, 1), NewText: "\x00"}},
+				{edit: TextEdit{Range: span(4, 1, 4, 2), NewText: ""}, defs: []string{"B"}},
+			},
+		},
+		{
+			name: "too large",
+			path: "p/big.go",
+			text: "package p\n\nfunc Big() {}\n",
+			defs: []string{"Big"},
+			changes: []change{
+				{edit: TextEdit{Range: span(4, 1, 4, 1), NewText: "// " + strings.Repeat("x", 64) + "\n"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(WithLanguages("go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bc := build.Default
+			bc.GOOS, bc.GOARCH = "linux", "amd64"
+			e.Build, e.Walk.MaxFileSize = &bc, 64
+			names := func() []string {
+				var out []string
+				for _, d := range e.GetDefinitions() {
+					out = append(out, d.Name)
+				}
+				slices.Sort(out)
+				return out
+			}
+			if err := e.OpenDocument(tt.path, tt.text); err != nil {
+				t.Fatal(err)
+			}
+			if got := names(); !slices.Equal(got, tt.defs) {
+				t.Fatalf("definitions once opened %q, want %q", got, tt.defs)
+			}
+			for i, c := range tt.changes {
+				if err := e.ChangeDocument(tt.path, c.edit); err != nil {
+					t.Fatalf("change %d: %v", i, err)
+				}
+				if got := names(); !slices.Equal(got, c.defs) {
+					t.Errorf("change %d: definitions %q, want %q", i, got, c.defs)
+				}
+				if got := e.Index.Occurrences(tt.path); len(c.defs) == 0 && len(got) != 0 {
+					t.Errorf("change %d: occurrences %v, want none", i, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,579 @@
+package xref
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The index file format: the magic, the format version, a string table, then the body, which
+// refers to strings by their position in the table. Integers are varints, so positions and
+// ranges mostly take a byte or two.
+const (
+	indexMagic         = "XREFIDX\x00"
+	indexFormatVersion = 2
+
+	maxIndexString = 1 << 24 // Sanity limit for a single string in an index file
+)
+
+// ErrIndexFormat is returned by Load for data that is not an index in a supported format.
+var ErrIndexFormat = errors.New("not an xref index or unsupported format version")
+
+// fileStamp is the size and modification time (Unix nanoseconds) of a file when it was indexed,
+// used to tell which files changed since an index was saved.
+type fileStamp struct{ Size, ModTime int64 }
+
+// setStamp records the stamp of a file that was just indexed.
+func (pi *ProjectIndex) setStamp(file string, st fileStamp) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	pi.stamps[file] = st
+}
+
+// stamp records the stamp of a file the engine just indexed, if it indexes into Index: only the
+// in-memory index is saved, and with it the stamps.
+func (e *Engine) stamp(file string, st fileStamp) {
+	if e.Store == nil {
+		e.Index.setStamp(file, st)
+	}
+}
+
+func (pi *ProjectIndex) stampOf(file string) (fileStamp, bool) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	st, ok := pi.stamps[file]
+	return st, ok
+}
+
+// files returns every file that contributes to the index, sorted. The caller must hold the lock.
+func (pi *ProjectIndex) files() []string {
+	seen := map[strID]struct{}{}
+	for _, m := range []map[strID][]symKey{pi.fileDefs, pi.fileRefs} {
+		for f := range m {
+			seen[f] = struct{}{}
+		}
+	}
+	for f := range pi.fileOcc {
+		seen[f] = struct{}{}
+	}
+	out := make([]string, 0, len(seen))
+	for f := range seen {
+		out = append(out, pi.strs.str(f))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// savedFiles returns the files Save writes, sorted: those that contribute to the index, and
+// those indexed to nothing (too large, say), saved for their stamps so they are not read again.
+// The caller must hold the lock.
+func (pi *ProjectIndex) savedFiles() []string {
+	files := pi.files()
+	for file := range pi.stamps {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
+
+// indexHeader describes what an index was extracted with.
+type indexHeader struct {
+	Queries string   // Engine.extractVersion
+	Langs   []string // adapter languages, sorted
+}
+
+// adapterLangs returns the languages of the engine's adapters, sorted.
+func (e *Engine) adapterLangs() []string {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
+	langs := make([]string, 0, len(e.Adapters))
+	for _, a := range e.Adapters {
+		langs = append(langs, a.Lang())
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Save writes the index to w in a compact, versioned binary format that Load reads back.
+// Along with symbols it records each file's size and modification time when it was indexed,
+// so IndexCached can re-index only the files that changed since. Save, Load and IndexCached
+// apply to the in-memory Index; they fail if Engine.Store is set.
+func (e *Engine) Save(w io.Writer) error {
+	if e.Store != nil {
+		return errCustomStore
+	}
+	pi := e.Index
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	iw := newIndexWriter()
+	iw.str(e.extractVersion())
+	langs := e.adapterLangs()
+	iw.uvarint(uint64(len(langs)))
+	for _, l := range langs {
+		iw.str(l)
+	}
+
+	files := pi.savedFiles()
+	iw.uvarint(uint64(len(files)))
+	for _, file := range files {
+		f, _ := pi.strs.find(file)
+		iw.str(file)
+		st, ok := pi.stamps[file]
+		iw.bool(ok)
+		if ok {
+			iw.varint(st.Size)
+			iw.varint(st.ModTime)
+		}
+		iw.str(pi.generated[file])
+		iw.str(pi.packages[file])
+
+		imports := pi.imports[file]
+		keys := make([]string, 0, len(imports))
+		for k := range imports {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		iw.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			iw.str(k)
+			iw.str(imports[k])
+		}
+
+		var defs []symKey
+		for _, k := range uniqueSorted(pi.fileDefs[f], pi.sid) {
+			if d, ok := pi.defs[k]; ok && d.file == f {
+				defs = append(defs, k)
+			}
+		}
+		iw.uvarint(uint64(len(defs)))
+		for _, k := range defs {
+			d := pi.unpackDef(pi.defs[k])
+			iw.str(pi.sid(k))
+			iw.str(d.Lang)
+			iw.str(d.Name)
+			iw.str(d.Kind)
+			iw.bool(d.Decl)
+			iw.rng(d.Rng)
+		}
+
+		type symRefs struct {
+			sid  string
+			refs []RefLocation
+		}
+		var refs []symRefs
+		for _, k := range uniqueSorted(pi.fileRefs[f], pi.sid) {
+			var here []RefLocation
+			for _, r := range pi.refs[k] {
+				if r.file == f {
+					here = append(here, pi.unpackRef(r))
+				}
+			}
+			if len(here) > 0 {
+				refs = append(refs, symRefs{pi.sid(k), here})
+			}
+		}
+		iw.uvarint(uint64(len(refs)))
+		for _, sr := range refs {
+			iw.str(sr.sid)
+			iw.uvarint(uint64(len(sr.refs)))
+			for _, r := range sr.refs {
+				iw.str(r.Lang)
+				iw.rng(r.Rng)
+			}
+		}
+
+		occs := pi.occurrences(file)
+		iw.uvarint(uint64(len(occs)))
+		for _, o := range occs {
+			iw.str(o.Name)
+			iw.str(o.KindHint)
+			iw.str(o.SymbolID)
+			iw.rng(o.Rng)
+		}
+	}
+
+	return iw.writeTo(w, indexMagic, indexFormatVersion)
+}
+
+// Load replaces the index with one written by Save. Files are read from the OS file system
+// afterwards, whatever they were indexed from. Load must not run concurrently with queries.
+func (e *Engine) Load(r io.Reader) error {
+	if e.Store != nil {
+		return errCustomStore
+	}
+	pi, _, err := readIndex(r)
+	if err != nil {
+		return err
+	}
+	e.useIndex(pi)
+	return nil
+}
+
+func (e *Engine) useIndex(pi *ProjectIndex) {
+	e.docMu.Lock()
+	clear(e.sources)
+	e.docMu.Unlock()
+	e.Index = pi
+}
+
+// readIndex decodes an index written by Save and rebuilds the lookup tables and generated-code
+// links that are derived rather than stored.
+func readIndex(r io.Reader) (*ProjectIndex, indexHeader, error) {
+	ir, err := newIndexReader(r, indexMagic, indexFormatVersion)
+	if err != nil {
+		return nil, indexHeader{}, err
+	}
+	var h indexHeader
+	h.Queries = ir.str()
+	for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+		h.Langs = append(h.Langs, ir.str())
+	}
+
+	pi := newProjectIndex()
+	for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+		fi := &FileIndex{File: ir.str(), Defs: map[string]DefLocation{}, Refs: map[string][]RefLocation{}, Imports: map[string]string{}}
+		if ir.bool() {
+			pi.stamps[fi.File] = fileStamp{Size: ir.varint(), ModTime: ir.varint()}
+		}
+		fi.Generated = ir.str()
+		fi.Package = ir.str()
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			k := ir.str()
+			fi.Imports[k] = ir.str()
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			sid := ir.str()
+			fi.Defs[sid] = DefLocation{File: fi.File, Lang: ir.str(), Name: ir.str(), Kind: ir.str(), Decl: ir.bool(), Rng: ir.rng()}
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			sid := ir.str()
+			for m := ir.uvarint(); m > 0 && ir.err == nil; m-- {
+				fi.Refs[sid] = append(fi.Refs[sid], RefLocation{File: fi.File, Lang: ir.str(), Rng: ir.rng()})
+			}
+		}
+		for n := ir.uvarint(); n > 0 && ir.err == nil; n-- {
+			fi.Occurrences = append(fi.Occurrences, Occurrence{Name: ir.str(), KindHint: ir.str(), SymbolID: ir.str(), Rng: ir.rng()})
+		}
+		if len(fi.Defs) > 0 || len(fi.Refs) > 0 || len(fi.Occurrences) > 0 || len(fi.Imports) > 0 || fi.Generated != "" || fi.Package != "" {
+			pi.merge(fi)
+			pi.noteResolved(pi.strs.intern(fi.File))
+		} // Else only its stamp was saved
+	}
+	if ir.err != nil {
+		return nil, indexHeader{}, fmt.Errorf("corrupt index: %w", ir.err)
+	}
+	pi.linkGenerated()
+	return pi, h, nil
+}
+
+// DefaultCacheDir returns the per-user directory for IndexCached, e.g. ~/.cache/xref on Linux.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "xref"), nil
+}
+
+// IndexCached indexes roots like IndexPaths, but starts from the index saved in cacheDir for the
+// same roots: only files whose size or modification time changed are parsed again, new files
+// are added and deleted ones removed. The result is saved back for the next run. A saved index
+// from different queries or adapters is discarded and the roots indexed from scratch.
+func (e *Engine) IndexCached(cacheDir string, roots ...string) error {
+	if e.Store != nil {
+		return errCustomStore
+	}
+	file := filepath.Join(cacheDir, cacheKey(roots)+".xref")
+	if pi, ok := e.loadCached(file); ok {
+		e.useIndex(pi)
+		if err := e.refresh(roots); err != nil {
+			return err
+		}
+	} else if err := e.IndexPaths(roots...); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(cacheDir, ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := e.Save(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file) // Readers see the old index or the new one, never a torn one
+}
+
+// cacheKey names the cached index of a set of roots. Indexed paths are the roots as given, so a
+// relative root is keyed together with the working directory.
+func cacheKey(roots []string) string {
+	h := sha256.New()
+	for _, r := range roots {
+		if !filepath.IsAbs(r) {
+			wd, _ := os.Getwd()
+			fmt.Fprintf(h, "%s\x00", wd)
+			break
+		}
+	}
+	for _, r := range roots {
+		fmt.Fprintf(h, "%s\x00", r)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// loadCached reads a cached index, if it exists and matches the engine's queries and adapters.
+func (e *Engine) loadCached(file string) (*ProjectIndex, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	pi, h, err := readIndex(f)
+	if err != nil || h.Queries != e.extractVersion() || !slices.Equal(h.Langs, e.adapterLangs()) {
+		return nil, false
+	}
+	return pi, true
+}
+
+// refresh brings a loaded index up to date with the files under roots, comparing their stamps.
+func (e *Engine) refresh(roots []string) error {
+	seen := map[string]struct{}{}
+	var changed []string
+	check := func(path string, info fs.FileInfo) {
+		if !e.handles(path) {
+			return
+		}
+		seen[path] = struct{}{}
+		st, ok := e.Index.stampOf(path)
+		if !ok || st != (fileStamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}) {
+			changed = append(changed, path)
+		}
+	}
+	w := e.newWalker(osFS{})
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			check(root, info)
+			continue
+		}
+		w.walk(root, func(path string) bool {
+			info, err := os.Stat(path) // Follows symlinks, as reading the file does
+			if err == nil {
+				check(path, info)
+			}
+			return true
+		})
+	}
+
+	e.Index.mu.RLock()
+	var gone []string
+	for _, f := range e.Index.savedFiles() {
+		if _, ok := seen[f]; !ok {
+			gone = append(gone, f)
+		}
+	}
+	e.Index.mu.RUnlock()
+	if len(gone) > 0 {
+		e.Index.replace(gone, nil)
+	}
+	if len(changed) > 0 {
+		_, err := e.indexFS(context.Background(), osFS{}, changed, IndexOptions{}) // Relinks generated code
+		return err
+	}
+	if len(gone) > 0 {
+		e.Index.linkGenerated()
+	}
+	return nil
+}
+
+// uniqueSorted returns the distinct symbols of keys, ordered by symbol ID.
+func uniqueSorted(keys []symKey, sid func(symKey) string) []symKey {
+	out := slices.Clone(keys)
+	slices.SortFunc(out, func(a, b symKey) int { return strings.Compare(sid(a), sid(b)) })
+	return slices.Compact(out)
+}
+
+// indexWriter encodes the body of an index file, interning strings into the string table.
+type indexWriter struct {
+	body bytes.Buffer
+	strs []string
+	ids  map[string]uint64
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func newIndexWriter() *indexWriter {
+	return &indexWriter{ids: map[string]uint64{}}
+}
+
+// writeTo writes the file: magic, format version, string table and body.
+func (w *indexWriter) writeTo(out io.Writer, magic string, version uint64) error {
+	head := newIndexWriter()
+	head.body.WriteString(magic)
+	head.uvarint(version)
+	head.uvarint(uint64(len(w.strs)))
+	for _, s := range w.strs {
+		head.uvarint(uint64(len(s)))
+		head.body.WriteString(s)
+	}
+	bw := bufio.NewWriter(out)
+	bw.Write(head.body.Bytes())
+	bw.Write(w.body.Bytes())
+	return bw.Flush()
+}
+
+func (w *indexWriter) uvarint(v uint64) {
+	w.body.Write(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *indexWriter) varint(v int64) {
+	w.body.Write(w.buf[:binary.PutVarint(w.buf[:], v)])
+}
+
+func (w *indexWriter) bool(b bool) {
+	if b {
+		w.body.WriteByte(1)
+	} else {
+		w.body.WriteByte(0)
+	}
+}
+
+func (w *indexWriter) str(s string) {
+	id, ok := w.ids[s]
+	if !ok {
+		id = uint64(len(w.strs))
+		w.ids[s] = id
+		w.strs = append(w.strs, s)
+	}
+	w.uvarint(id)
+}
+
+// rng packs a range as its start and the distance to its end line.
+func (w *indexWriter) rng(r Range) {
+	w.uvarint(uint64(r.Start.Line))
+	w.uvarint(uint64(r.Start.Col))
+	w.varint(int64(r.End.Line - r.Start.Line))
+	w.uvarint(uint64(r.End.Col))
+}
+
+// indexReader decodes an index file. The first error sticks; later reads return zero values.
+type indexReader struct {
+	r    *bufio.Reader
+	strs []string
+	err  error
+}
+
+// newIndexReader checks the magic and format version of a file and reads its string table,
+// returning ErrIndexFormat if either doesn't match.
+func newIndexReader(in io.Reader, magic string, version uint64) (*indexReader, error) {
+	br := bufio.NewReader(in)
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil || string(m) != magic {
+		return nil, ErrIndexFormat
+	}
+	r := &indexReader{r: br}
+	if v := r.uvarint(); r.err != nil || v != version {
+		return nil, ErrIndexFormat
+	}
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		size := r.uvarint()
+		if size > maxIndexString {
+			r.fail(fmt.Errorf("string of %d bytes", size))
+			break
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(br, b); err != nil {
+			r.fail(err)
+		}
+		r.strs = append(r.strs, string(b))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("corrupt index: %w", r.err)
+	}
+	return r, nil
+}
+
+func (r *indexReader) fail(err error) {
+	if r.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+	}
+}
+
+func (r *indexReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+func (r *indexReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+func (r *indexReader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.fail(err)
+	}
+	return b == 1
+}
+
+func (r *indexReader) str() string {
+	id := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if id >= uint64(len(r.strs)) {
+		r.fail(fmt.Errorf("string %d out of range", id))
+		return ""
+	}
+	return r.strs[id]
+}
+
+func (r *indexReader) rng() Range {
+	var rg Range
+	rg.Start.Line = int(r.uvarint())
+	rg.Start.Col = int(r.uvarint())
+	rg.End.Line = rg.Start.Line + int(r.varint())
+	rg.End.Col = int(r.uvarint())
+	return rg
+}
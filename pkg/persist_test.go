@@ -0,0 +1,302 @@
+package xref
+
+import (
+	"bytes"
+	"errors"
+	"go/build"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTree writes files (slash-separated path -> contents) under dir.
+func writeTree(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var persistFiles = map[string]string{
+	"a/a.go":      "package a\n\nimport \"fmt\"\n\ntype T struct{}\n\nfunc (T) Hello() { fmt.Println(\"hi\") }\n",
+	"b/b.go":      "package b\n\nimport \"example.com/a\"\n\nfunc Use() { a.T{}.Hello() }\n",
+	"py/m.py":     "import os\n\nclass C:\n    def m(self):\n        return os.getcwd()\n\ndef f():\n    return C().m()\n",
+	"users.proto": "syntax = \"proto3\";\npackage acme;\nmessage User {\n  string user_id = 1;\n}\n",
+	"gen/users.pb.go": "// source: users.proto\n\npackage acme\n\ntype User struct{ UserId string }\n\n" +
+		"func (x *User) GetUserId() string { return x.UserId }\n",
+	"README.md": "# Demo\n\n```go\nfunc Doc() {}\n```\n",
+}
+
+// indexedForSave indexes persistFiles in a temporary directory and returns the engine and its
+// saved index.
+func indexedForSave(t *testing.T) (*Engine, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTree(t, dir, persistFiles)
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexPaths(dir); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := e.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return e, buf.Bytes()
+}
+
+// TestSaveLoadRoundTrip checks that a loaded index answers every query as the saved one did,
+// and saves back to the same bytes.
+func TestSaveLoadRoundTrip(t *testing.T) {
+	e, saved := indexedForSave(t)
+	loaded, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := e.GetDefinitions(), loaded.GetDefinitions()
+	if len(want) == 0 || !maps.Equal(got, want) {
+		t.Fatalf("loaded %d definitions, want %d:\n%v\n%v", len(got), len(want), got, want)
+	}
+	links := 0
+	for sid := range want {
+		if r1, r2 := e.Index.References(sid), loaded.Index.References(sid); !reflect.DeepEqual(r1, r2) {
+			t.Errorf("references to %s: %v, want %v", sid, r2, r1)
+		}
+		to1, ok1 := e.Index.Link(sid)
+		to2, ok2 := loaded.Index.Link(sid)
+		if to1 != to2 || ok1 != ok2 {
+			t.Errorf("link of %s: %q, %t; want %q, %t", sid, to2, ok2, to1, ok1)
+		}
+		if ok1 {
+			links++
+		}
+	}
+	if links == 0 {
+		t.Error("no generated-code links to compare")
+	}
+	if files := loaded.Index.Files(); !reflect.DeepEqual(files, e.Index.Files()) {
+		t.Errorf("files %v, want %v", files, e.Index.Files())
+	}
+	for _, f := range e.Index.Files() {
+		if o1, o2 := e.Index.Occurrences(f), loaded.Index.Occurrences(f); !reflect.DeepEqual(o1, o2) {
+			t.Errorf("occurrences of %s differ:\n%v\n%v", f, o2, o1)
+		}
+		if i1, i2 := e.Index.Imports(f), loaded.Index.Imports(f); !maps.Equal(i1, i2) {
+			t.Errorf("imports of %s: %v, want %v", f, i2, i1)
+		}
+		s1, _ := e.Index.stampOf(f)
+		if s2, ok := loaded.Index.stampOf(f); !ok || s2 != s1 {
+			t.Errorf("stamp of %s: %v, want %v", f, s2, s1)
+		}
+	}
+
+	var again bytes.Buffer
+	if err := loaded.Save(&again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), saved) {
+		t.Error("saving a loaded index gives different bytes")
+	}
+}
+
+// TestLoadRejectsBadInput checks that Load fails cleanly on data that isn't a whole index in
+// the current format, leaving the engine's index as it was.
+func TestLoadRejectsBadInput(t *testing.T) {
+	_, saved := indexedForSave(t)
+	withByte := func(i int, b byte) []byte {
+		out := bytes.Clone(saved)
+		out[i] = b
+		return out
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		format bool // want ErrIndexFormat
+	}{
+		{"empty", nil, true},
+		{"not an index", []byte("package main\n"), true},
+		{"magic only", []byte(indexMagic), true},
+		{"other format version", withByte(len(indexMagic), indexFormatVersion+1), true},
+		{"extraction cache file", func() []byte {
+			var buf bytes.Buffer
+			encodeFileIndexes(&buf, nil, "")
+			return buf.Bytes()
+		}(), true},
+		{"string table cut short", saved[:len(indexMagic)+4], false},
+		{"body cut short", saved[:len(saved)-1], false},
+		{"string ID out of range", func() []byte {
+			iw := newIndexWriter()
+			iw.str("queries")
+			iw.uvarint(0) // No languages
+			iw.uvarint(1) // One file, named by a string the table doesn't have
+			iw.uvarint(7)
+			var buf bytes.Buffer
+			iw.writeTo(&buf, indexMagic, indexFormatVersion)
+			return buf.Bytes()
+		}(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := e.Index
+			err = e.Load(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			if isFormat := errors.Is(err, ErrIndexFormat); isFormat != tt.format {
+				t.Errorf("Load: %v; want ErrIndexFormat: %t", err, tt.format)
+			}
+			if e.Index != before {
+				t.Error("a failed Load replaced the index")
+			}
+		})
+	}
+}
+
+// TestLoadTruncated loads every prefix of a saved index: each must fail without panicking.
+func TestLoadTruncated(t *testing.T) {
+	_, saved := indexedForSave(t)
+	for n := range len(saved) {
+		if _, _, err := readIndex(bytes.NewReader(saved[:n])); err == nil {
+			t.Fatalf("index cut to %d of %d bytes loaded", n, len(saved))
+		}
+	}
+}
+
+// TestLoadCorrupt flips each byte of a saved index in turn. Loading may succeed (a changed
+// name is still a name), but must not panic.
+func TestLoadCorrupt(t *testing.T) {
+	_, saved := indexedForSave(t)
+	data := bytes.Clone(saved)
+	for i := range data {
+		data[i] ^= 0xff
+		readIndex(bytes.NewReader(data))
+		data[i] = saved[i]
+	}
+}
+
+// TestIndexCachedDiscardsOtherSettings checks that an index saved with other extraction
+// settings is not reused, although none of the files changed since.
+func TestIndexCachedDiscardsOtherSettings(t *testing.T) {
+	dir, cache := t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.go":         "package a\n\nfunc A() {}\n",
+		"a_windows.go": "package a\n\nfunc W() {}\n",
+	})
+	e, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexCached(cache, dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.GetDefinitions()) != 2 {
+		t.Fatalf("indexed %v, want A and W", e.GetDefinitions())
+	}
+	bc := build.Default
+	bc.GOOS = "linux"
+	e, err = New(WithLanguages("go"), WithBuildContext(&bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexCached(cache, dir); err != nil {
+		t.Fatal(err)
+	}
+	if defs := e.GetDefinitions(); len(defs) != 1 {
+		t.Errorf("indexed %v, want only A: the saved index was built without build constraints", defs)
+	}
+}
+
+// TestIndexCachedDropsExcludedFiles changes a file between runs of IndexCached so that it now
+// indexes to nothing, and checks that its saved symbols are dropped and its new stamp saved,
+// so the next run doesn't read it again.
+func TestIndexCachedDropsExcludedFiles(t *testing.T) {
+	tests := []struct {
+		name, src string
+	}{
+		{"emptied", ""},
+		{"too large", "package a\n\nfunc Gone() {}\n\n" + strings.Repeat("// padding\n", 10)},
+		{"binary", "package a\n\nfunc Gone() {}\n\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cache := t.TempDir(), t.TempDir()
+			writeTree(t, dir, map[string]string{
+				"a.go": "package a\n\nfunc Gone() {}\n",
+				"b.go": "package a\n\nfunc Kept() { Gone() }\n",
+			})
+			a := filepath.Join(dir, "a.go")
+			run := func() *Engine {
+				t.Helper()
+				e, err := New(WithLanguages("go"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				e.Walk.MaxFileSize = 64
+				if err := e.IndexCached(cache, dir); err != nil {
+					t.Fatal(err)
+				}
+				return e
+			}
+			if _, ok := run().GetDefinitions()["go::"+a+"::Gone"]; !ok {
+				t.Fatal("Gone not indexed")
+			}
+
+			writeTree(t, dir, map[string]string{"a.go": tt.src})
+			info, err := os.Stat(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range 2 { // Refreshed from the stale index, then loaded as saved
+				e := run()
+				if _, ok := e.GetDefinitions()["go::"+a+"::Gone"]; ok {
+					t.Errorf("run %d: Gone still defined", i)
+				}
+				if refs := e.Index.References("go::" + a + "::Gone"); len(refs) != 0 {
+					t.Errorf("run %d: references to Gone %v", i, refs)
+				}
+				if _, ok := e.GetDefinitions()["go::"+filepath.Join(dir, "b.go")+"::Kept"]; !ok {
+					t.Errorf("run %d: Kept dropped", i)
+				}
+				if st, ok := e.Index.stampOf(a); !ok || st != (fileStamp{info.Size(), info.ModTime().UnixNano()}) {
+					t.Errorf("run %d: stamp of a.go %v, %t; want the current one", i, st, ok)
+				}
+			}
+
+			f, err := os.Open(filepath.Join(cache, cacheKey([]string{dir})+".xref"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			pi, _, err := readIndex(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st, ok := pi.stampOf(a); !ok || st != (fileStamp{info.Size(), info.ModTime().UnixNano()}) {
+				t.Errorf("saved stamp of a.go %v, %t; want the current one", st, ok)
+			}
+			if files := pi.Files(); tt.src != "" && slices.Contains(files, a) {
+				t.Errorf("saved index lists a.go among its files %q", files)
+			}
+		})
+	}
+}
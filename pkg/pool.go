@@ -0,0 +1,40 @@
+package xref
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Parsers and query cursors wrap C allocations that are costly to create for every file, so
+// they are pooled: each indexing worker keeps reusing the ones it last put back.
+
+var (
+	parserPools    sync.Map     // grammar name -> *sync.Pool of *sitter.Parser set to it
+	parsersCreated atomic.Int64 // parsers the pools had to create, for tests
+)
+
+// parse parses src with a pooled parser for lang, the grammar called name. Pools are keyed by
+// name: grammar packages return a new *sitter.Language on each call.
+func parse(name string, lang *sitter.Language, src []byte) (*sitter.Tree, error) {
+	pool, ok := parserPools.Load(name)
+	if !ok {
+		pool, _ = parserPools.LoadOrStore(name, &sync.Pool{New: func() any {
+			parsersCreated.Add(1)
+			p := sitter.NewParser()
+			p.SetLanguage(lang)
+			return p
+		}})
+	}
+	p := pool.(*sync.Pool).Get().(*sitter.Parser)
+	tree, err := p.ParseCtx(context.Background(), nil, src)
+	if err != nil {
+		p.Reset() // Don't resume a failed parse on the next file
+	}
+	pool.(*sync.Pool).Put(p)
+	return tree, err
+}
+
+var cursorPool = sync.Pool{New: func() any { return sitter.NewQueryCursor() }}
@@ -0,0 +1,36 @@
+package xref
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestParserReusedAcrossFiles checks that parsing many files goes through one parser pool per
+// grammar and reuses its parsers, although each Parse gets a new *sitter.Language.
+func TestParserReusedAcrossFiles(t *testing.T) {
+	a, err := newGoAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := func() int {
+		n := 0
+		parserPools.Range(func(_, _ any) bool { n++; return true })
+		return n
+	}
+	const files = 100
+	poolsBefore, createdBefore := pools(), parsersCreated.Load()
+	for i := range files {
+		src := fmt.Appendf(nil, "package p\n\nfunc F%d() {}\n", i)
+		if _, err := a.Parse(fmt.Sprintf("f%d.go", i), src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := pools() - poolsBefore; n > 1 {
+		t.Errorf("parsing Go files added %d parser pools, want at most 1", n)
+	}
+	// sync.Pool may drop parsers (on GC, and at random under the race detector), so only
+	// require most parses to have reused one.
+	if n := parsersCreated.Load() - createdBefore; n > files/2 {
+		t.Errorf("parsing %d files created %d parsers, want them reused", files, n)
+	}
+}
@@ -0,0 +1,455 @@
+package xref
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Generated-code linking: code generated from .proto files (protoc-gen-go, grpc-go, connect-go,
+// protoc-gen-js/grpc-web, ts-proto, protobuf-es) is indexed like any other source, then each
+// generated symbol is mapped back to the proto definition it came from by the generators'
+// naming conventions. FindDefinitionAt follows these links, so jumping from a call to a
+// generated GetUser method lands on the rpc in the .proto rather than in the .pb.go file.
+
+// generatedProtoFileSuffixes are the file name suffixes of generated code, used to find the
+// source .proto by file name when the header doesn't say.
+var generatedProtoFileSuffixes = []string{
+	"_grpc.pb.go", ".connect.go", ".pb.go",
+	"_grpc_web_pb.d.ts", "_grpc_pb.d.ts", "_pb.d.ts", "_connect.ts", "_pb.ts",
+}
+
+// generatedSource returns the .proto path recorded in a generated file's leading comments, or "".
+// protoc-gen-go, grpc-go, connect-go, protoc-gen-js and ts-proto write "// source: acme/users.proto";
+// protobuf-es writes "// @generated from file acme/users.proto (package ...)".
+func generatedSource(src []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for i := 0; i < 40 && sc.Scan(); i++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "/*") || strings.HasPrefix(line, "*") {
+			continue
+		}
+		text, ok := strings.CutPrefix(line, "//")
+		if !ok {
+			break // End of the header comment
+		}
+		text = strings.TrimSpace(text)
+		if rest, ok := strings.CutPrefix(strings.ToLower(text), "source:"); ok {
+			text = strings.TrimSpace(text[len(text)-len(rest):])
+		} else if rest, ok := strings.CutPrefix(text, "@generated from file "); ok {
+			text = rest
+		} else {
+			continue
+		}
+		if f, _, _ := strings.Cut(text, " "); strings.HasSuffix(f, ".proto") {
+			return f
+		}
+	}
+	return ""
+}
+
+// linkGenerated maps symbols of generated Go and TypeScript files to the proto definitions
+// they were generated from. Only links that may have changed since the last call are redone:
+// those of the generated files indexed since, and of the generated files named after a .proto
+// file indexed or removed since.
+func (pi *ProjectIndex) linkGenerated() {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	tables := map[string]map[string]string{} // .proto file + lang -> generated name -> proto symbol ID
+	for f := range pi.relink {
+		pi.linkFile(f, tables)
+	}
+	clear(pi.relink)
+}
+
+// linkFile redoes the links of a generated file's symbols. The caller must hold the write lock.
+func (pi *ProjectIndex) linkFile(f strID, tables map[string]map[string]string) {
+	file := pi.strs.str(f)
+	var proto string
+	if protos := pi.protos[pi.genProto[f]]; len(protos) > 0 {
+		goPackage := func(proto string) string { return pi.imports[proto]["option:go_package"] }
+		proto = protoSourceOf(file, pi.generated[file], protos, goPackage)
+	}
+	for _, k := range pi.fileDefs[f] {
+		d, ok := pi.defs[k]
+		if !ok || d.file != f {
+			continue
+		}
+		delete(pi.links, k)
+		lang := pi.strs.str(d.lang)
+		if proto == "" || (lang != "go" && lang != "ts") {
+			continue
+		}
+		key := proto + "|" + lang
+		if tables[key] == nil {
+			tables[key] = pi.generatedNamesOf(proto, lang)
+		}
+		if target, ok := tables[key][sidQualified(pi.sid(k))]; ok {
+			pi.links[k] = pi.internSID(target)
+		}
+	}
+}
+
+// generatedNamesOf is generatedNames for the definitions of an indexed .proto file. The caller
+// must hold the write lock.
+func (pi *ProjectIndex) generatedNamesOf(proto, lang string) map[string]string {
+	p, _ := pi.strs.find(proto)
+	var sids []string
+	defs := map[string]DefLocation{}
+	for _, k := range pi.fileDefs[p] {
+		d, ok := pi.defs[k]
+		if !ok || d.file != p || pi.strs.str(d.lang) != "proto" {
+			continue
+		}
+		sid := pi.sid(k)
+		if _, seen := defs[sid]; !seen {
+			sids = append(sids, sid)
+			defs[sid] = pi.unpackDef(d)
+		}
+	}
+	return generatedNames(lang, sids, defs)
+}
+
+// trackLinks records what a merged file means for generated-code links: a .proto file puts the
+// generated files that may come from it up for relinking, and a generated file itself. The
+// caller must hold the write lock.
+func (pi *ProjectIndex) trackLinks(file string, f strID) {
+	if strings.HasSuffix(file, ".proto") {
+		name := path.Base(file)
+		if !slices.Contains(pi.protos[name], file) {
+			pi.protos[name] = append(pi.protos[name], file)
+		}
+		for _, g := range pi.genFiles[name] {
+			pi.relink[g] = struct{}{}
+		}
+		return
+	}
+	name := protoNameOf(file, pi.generated[file])
+	if old, ok := pi.genProto[f]; ok && old != name {
+		pi.untrackLinks(file, f)
+	}
+	if name == "" {
+		return
+	}
+	if _, ok := pi.genProto[f]; !ok {
+		pi.genFiles[name] = append(pi.genFiles[name], f)
+		pi.genProto[f] = name
+	}
+	pi.relink[f] = struct{}{}
+}
+
+// untrackLinks forgets a retracted file for generated-code links; the links of its symbols are
+// dropped with them. The caller must hold the write lock.
+func (pi *ProjectIndex) untrackLinks(file string, f strID) {
+	if strings.HasSuffix(file, ".proto") {
+		name := path.Base(file)
+		if protos := slices.DeleteFunc(pi.protos[name], func(p string) bool { return p == file }); len(protos) == 0 {
+			delete(pi.protos, name)
+		} else {
+			pi.protos[name] = protos
+		}
+		for _, g := range pi.genFiles[name] {
+			pi.relink[g] = struct{}{}
+		}
+		return
+	}
+	name, ok := pi.genProto[f]
+	if !ok {
+		return
+	}
+	if gens := slices.DeleteFunc(pi.genFiles[name], func(g strID) bool { return g == f }); len(gens) == 0 {
+		delete(pi.genFiles, name)
+	} else {
+		pi.genFiles[name] = gens
+	}
+	delete(pi.genProto, f)
+	delete(pi.relink, f)
+}
+
+// protoNameOf returns the base name of the .proto file a file may have been generated from: the
+// one named in its header, else the one its generated file name implies (users.pb.go ->
+// users.proto), or "" if it doesn't look generated. protoSourceOf only picks .proto files so named.
+func protoNameOf(file, header string) string {
+	if header != "" {
+		return path.Base(header)
+	}
+	base := path.Base(file)
+	for _, suffix := range generatedProtoFileSuffixes {
+		if stem, ok := strings.CutSuffix(base, suffix); ok {
+			return stem + ".proto"
+		}
+	}
+	return ""
+}
+
+// storeLink is ProjectIndex.Link for other stores, which keep no links: it works out the proto
+// definition a generated symbol came from when asked, from the definitions of the store's .proto
+// files and the generated file's header, read by header.
+func storeLink(idx Store, sid string, header func(file string) string) (string, bool) {
+	d, ok := idx.Definition(sid)
+	if !ok || (d.Lang != "go" && d.Lang != "ts") {
+		return "", false
+	}
+	var protos []string
+	for _, f := range idx.Files() {
+		if strings.HasSuffix(f, ".proto") {
+			protos = append(protos, f)
+		}
+	}
+	if len(protos) == 0 {
+		return "", false
+	}
+	goPackage := func(proto string) string { return idx.Imports(proto)["option:go_package"] }
+	proto := protoSourceOf(d.File, header(d.File), protos, goPackage)
+	if proto == "" {
+		return "", false
+	}
+	var sids []string
+	defs := map[string]DefLocation{}
+	for _, o := range idx.Occurrences(proto) {
+		if _, seen := defs[o.SymbolID]; seen || o.KindHint != "def" || o.SymbolID == "" {
+			continue
+		}
+		if pd, ok := idx.Definition(o.SymbolID); ok && pd.Lang == "proto" {
+			sids = append(sids, o.SymbolID)
+			defs[o.SymbolID] = pd
+		}
+	}
+	target, ok := generatedNames(d.Lang, sids, defs)[sidQualified(sid)]
+	return target, ok
+}
+
+// protoSourceOf returns which of the .proto files protos a generated file came from. The source
+// named in the file header (FileIndex.Generated) wins; otherwise generated file names
+// (users.pb.go, users_pb.d.ts) are matched against .proto file names, narrowed for Go by the
+// proto's go_package option.
+func protoSourceOf(file, header string, protos []string, goPackage func(proto string) string) string {
+	var cands []string
+	if header != "" {
+		for _, proto := range protos {
+			if proto == header || strings.HasSuffix(proto, "/"+header) {
+				cands = append(cands, proto)
+			}
+		}
+	} else {
+		name := protoNameOf(file, "")
+		if name == "" {
+			return ""
+		}
+		for _, proto := range protos {
+			if path.Base(proto) != name {
+				continue
+			}
+			if strings.HasSuffix(file, ".go") && !goPackageMatches(file, proto, goPackage(proto)) {
+				continue
+			}
+			cands = append(cands, proto)
+		}
+	}
+	if len(cands) == 0 {
+		return ""
+	}
+	// Several protos with the same relative path: prefer the one sharing the longest directory prefix
+	sort.Slice(cands, func(i, j int) bool {
+		ci, cj := commonPrefixLen(file, cands[i]), commonPrefixLen(file, cands[j])
+		if ci != cj {
+			return ci > cj
+		}
+		return cands[i] < cands[j]
+	})
+	return cands[0]
+}
+
+// goPackageMatches reports whether a generated Go file is plausibly the output for proto:
+// generated next to it (paths=source_relative) or under a directory ending in its go_package
+// import path (paths=import). Without go_package any location is accepted.
+func goPackageMatches(goFile, proto, goPackage string) bool {
+	importPath, _, _ := strings.Cut(goPackage, ";")
+	if importPath == "" || path.Dir(goFile) == path.Dir(proto) {
+		return true
+	}
+	dir := path.Dir(goFile)
+	return dir == importPath || strings.HasSuffix(dir, "/"+importPath) || strings.HasSuffix(importPath, "/"+dir)
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// generatedNames returns, for one .proto file, the qualified names (as in symbol IDs, i.e.
+// "Container.name") that the code generators for lang emit for each proto definition.
+func generatedNames(lang string, sids []string, defs map[string]DefLocation) map[string]string {
+	var pkg string
+	kinds := map[string]string{} // package-relative proto name -> kind
+	for _, sid := range sids {
+		if defs[sid].Kind == "package" {
+			pkg = defs[sid].Name
+		}
+	}
+	rel := func(sid string) []string {
+		q := sidQualified(sid)
+		if pkg != "" {
+			q = strings.TrimPrefix(q, pkg+".")
+		}
+		return strings.Split(q, ".")
+	}
+	for _, sid := range sids {
+		kinds[strings.Join(rel(sid), ".")] = defs[sid].Kind
+	}
+
+	// First definition wins when generated names collide
+	sids = slices.Sorted(slices.Values(sids))
+	out := map[string]string{}
+	for _, sid := range sids {
+		d := defs[sid]
+		parts := rel(sid)
+		var names []string
+		if lang == "go" {
+			names = goGeneratedNames(d.Kind, parts, kinds)
+		} else {
+			names = tsGeneratedNames(d.Kind, parts)
+		}
+		for _, n := range names {
+			if _, taken := out[n]; !taken {
+				out[n] = sid
+			}
+		}
+	}
+	return out
+}
+
+// goGeneratedNames lists the Go identifiers protoc-gen-go, grpc-go and connect-go generate for a
+// proto definition. Nested types join their parents with "_" (User_Address); enum values are
+// prefixed with the enclosing message, or with the enum itself at top level (Status_STATUS_OK).
+func goGeneratedNames(kind string, parts []string, kinds map[string]string) []string {
+	ident := func(parts []string) string {
+		out := make([]string, len(parts))
+		for i, p := range parts {
+			out[i] = goCamelCase(p)
+		}
+		return strings.Join(out, "_")
+	}
+	name := parts[len(parts)-1]
+	parent := parts[:len(parts)-1]
+	switch kind {
+	case "message":
+		return []string{ident(parts)}
+	case "enum":
+		e := ident(parts)
+		return []string{e, e + "_name", e + "_value"}
+	case "const":
+		if len(parent) == 1 {
+			return []string{ident(parent) + "_" + name}
+		}
+		return []string{ident(parent[:len(parent)-1]) + "_" + name}
+	case "field", "oneof":
+		if kinds[strings.Join(parent, ".")] != "message" {
+			return nil
+		}
+		return []string{ident(parent) + ".Get" + goCamelCase(name)}
+	case "service":
+		s := goCamelCase(name)
+		return []string{
+			s + "Client", lowerFirst(s) + "Client", "New" + s + "Client",
+			s + "Server", "Unimplemented" + s + "Server", "Unsafe" + s + "Server", "Register" + s + "Server", s + "_ServiceDesc",
+			s + "Handler", "New" + s + "Handler", "Unimplemented" + s + "Handler",
+		}
+	case "rpc":
+		if len(parent) != 1 {
+			return nil
+		}
+		s, m := goCamelCase(parent[0]), goCamelCase(name)
+		return []string{
+			lowerFirst(s) + "Client." + m, "Unimplemented" + s + "Server." + m, "Unimplemented" + s + "Handler." + m,
+			"_" + s + "_" + m + "_Handler", s + "_" + m + "_FullMethodName",
+		}
+	}
+	return nil
+}
+
+// tsGeneratedNames lists the TypeScript names protoc-gen-js/grpc-web, ts-proto and protobuf-es
+// generate for a proto definition: nested types joined with "_", accessor methods for fields,
+// and service clients whose methods use the rpc name in either case.
+func tsGeneratedNames(kind string, parts []string) []string {
+	name := parts[len(parts)-1]
+	parent := parts[:len(parts)-1]
+	switch kind {
+	case "message", "enum":
+		return []string{strings.Join(parts, "_")}
+	case "field":
+		owner, acc := strings.Join(parent, "_"), jsCamelCase(name)
+		return []string{owner + ".get" + acc, owner + ".set" + acc, owner + ".has" + acc, owner + ".clear" + acc}
+	case "service":
+		return []string{name, name + "Client", name + "PromiseClient", name + "ClientImpl", name + "Definition", name + "Server", name + "Service"}
+	case "rpc":
+		if len(parent) != 1 {
+			return nil
+		}
+		s := parent[0]
+		var out []string
+		for _, owner := range []string{s, s + "Client", s + "PromiseClient", s + "ClientImpl", s + "Server"} {
+			out = append(out, owner+"."+name, owner+"."+lowerFirst(name))
+		}
+		return out
+	}
+	return nil
+}
+
+// goCamelCase converts a proto name to a Go identifier the way protoc-gen-go does:
+// underscores before lowercase letters are dropped and the letter capitalized (user_id -> UserId).
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '.' in ".{{lowercase}}"
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			b = append(b, 'X') // An initial '_' becomes 'X'
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '_' in "_{{lowercase}}"
+		case c >= '0' && c <= '9':
+			b = append(b, c)
+		default:
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+// jsCamelCase converts a proto field name to the upper camel case protoc-gen-js uses in accessor
+// names (user_id -> UserId).
+func jsCamelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func isASCIILower(c byte) bool { return c >= 'a' && c <= 'z' }
@@ -0,0 +1,111 @@
+package xref
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// protoFiles is a .proto file with Go and TypeScript code generated from it, and callers of both.
+var protoFiles = fstest.MapFS{
+	"proto/acme/users/v1/users.proto": {Data: []byte(`syntax = "proto3";
+package acme.users.v1;
+option go_package = "github.com/acme/api/users/v1;usersv1";
+message User {
+  string user_id = 1;
+  Status status = 2;
+}
+enum Status { STATUS_OK = 0; }
+service UserService {
+  rpc GetUser(GetUserRequest) returns (User);
+}
+message GetUserRequest { string name = 1; }
+`)},
+	"gen/users.pb.go": {Data: []byte(`// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: acme/users/v1/users.proto
+
+package usersv1
+
+type Status int32
+
+const Status_STATUS_OK Status = 0
+
+type User struct{ UserId string }
+
+func (x *User) GetUserId() string { return x.UserId }
+
+type GetUserRequest struct{ Name string }
+
+func (x *GetUserRequest) GetName() string { return x.Name }
+`)},
+	"gen/users_grpc.pb.go": {Data: []byte(`// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// source: acme/users/v1/users.proto
+
+package usersv1
+
+type UserServiceClient interface {
+	GetUser(ctx context.Context, in *GetUserRequest) (*User, error)
+}
+
+type userServiceClient struct{}
+
+func (c *userServiceClient) GetUser(ctx context.Context, in *GetUserRequest) (*User, error) {
+	return nil, nil
+}
+`)},
+	"main.go": {Data: []byte(`package main
+
+func main() {
+	var c usersv1.UserServiceClient
+	u, _ := c.GetUser(ctx, &usersv1.GetUserRequest{})
+	_ = u.GetUserId()
+	_ = usersv1.Status_STATUS_OK
+}
+`)},
+	"web/users_grpc_web_pb.d.ts": {Data: []byte("export class UserServiceClient { getUser(request: GetUserRequest): Promise<User>; }\n")},
+	"web/users_pb.d.ts":          {Data: []byte("export class User { getUserId(): string; }\n")},
+	"web/app.ts":                 {Data: []byte("const c = new UserServiceClient();\nc.getUser(req).then(u => u.getUserId());\n")},
+}
+
+// TestFindDefinitionFollowsGeneratedCode looks up definitions in and through code generated
+// from a .proto file, and checks that they land on the proto definition it was generated from.
+func TestFindDefinitionFollowsGeneratedCode(t *testing.T) {
+	e, err := New(WithLanguages("go", "ts", "proto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(protoFiles, "."); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      string
+		line, col int
+		want      string // kind and name of the proto definition
+		wantLine  int
+	}{
+		{"rpc from a client call", "main.go", 5, 12, "rpc GetUser", 10},
+		{"rpc from the generated method", "gen/users_grpc.pb.go", 12, 30, "rpc GetUser", 10},
+		{"service from the generated interface", "gen/users_grpc.pb.go", 6, 6, "service UserService", 9},
+		{"message from the generated type", "gen/users.pb.go", 10, 6, "message User", 4},
+		{"message from a composite literal", "main.go", 5, 36, "message GetUserRequest", 12},
+		{"field from a getter call", "main.go", 6, 8, "field user_id", 5},
+		{"field from the generated getter", "gen/users.pb.go", 16, 26, "field name", 12},
+		{"enum value", "main.go", 7, 14, "const STATUS_OK", 8},
+		{"enum", "gen/users.pb.go", 6, 6, "enum Status", 8},
+		{"rpc from TypeScript", "web/app.ts", 2, 3, "rpc GetUser", 10},
+		{"field from TypeScript", "web/app.ts", 2, 28, "field user_id", 5},
+		{"message within the proto", "proto/acme/users/v1/users.proto", 10, 40, "message User", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, cands, err := e.FindDefinitionAt(tt.file, tt.line, tt.col)
+			if err != nil {
+				t.Fatalf("%v (candidates %v)", err, cands)
+			}
+			if got := d.Kind + " " + d.Name; got != tt.want || d.File != "proto/acme/users/v1/users.proto" || d.Rng.Start.Line != tt.wantLine {
+				t.Errorf("definition %s in %s at line %d, want %s in users.proto at line %d (candidates %v)",
+					got, d.File, d.Rng.Start.Line, tt.want, tt.wantLine, cands)
+			}
+		})
+	}
+}
@@ -0,0 +1 @@
+((preproc_include path: [(string_literal) (system_lib_string)] @path) @rng)
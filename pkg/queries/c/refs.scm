@@ -0,0 +1,3 @@
+((identifier) @id) @rng
+((type_identifier) @id) @rng
+((field_identifier) @id) @rng
@@ -0,0 +1,13 @@
+((function_definition declarator: (_) @fdecl) @rng)
+((declaration         declarator: (_) @decl) @rng)
+((field_declaration   declarator: (_) @field) @rng)
+((type_definition     declarator: (_) @tdecl) @rng)
+((struct_specifier name: (type_identifier) @sname body: (field_declaration_list)) @rng)
+((union_specifier  name: (type_identifier) @uname body: (field_declaration_list)) @rng)
+((enum_specifier   name: (type_identifier) @ename body: (enumerator_list)) @rng)
+((enumerator name: (identifier) @kname) @rng)
+((preproc_def          name: (identifier) @mname) @rng)
+((preproc_function_def name: (identifier) @mname) @rng)
+((class_specifier name: (type_identifier) @clname body: (field_declaration_list)) @rng)
+((namespace_definition name: (namespace_identifier) @nsname) @rng)
+((alias_declaration name: (type_identifier) @tname) @rng)
@@ -0,0 +1,4 @@
+((identifier) @id) @rng
+((type_identifier) @id) @rng
+((field_identifier) @id) @rng
+((namespace_identifier) @id) @rng
@@ -0,0 +1,15 @@
+((namespace_declaration             name: (_) @nsname) @rng)
+((file_scoped_namespace_declaration name: (_) @nsname) @rng)
+((class_declaration     name: (identifier) @cname) @rng)
+((struct_declaration    name: (identifier) @sname) @rng)
+((record_declaration    name: (identifier) @rname) @rng)
+((interface_declaration name: (identifier) @iname) @rng)
+((enum_declaration      name: (identifier) @ename) @rng)
+((delegate_declaration  name: (identifier) @dname) @rng)
+((enum_member_declaration name: (identifier) @kname) @rng)
+((method_declaration      name: (identifier) @mname parameters: (parameter_list) @params) @rng)
+((constructor_declaration name: (identifier) @ctor  parameters: (parameter_list) @params) @rng)
+((property_declaration    name: (identifier) @pname) @rng)
+((event_declaration       name: (identifier) @evname) @rng)
+((event_field_declaration (variable_declaration (variable_declarator name: (identifier) @evname))) @rng)
+((field_declaration       (variable_declaration (variable_declarator name: (identifier) @fname))) @rng)
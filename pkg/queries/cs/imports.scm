@@ -0,0 +1,2 @@
+((using_directive "global"? @global "static"? @static name: (identifier) @alias [(identifier) (qualified_name) (generic_name)] @path) @rng)
+((using_directive "global"? @global "static"? @static !name [(identifier) (qualified_name)] @path) @rng)
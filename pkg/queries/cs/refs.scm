@@ -0,0 +1 @@
+((identifier) @id) @rng
@@ -1,5 +1,5 @@
 ((function_declaration name: (identifier) @fname) @rng)
-((method_declaration name: (field_identifier) @fname) @rng)
+((method_declaration receiver: (parameter_list (parameter_declaration type: [(type_identifier) @mrecv (pointer_type (type_identifier) @mrecv) (generic_type type: (type_identifier) @mrecv) (pointer_type (generic_type type: (type_identifier) @mrecv))])) name: (field_identifier) @fname) @rng)
 ((type_spec name: (type_identifier) @tname) @rng)
 ((var_spec  name: (identifier) @vname) @rng)
 ((const_spec name: (identifier) @cname) @rng)
@@ -1 +1,3 @@
 ((identifier) @id) @rng
+(selector_expression field: (field_identifier) @id @rng)
+(qualified_type name: (type_identifier) @id @rng)
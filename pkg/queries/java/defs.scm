@@ -0,0 +1,10 @@
+((class_declaration      name: (identifier) @cname) @rng)
+((interface_declaration  name: (identifier) @iname) @rng)
+((enum_declaration       name: (identifier) @ename) @rng)
+((record_declaration     name: (identifier) @rname) @rng)
+((annotation_type_declaration name: (identifier) @aname) @rng)
+((method_declaration      name: (identifier) @mname parameters: (formal_parameters) @params) @rng)
+((constructor_declaration name: (identifier) @ctor  parameters: (formal_parameters) @params) @rng)
+((field_declaration    declarator: (variable_declarator name: (identifier) @fname)) @rng)
+((constant_declaration declarator: (variable_declarator name: (identifier) @fname)) @rng)
+((enum_constant name: (identifier) @kname) @rng)
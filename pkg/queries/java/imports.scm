@@ -0,0 +1,2 @@
+((package_declaration [(identifier) (scoped_identifier)] @package) @rng)
+((import_declaration "static"? @static [(identifier) (scoped_identifier)] @path (asterisk)? @wildcard) @rng)
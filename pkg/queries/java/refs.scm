@@ -0,0 +1,2 @@
+((identifier) @id) @rng
+((type_identifier) @id) @rng
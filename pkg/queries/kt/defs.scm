@@ -0,0 +1,10 @@
+((class_declaration  (type_identifier) @cname) @rng)
+((object_declaration (type_identifier) @oname) @rng)
+((companion_object) @companion @rng)
+((function_declaration (simple_identifier) @fname) @rng)
+(source_file     ((property_declaration (variable_declaration (simple_identifier) @pname)) @rng))
+(class_body      ((property_declaration (variable_declaration (simple_identifier) @pname)) @rng))
+(enum_class_body ((property_declaration (variable_declaration (simple_identifier) @pname)) @rng))
+((class_parameter (binding_pattern_kind) (simple_identifier) @pname) @rng)
+((enum_entry (simple_identifier) @kname) @rng)
+((type_alias (type_identifier) @tname) @rng)
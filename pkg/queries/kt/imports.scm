@@ -0,0 +1,2 @@
+((package_header (identifier) @package) @rng)
+((import_header (identifier) @path (wildcard_import)? @wildcard (import_alias (type_identifier) @alias)?) @rng)
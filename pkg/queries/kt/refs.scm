@@ -0,0 +1,2 @@
+((simple_identifier) @id) @rng
+((type_identifier) @id) @rng
@@ -0,0 +1 @@
+((fenced_code_block (info_string (language) @lang) (code_fence_content) @body) @rng)
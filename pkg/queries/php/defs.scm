@@ -0,0 +1,10 @@
+((namespace_definition  name: (namespace_name) @nsname) @rng)
+((class_declaration     name: (name) @cname) @rng)
+((interface_declaration name: (name) @iname) @rng)
+((trait_declaration     name: (name) @tname) @rng)
+((enum_declaration      name: (name) @ename) @rng)
+((function_definition   name: (name) @fname) @rng)
+((method_declaration    name: (name) @mname) @rng)
+((const_declaration (const_element (name) @kname)) @rng)
+((enum_case name: (name) @kname) @rng)
+((property_declaration (property_element (variable_name (name) @pname))) @rng)
@@ -0,0 +1 @@
+((namespace_use_declaration) @rng)
@@ -0,0 +1 @@
+((name) @id) @rng
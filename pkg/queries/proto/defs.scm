@@ -0,0 +1,10 @@
+((package (full_ident) @pkgname) @rng)
+((message (message_name (identifier) @mname)) @rng)
+((enum (enum_name (identifier) @ename)) @rng)
+((enum_field (identifier) @vname) @rng)
+((field (identifier) @fname) @rng)
+((map_field (identifier) @fname) @rng)
+((oneof_field (identifier) @fname) @rng)
+((oneof (identifier) @oname) @rng)
+((service (service_name (identifier) @sname)) @rng)
+((rpc (rpc_name (identifier) @rname)) @rng)
@@ -0,0 +1,3 @@
+((package (full_ident) @package))
+((import path: (string) @path) @rng)
+((option (identifier) @option (constant (string) @value)))
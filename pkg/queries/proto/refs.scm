@@ -0,0 +1 @@
+(message_or_enum_type (identifier) @id @rng)
@@ -0,0 +1,10 @@
+((class name: (constant) @owner body: (body_statement [(method name: (identifier) @mname) (singleton_method name: (identifier) @mname)] @rng)))
+((module name: (constant) @owner body: (body_statement [(method name: (identifier) @mname) (singleton_method name: (identifier) @mname)] @rng)))
+((program [(method name: (identifier) @fname) (singleton_method name: (identifier) @fname)] @rng))
+((class name: [(constant) @cname (scope_resolution name: (constant) @cname)]) @rng)
+((module name: [(constant) @modname (scope_resolution name: (constant) @modname)]) @rng)
+((assignment left: (constant) @kname) @rng)
+((assignment left: (identifier) @vname) @rng)
+(method_parameters [(identifier) @vname (optional_parameter name: (identifier) @vname) (keyword_parameter name: (identifier) @vname)] @rng)
+(lambda_parameters (identifier) @vname @rng)
+(block_parameters (identifier) @vname @rng)
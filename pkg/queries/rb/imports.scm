@@ -0,0 +1 @@
+((call method: (identifier) @_req arguments: (argument_list . (string (string_content) @path))) @rng (#match? @_req "^(require|require_relative|load)$"))
@@ -0,0 +1,2 @@
+((identifier) @id) @rng
+((constant) @id) @rng
@@ -0,0 +1,3 @@
+(method) @scope
+(singleton_method) @scope
+(lambda) @scope
@@ -0,0 +1 @@
+((script_element (start_tag) @tag (raw_text) @body) @rng)
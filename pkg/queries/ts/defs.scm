@@ -3,3 +3,5 @@
 ((lexical_declaration (variable_declarator name: (identifier) @vname)) @rng)
 ((interface_declaration name: (type_identifier) @iname) @rng)
 ((enum_declaration       name: (identifier) @ename) @rng)
+((class_declaration name: (type_identifier) @owner body: (class_body [(method_definition name: (property_identifier) @mname) (method_signature name: (property_identifier) @mname)] @rng)))
+((interface_declaration name: (type_identifier) @owner body: (interface_body (method_signature name: (property_identifier) @mname) @rng)))
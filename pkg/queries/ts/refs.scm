@@ -1 +1,2 @@
 ((identifier) @id) @rng
+(member_expression property: (property_identifier) @id @rng)
@@ -1,9 +1,20 @@
 package xref
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
 
 	sitter "github.com/smacker/go-tree-sitter"
 )
@@ -11,15 +22,430 @@ import (
 //go:embed queries/*/*.scm
 var qfs embed.FS
 
-func loadQuery(langFolder, file string, tsLang *sitter.Language) (*sitter.Query, error) {
-	path := filepath.ToSlash(filepath.Join("queries", langFolder, file))
-	b, err := qfs.ReadFile(path)
+// querySource reads query files from an override directory laid out like the embedded queries
+// (<dir>/go/defs.scm shadows queries/go/defs.scm), falling back to the embedded files. A nil
+// source reads the embedded files only.
+type querySource struct {
+	dir        string
+	files      map[string][]byte // "go/defs.scm" -> override contents
+	version    string
+	noEmbedded bool // the files are all there is (an AdapterSpec's own queries)
+}
+
+// loadQuerySource reads the .scm files of an override directory. A file that shadows no
+// embedded query is an error, so a misspelled name doesn't go unnoticed.
+func loadQuerySource(dir string) (*querySource, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.scm"))
+	if err != nil {
+		return nil, err
+	}
+	src := &querySource{dir: dir, files: map[string][]byte{}, version: querySetVersion()}
+	for _, path := range paths {
+		rel := filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+		if _, err := fs.Stat(qfs, "queries/"+rel); err != nil {
+			return nil, fmt.Errorf("%s: no embedded query queries/%s to override", path, rel)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		src.files[rel] = b
+	}
+	if len(src.files) > 0 {
+		h := sha256.New()
+		h.Write([]byte(src.version))
+		for _, rel := range slices.Sorted(maps.Keys(src.files)) {
+			fmt.Fprintf(h, "\x00%s\x00%d\x00", rel, len(src.files[rel]))
+			h.Write(src.files[rel])
+		}
+		src.version = hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return src, nil
+}
+
+// read returns a query file and the name to report it under.
+func (s *querySource) read(langFolder, file string) ([]byte, string, error) {
+	rel := langFolder + "/" + file
+	if s != nil {
+		if b, ok := s.files[rel]; ok {
+			return b, filepath.Join(s.dir, langFolder, file), nil
+		}
+	}
+	if s != nil && s.noEmbedded {
+		return nil, "", fmt.Errorf("read %s: %w", rel, fs.ErrNotExist)
+	}
+	b, err := qfs.ReadFile("queries/" + rel)
 	if err != nil {
-		return nil, fmt.Errorf("read %s: %w", path, err)
+		return nil, "", fmt.Errorf("read queries/%s: %w", rel, err)
+	}
+	return b, "queries/" + rel, nil
+}
+
+// has reports whether a query file exists, overridden or embedded.
+func (s *querySource) has(langFolder, file string) bool {
+	if s != nil {
+		if _, ok := s.files[langFolder+"/"+file]; ok {
+			return true
+		}
+		if s.noEmbedded {
+			return false
+		}
+	}
+	_, err := fs.Stat(qfs, "queries/"+langFolder+"/"+file)
+	return err == nil
+}
+
+// overrides reports whether any of the files of a language folder is overridden.
+func (s *querySource) overrides(langFolder string, files ...string) bool {
+	if s == nil {
+		return false
+	}
+	for _, file := range files {
+		if _, ok := s.files[langFolder+"/"+file]; ok {
+			return true
+		}
 	}
-	q, err := sitter.NewQuery([]byte(b), tsLang)
+	return false
+}
+
+// queryVersion identifies the queries read through the source: the embedded ones, and the
+// overrides if any.
+func (s *querySource) queryVersion() string {
+	if s == nil {
+		return querySetVersion()
+	}
+	return s.version
+}
+
+func loadQuery(src *querySource, langFolder, file string, tsLang *sitter.Language) (*sitter.Query, []byte, error) {
+	b, name, err := src.read(langFolder, file)
 	if err != nil {
-		return nil, fmt.Errorf("compile %s: %w", path, err)
+		return nil, nil, err
+	}
+	q, err := sitter.NewQuery(b, tsLang)
+	if err != nil {
+		return nil, nil, newQueryError(name, b, err)
+	}
+	return q, b, nil
+}
+
+// QueryError reports a query file that does not compile: where the error is, and the top-level
+// pattern containing it.
+type QueryError struct {
+	File    string // the override file, or queries/<lang>/<file> for an embedded query
+	Line    int    // 1-based
+	Col     int    // 1-based, in bytes
+	Pattern string // the offending top-level pattern
+	Msg     string // what is wrong, e.g. invalid node type "fn_decl"
+}
+
+func (e *QueryError) Error() string {
+	pattern := strings.Join(strings.Fields(e.Pattern), " ")
+	if len(pattern) > 200 {
+		pattern = pattern[:200] + "..."
+	}
+	return fmt.Sprintf("%s:%d:%d: %s in pattern %s", e.File, e.Line, e.Col, e.Msg, pattern)
+}
+
+// newQueryError locates a tree-sitter query error in the file it came from.
+func newQueryError(file string, src []byte, err error) error {
+	var qe *sitter.QueryError
+	if !errors.As(err, &qe) {
+		return fmt.Errorf("%s: %w", file, err) // e.g. a malformed predicate, which has no position
+	}
+	off := min(int(qe.Offset), len(src))
+	e := &QueryError{
+		File:    file,
+		Line:    1 + bytes.Count(src[:off], []byte("\n")),
+		Col:     off - bytes.LastIndexByte(src[:off], '\n'),
+		Pattern: patternAt(src, off),
+	}
+	ident := src[off:]
+	if i := bytes.IndexFunc(ident, func(r rune) bool {
+		return !(r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	}); i >= 0 {
+		ident = ident[:i]
+	}
+	switch qe.Type {
+	case sitter.QueryErrorNodeType:
+		e.Msg = fmt.Sprintf("invalid node type %q", ident)
+	case sitter.QueryErrorField:
+		e.Msg = fmt.Sprintf("invalid field %q", ident)
+	case sitter.QueryErrorCapture:
+		e.Msg = fmt.Sprintf("invalid capture %q", ident)
+	case sitter.QueryErrorStructure:
+		e.Msg = "impossible pattern structure"
+	case sitter.QueryErrorLanguage:
+		e.Msg = "incompatible grammar"
+	default:
+		e.Msg = "syntax error"
+	}
+	return e
+}
+
+// patternAt returns the top-level pattern of a query containing the byte offset off: from the
+// last top-level "(" or "[" at or before it up to the next one, without trailing comments.
+func patternAt(src []byte, off int) string {
+	start, end, depth := -1, len(src), 0
+scan:
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case ';': // Comment to the end of the line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '(', '[':
+			if depth == 0 {
+				if i > off && start >= 0 {
+					end = i
+					break scan
+				}
+				start = i
+			}
+			depth++
+		case ')', ']':
+			depth = max(depth-1, 0)
+		}
+	}
+	if start < 0 || start > off { // Before the first pattern: report the line
+		start = bytes.LastIndexByte(src[:off], '\n') + 1
+		end = off + max(bytes.IndexByte(src[off:], '\n'), 0)
+		if bytes.IndexByte(src[off:], '\n') < 0 {
+			end = len(src)
+		}
+	}
+	lines := strings.Split(strings.TrimRight(string(src[start:end]), " \t\r\n"), "\n")
+	for len(lines) > 1 && (strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), ";") || strings.TrimSpace(lines[len(lines)-1]) == "") {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// querySetVersion identifies the embedded queries, so indexes extracted with different queries
+// are not mistaken for current ones.
+var querySetVersion = sync.OnceValue(func() string {
+	h := sha256.New()
+	fs.WalkDir(qfs, "queries", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		b, _ := qfs.ReadFile(path)
+		fmt.Fprintf(h, "%s\x00%d\x00", path, len(b))
+		h.Write(b)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)[:8])
+})
+
+// querySet holds the queries of a language (defs, refs, imports) along with one query combining
+// all their patterns, so extraction walks each tree once instead of once per query. Matches of
+// the combined query are handed out per part with the part's own capture IDs, so visitors keep
+// resolving capture names through the part's query.
+type querySet struct {
+	parts []*sitter.Query
+	all   *sitter.Query // nil if the parts could not be compiled together
+	first []uint32      // index in all of each part's first pattern
+	ids   [][]uint32    // per part: capture ID in all -> capture ID in the part
+	text  []byte        // source of all parts, to tell whether a reload changed them
+
+	predicates bool // some pattern has predicates (#eq?, #match?, ...) that matches must pass
+}
+
+// querySets caches compiled sets of embedded queries, which are read-only once built, so
+// engines share them instead of compiling every language's queries again in each New. Sets
+// with overridden files are compiled on each load, as the files may have changed.
+var querySets struct {
+	mu   sync.Mutex
+	sets map[querySetKey]*querySet
+}
+
+// querySetKey identifies a set of embedded queries. The folder implies the grammar: grammar
+// packages return a new *sitter.Language on each call, so it can't be part of the key.
+type querySetKey struct {
+	langFolder string
+	files      string
+}
+
+// loadQuerySet loads the given query files of a language folder, in order, through src.
+// Embedded files are compiled once per process.
+func loadQuerySet(src *querySource, langFolder string, tsLang *sitter.Language, files ...string) (*querySet, error) {
+	if src.overrides(langFolder, files...) {
+		return compileQuerySet(src, langFolder, tsLang, files...)
+	}
+	key := querySetKey{langFolder, strings.Join(files, "\x00")}
+	querySets.mu.Lock()
+	defer querySets.mu.Unlock()
+	if qs, ok := querySets.sets[key]; ok {
+		return qs, nil
+	}
+	qs, err := compileQuerySet(nil, langFolder, tsLang, files...)
+	if err != nil {
+		return nil, err
+	}
+	if querySets.sets == nil {
+		querySets.sets = map[querySetKey]*querySet{}
+	}
+	querySets.sets[key] = qs
+	return qs, nil
+}
+
+// compileQuerySet compiles the files of a set, reporting every file that does not compile.
+func compileQuerySet(src *querySource, langFolder string, tsLang *sitter.Language, files ...string) (*querySet, error) {
+	qs := &querySet{}
+	var errs []error
+	for _, file := range files {
+		q, b, err := loadQuery(src, langFolder, file, tsLang)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		qs.parts = append(qs.parts, q)
+		qs.text = append(append(qs.text, b...), '\n')
+		qs.predicates = qs.predicates || hasPredicates(q)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	all, err := sitter.NewQuery(qs.text, tsLang)
+	if err != nil {
+		return qs, nil // Run the parts one by one
+	}
+	names := map[string]uint32{}
+	for id := range all.CaptureCount() {
+		names[all.CaptureNameForId(id)] = id
+	}
+	var first uint32
+	for _, q := range qs.parts {
+		ids := make([]uint32, all.CaptureCount())
+		for id := range q.CaptureCount() {
+			ids[names[q.CaptureNameForId(id)]] = id
+		}
+		qs.first = append(qs.first, first)
+		qs.ids = append(qs.ids, ids)
+		first += q.PatternCount()
+	}
+	if first != all.PatternCount() {
+		return qs, nil // Patterns didn't line up; don't risk misattributing matches
+	}
+	qs.all = all
+	return qs, nil
+}
+
+// symbolQueries are the defs, refs and imports queries of a language, compiled as one set.
+type symbolQueries struct {
+	queries               *querySet
+	qDefs, qRefs, qImport *sitter.Query
+}
+
+func loadSymbolQueries(src *querySource, langFolder string, tsLang *sitter.Language) (symbolQueries, error) {
+	qs, err := loadQuerySet(src, langFolder, tsLang, "defs.scm", "refs.scm", "imports.scm")
+	if err != nil {
+		return symbolQueries{}, err
+	}
+	return symbolQueries{queries: qs, qDefs: qs.parts[0], qRefs: qs.parts[1], qImport: qs.parts[2]}, nil
+}
+
+func (q symbolQueries) querySets() []*querySet { return []*querySet{q.queries} }
+
+// queryPredicates caches hasPredicates per compiled query, for execQuery.
+var queryPredicates sync.Map // *sitter.Query -> bool
+
+// hasPredicates reports whether any pattern of q has predicates. The queries of the built-in
+// adapters have none, so their matches skip the filtering.
+func hasPredicates(q *sitter.Query) bool {
+	if v, ok := queryPredicates.Load(q); ok {
+		return v.(bool)
+	}
+	has := false
+	for i := range q.PatternCount() {
+		if len(q.PredicatesForPattern(i)) > 0 {
+			has = true
+			break
+		}
+	}
+	queryPredicates.Store(q, has)
+	return has
+}
+
+// part returns the index of q in the set, or -1.
+func (qs *querySet) part(q *sitter.Query) int {
+	for i, p := range qs.parts {
+		if p == q {
+			return i
+		}
+	}
+	return -1
+}
+
+// queryPass gives the matches of queries over one tree. A pass made by querySet.run holds the
+// matches of every part from a single walk; other queries (or a pass built directly) are run on
+// demand with execQuery.
+type queryPass struct {
+	src     []byte
+	root    *sitter.Node
+	set     *querySet
+	matches [][][]sitter.QueryCapture // per part of set, when run combined
+}
+
+// lazyPass returns a pass over root that runs each query on demand, for callers that need only
+// one or two of a set's queries.
+func lazyPass(src []byte, root *sitter.Node) *queryPass {
+	return &queryPass{src: src, root: root}
+}
+
+// run matches all queries of the set against root, in one walk when they could be combined.
+func (qs *querySet) run(src []byte, root *sitter.Node) *queryPass {
+	p := &queryPass{src: src, root: root}
+	if qs.all == nil {
+		return p
+	}
+	p.set = qs
+	p.matches = make([][][]sitter.QueryCapture, len(qs.parts))
+	cur := cursorPool.Get().(*sitter.QueryCursor)
+	defer cursorPool.Put(cur)
+	cur.Exec(qs.all, root)
+	for {
+		m, ok := cur.NextMatch()
+		if !ok {
+			break
+		}
+		if qs.predicates {
+			if m = cur.FilterPredicates(m, src); len(m.Captures) == 0 {
+				continue
+			}
+		}
+		i := len(qs.first) - 1
+		for i > 0 && uint32(m.PatternIndex) < qs.first[i] {
+			i--
+		}
+		for j := range m.Captures {
+			m.Captures[j].Index = qs.ids[i][m.Captures[j].Index]
+		}
+		p.matches[i] = append(p.matches[i], m.Captures)
+	}
+	return p
+}
+
+// each calls visit for each match of q, like execQuery.
+func (p *queryPass) each(q *sitter.Query, visit func([]sitter.QueryCapture, func(id uint32) string)) {
+	i := -1
+	if p.set != nil {
+		i = p.set.part(q)
+	}
+	if i < 0 {
+		execQuery(p.src, p.root, q, visit)
+		return
+	}
+	for _, caps := range p.matches[i] {
+		visit(caps, q.CaptureNameForId)
 	}
-	return q, nil
 }
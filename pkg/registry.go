@@ -0,0 +1,68 @@
+package xref
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// AdapterFactory creates a language adapter for a new engine.
+type AdapterFactory func() (LanguageAdapter, error)
+
+// registry holds the adapters New can enable by name, in registration order.
+var registry = struct {
+	mu        sync.RWMutex
+	names     []string
+	factories map[string]AdapterFactory
+}{factories: map[string]AdapterFactory{}}
+
+// The built-in adapters, in the order New tries them. Those that take configuration (source
+// roots, include paths) are registered with their defaults.
+func init() {
+	Register("go", newGoAdapter)
+	Register("ts", newTsAdapter)
+	Register("py", newPyAdapter)
+	Register("java", func() (LanguageAdapter, error) { return NewJavaAdapter() })
+	Register("c", func() (LanguageAdapter, error) { return NewCAdapter() })
+	Register("cs", newCsAdapter)
+	Register("php", newPhpAdapter)
+	Register("kt", func() (LanguageAdapter, error) { return NewKtAdapter() })
+	Register("proto", newProtoAdapter)
+	Register("rb", newRbAdapter)
+}
+
+// Register makes an adapter available to New under name, which should be the adapter's Lang.
+// Engines created without WithLanguages get every registered adapter, in registration order.
+// Registering a name again replaces its factory, e.g. to configure a built-in adapter.
+func Register(name string, factory AdapterFactory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.factories[name]; !ok {
+		registry.names = append(registry.names, name)
+	}
+	registry.factories[name] = factory
+}
+
+// Registered returns the names of the registered adapters, in registration order.
+func Registered() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return slices.Clone(registry.names)
+}
+
+// registeredFactory returns the factory registered under name, or under the language name
+// that it is an alias of in Markdown fences ("python", "golang", ...).
+func registeredFactory(name string) (string, AdapterFactory, error) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	if f, ok := registry.factories[name]; ok {
+		return name, f, nil
+	}
+	if lang, ok := fenceLangs[strings.ToLower(name)]; ok {
+		if f, ok := registry.factories[lang]; ok {
+			return lang, f, nil
+		}
+	}
+	return "", nil, fmt.Errorf("xref: no adapter registered for language %q", name)
+}
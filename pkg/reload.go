@@ -0,0 +1,215 @@
+package xref
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reloadableAdapter is implemented by the built-in adapters, whose queries ReloadQueries
+// replaces.
+type reloadableAdapter interface {
+	LanguageAdapter
+	querySets() []*querySet
+	withQueries(src *querySource) (LanguageAdapter, error)
+}
+
+// reloadableInjector is implemented by the built-in injectors that find regions with a query.
+type reloadableInjector interface {
+	Injector
+	querySets() []*querySet
+	withQueries(src *querySource) (Injector, error)
+}
+
+// queryVersion identifies the engine's queries.
+func (e *Engine) queryVersion() string {
+	e.adaptMu.RLock()
+	defer e.adaptMu.RUnlock()
+	return e.queries.queryVersion()
+}
+
+// extractVersion identifies what extraction depends on besides the source: the queries, and
+// the Build context. It keys the caches of extraction results.
+func (e *Engine) extractVersion() string {
+	v := e.queryVersion()
+	if bc := e.Build; bc != nil {
+		v += fmt.Sprintf(" build=%s/%s,cgo=%t,%s,%v,%v,%v", bc.GOOS, bc.GOARCH, bc.CgoEnabled, bc.Compiler, bc.BuildTags, bc.ToolTags, bc.ReleaseTags)
+	}
+	return v
+}
+
+// ReloadQueries recompiles the tree-sitter queries of the built-in adapters and injectors with
+// the .scm files in dir shadowing the embedded ones: dir/go/defs.scm replaces queries/go/defs.scm,
+// and languages without files in dir keep the embedded queries. An empty dir restores the
+// embedded queries everywhere. Indexed files whose queries changed are then re-extracted, and
+// the report covers them.
+//
+// All queries are compiled before anything changes: if a file does not compile, ReloadQueries
+// returns every such file's *QueryError (joined) and the engine keeps its current queries.
+// Call it again with the same dir after editing the files. Caches of extraction results (Blobs,
+// Extracts, IndexCached) are keyed by the queries, so results of other queries are not reused.
+func (e *Engine) ReloadQueries(dir string) (*IndexReport, error) {
+	var src *querySource
+	if dir != "" {
+		var err error
+		if src, err = loadQuerySource(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	e.adaptMu.RLock()
+	adapters, injectors := slices.Clone(e.Adapters), slices.Clone(e.Injectors)
+	e.adaptMu.RUnlock()
+	var errs []error
+	var changed []any // new adapters and injectors whose queries differ (built-in, so comparable)
+	for i, a := range adapters {
+		ra, ok := a.(reloadableAdapter)
+		if !ok {
+			continue // Not built in: its queries are its own
+		}
+		na, err := ra.withQueries(src)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !sameQueries(ra.querySets(), na.(reloadableAdapter).querySets()) {
+			adapters[i] = na
+			changed = append(changed, na)
+		}
+	}
+	for i, inj := range injectors {
+		ri, ok := inj.(reloadableInjector)
+		if !ok {
+			continue
+		}
+		ni, err := ri.withQueries(src)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !sameQueries(ri.querySets(), ni.(reloadableInjector).querySets()) {
+			injectors[i] = ni
+			changed = append(changed, ni)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	changedLangs := map[string]bool{}
+	for _, a := range changed {
+		if a, ok := a.(LanguageAdapter); ok {
+			changedLangs[a.Lang()] = true
+		}
+	}
+
+	e.adaptMu.Lock()
+	e.Adapters, e.Injectors, e.queries = adapters, injectors, src
+	e.adaptMu.Unlock()
+
+	began := time.Now()
+	var paths []string
+	for _, path := range e.store().Files() {
+		if e.queriesChangedFor(path, changed, changedLangs) {
+			paths = append(paths, path)
+		}
+	}
+	report := e.reextract(paths)
+	start := time.Now()
+	e.Index.linkGenerated()
+	since(&report.Timing.Link, start)
+	report.Timing.Total = time.Since(began)
+	e.logger().Info("reloaded queries", "dir", dir, "reextracted", len(paths), "failed", report.Counts.Failed)
+	return report, nil
+}
+
+// sameQueries reports whether two lists of query sets were compiled from the same source.
+func sameQueries(a, b []*querySet) bool {
+	return slices.EqualFunc(a, b, func(x, y *querySet) bool { return bytes.Equal(x.text, y.text) })
+}
+
+// queriesChangedFor reports whether a file extracts differently after a reload: its adapter or
+// injector changed, or one of its embedded regions is in a language whose adapter changed.
+func (e *Engine) queriesChangedFor(path string, changed []any, changedLangs map[string]bool) bool {
+	if a := e.pickAdapter(path); a != nil && slices.Contains(changed, any(a)) {
+		return true
+	}
+	inj := e.pickInjector(path)
+	if inj == nil || slices.Contains(changed, any(inj)) {
+		return inj != nil
+	}
+	if len(changedLangs) == 0 {
+		return false
+	}
+	src, err := e.readSource(path)
+	if err != nil {
+		return true // Re-extracting reports the error
+	}
+	regions, err := inj.Regions(path, src)
+	if err != nil {
+		return true
+	}
+	return slices.ContainsFunc(regions, func(r Region) bool { return changedLangs[r.Lang] })
+}
+
+// reextract re-extracts indexed files with the engine's workers, replacing their contribution to
+// the store. A file that can no longer be read keeps its previous contribution.
+func (e *Engine) reextract(paths []string) *IndexReport {
+	report := &IndexReport{Unsupported: map[string]int{}, Excluded: map[string]int{}}
+	report.Counts.Discovered = len(paths)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ch := make(chan string)
+	for range e.workers(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range ch {
+				tr := &extractTrace{}
+				st, stamped := e.statSource(path)
+				start := time.Now()
+				src, err := e.readSource(path)
+				since(&tr.timing.Read, start)
+				var fis []*FileIndex
+				if err != nil {
+					tr.errs = append(tr.errs, err)
+				} else {
+					fis = e.extract(path, src, tr)
+					start = time.Now()
+					err := e.store().PutFile(path, fis)
+					since(&tr.timing.Merge, start)
+					if err != nil {
+						tr.errs = append(tr.errs, fmt.Errorf("%s: store: %w", path, err))
+						fis = nil
+					} else if stamped {
+						e.stamp(path, st)
+					}
+				}
+				mu.Lock()
+				switch {
+				case len(fis) > 0:
+					report.Counts.Parsed++
+				case len(tr.errs) > 0:
+					report.Counts.Failed++
+				default:
+					report.Counts.Skipped++
+				}
+				report.Counts.Bytes += int64(len(src))
+				report.reportFile(path, fis, tr, true)
+				report.Timing.add(tr.timing)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		ch <- path
+	}
+	close(ch)
+	wg.Wait()
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].File < report.Files[j].File })
+	report.Counts.Done = true
+	return report
+}
@@ -0,0 +1,137 @@
+package xref
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// queryErrors returns the *QueryErrors in a (possibly joined) error.
+func queryErrors(err error) []*QueryError {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []*QueryError
+		for _, err := range j.Unwrap() {
+			out = append(out, queryErrors(err)...)
+		}
+		return out
+	}
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return []*QueryError{qe}
+	}
+	return nil
+}
+
+// TestReloadQueriesRejectsInvalid reloads query directories with broken files and checks that
+// each is reported and the engine keeps extracting with the queries it had.
+func TestReloadQueriesRejectsInvalid(t *testing.T) {
+	embedded := func(rel string) string {
+		b, err := qfs.ReadFile("queries/" + rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	goDefs := embedded("go/defs.scm")
+	tests := []struct {
+		name    string
+		files   map[string]string // override dir: lang folder/file -> contents
+		want    []string          // "file:line: message" of each QueryError, file relative to the dir
+		wantErr string            // in the error, if it is not a QueryError
+	}{
+		{
+			name:  "invalid node type",
+			files: map[string]string{"go/defs.scm": strings.Replace(goDefs, "function_declaration", "fn_declaration", 1)},
+			want:  []string{`go/defs.scm:1: invalid node type "fn_declaration"`},
+		},
+		{
+			name:  "invalid field",
+			files: map[string]string{"go/defs.scm": "((function_declaration title: (identifier) @fname) @rng)\n"},
+			want:  []string{`go/defs.scm:1: invalid field "title"`},
+		},
+		{
+			name:  "unbalanced parentheses",
+			files: map[string]string{"py/refs.scm": "; calls\n(identifier) @name\n\n((call function: (identifier) @x) @rng\n"},
+			want:  []string{"py/refs.scm:5: syntax error"}, // Reported where the input ends
+		},
+		{
+			name: "errors in several languages",
+			files: map[string]string{
+				"go/defs.scm": strings.Replace(goDefs, "function_declaration", "fn_declaration", 1),
+				"ts/refs.scm": "(identifier) @name\n(call_expression funct: (identifier) @x) @rng\n",
+			},
+			want: []string{`go/defs.scm:1: invalid node type "fn_declaration"`, `ts/refs.scm:2: invalid field "funct"`},
+		},
+		{
+			name:    "misspelled language folder",
+			files:   map[string]string{"golang/defs.scm": goDefs},
+			wantErr: "no embedded query queries/golang/defs.scm to override",
+		},
+		{
+			name:    "misspelled query file",
+			files:   map[string]string{"go/def.scm": goDefs},
+			wantErr: "no embedded query queries/go/def.scm to override",
+		},
+	}
+
+	fsys := fstest.MapFS{
+		"a.go": {Data: []byte("package a\n\ntype T struct{}\n\nfunc F() T { return T{} }\n")},
+		"m.py": {Data: []byte("def f():\n    return g()\n\ndef g():\n    return 1\n")},
+		"x.ts": {Data: []byte("function h() { return k(); }\nfunction k() { return 1; }\n")},
+	}
+	e, err := New(WithLanguages("go", "py", "ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	// Start from overridden queries, which a failed reload must not revert to the embedded ones
+	valid := t.TempDir()
+	writeTree(t, valid, map[string]string{"go/defs.scm": strings.Replace(goDefs, "((function_declaration", "; ((function_declaration", 1)})
+	if _, err := e.ReloadQueries(valid); err != nil {
+		t.Fatal(err)
+	}
+	defs, version := e.GetDefinitions(), e.queryVersion()
+	if _, ok := defs["go::a.go::F"]; ok {
+		t.Fatalf("definitions %v include F, dropped by the override", defs)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, tt.files)
+			report, err := e.ReloadQueries(dir)
+			if err == nil {
+				t.Fatalf("ReloadQueries succeeded: %+v", report.Counts)
+			}
+			var got []string
+			for _, qe := range queryErrors(err) {
+				rel, _ := filepath.Rel(dir, qe.File)
+				got = append(got, fmt.Sprintf("%s:%d: %s", filepath.ToSlash(rel), qe.Line, qe.Msg))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("query errors %q, want %q (error: %v)", got, tt.want, err)
+			}
+			if tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q, want it to mention %q", err, tt.wantErr)
+			}
+
+			if v := e.queryVersion(); v != version {
+				t.Errorf("query version %s after a failed reload, want %s", v, version)
+			}
+			if err := e.IndexFS(fsys, "."); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.GetDefinitions(); !maps.Equal(got, defs) {
+				t.Errorf("definitions after a failed reload %v, want %v", got, defs)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package xref
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// maxSyntaxErrors bounds the syntax error ranges kept per file; a badly broken file would
+// otherwise report one per token.
+const maxSyntaxErrors = 100
+
+// IndexReport describes an indexing run, so a missing definition can be traced to the file that
+// failed to read, parse or extract, or to the syntax errors tree-sitter recovered from.
+type IndexReport struct {
+	Files       []FileReport   // files that failed or were only partially parsed, sorted by path
+	Unsupported map[string]int // extension ("" for none) -> files no adapter or injector handles
+	Excluded    map[string]int // reason ("too large", "binary") -> files skipped after discovery
+	Counts      IndexProgress  // final counters of the run
+	Timing      IndexTiming
+}
+
+// FileReport is the diagnostic of one file.
+type FileReport struct {
+	File string
+	// Err is why the file, or part of it (an embedded region), was not indexed; nil if it was
+	// indexed in full despite syntax errors.
+	Err error
+	// Failed reports that nothing of the file was indexed.
+	Failed bool
+	// SyntaxErrors are the ranges of ERROR and MISSING nodes of the file's syntax trees.
+	SyntaxErrors []Range
+}
+
+// IndexTiming is the time spent per indexing phase. Walk, Link and Total are wall time; the
+// phases run by the workers are summed over all workers, so they can exceed Total.
+type IndexTiming struct {
+	Walk    time.Duration // discovering files, while workers already parse (not waiting for them)
+	Read    time.Duration // reading sources
+	Parse   time.Duration // building syntax trees
+	Extract time.Duration // running queries and building FileIndexes
+	Merge   time.Duration // replacing files in the project index
+	Link    time.Duration // linking generated code to its sources
+	Total   time.Duration
+}
+
+// add sums the worker phases of o into t.
+func (t *IndexTiming) add(o IndexTiming) {
+	t.Read += o.Read
+	t.Parse += o.Parse
+	t.Extract += o.Extract
+	t.Merge += o.Merge
+}
+
+// String formats the report for logs: counts, one line per file diagnostic, and unsupported
+// extensions.
+func (r *IndexReport) String() string {
+	var b strings.Builder
+	c := r.Counts
+	fmt.Fprintf(&b, "%d files: %d parsed, %d skipped, %d failed, %d bytes in %v\n",
+		c.Discovered, c.Parsed, c.Skipped, c.Failed, c.Bytes, r.Timing.Total.Round(time.Millisecond))
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "%s:", f.File)
+		if f.Err != nil {
+			fmt.Fprintf(&b, " %v", f.Err)
+		}
+		if n := len(f.SyntaxErrors); n > 0 {
+			s := f.SyntaxErrors[0].Start
+			fmt.Fprintf(&b, " %d syntax errors (first at %d:%d)", n, s.Line, s.Col)
+		}
+		b.WriteByte('\n')
+	}
+	exts := make([]string, 0, len(r.Unsupported))
+	for ext := range r.Unsupported {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		name := ext
+		if name == "" {
+			name = "(no extension)"
+		}
+		fmt.Fprintf(&b, "unsupported %s: %d\n", name, r.Unsupported[ext])
+	}
+	reasons := make([]string, 0, len(r.Excluded))
+	for reason := range r.Excluded {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "excluded %s: %d\n", reason, r.Excluded[reason])
+	}
+	return b.String()
+}
+
+// reportFile records the outcome of extracting one file. The caller serializes calls.
+func (r *IndexReport) reportFile(path string, fis []*FileIndex, tr *extractTrace, supported bool) {
+	if tr.excluded != "" {
+		r.Excluded[tr.excluded]++
+		return
+	}
+	if len(fis) == 0 && len(tr.errs) == 0 && !supported {
+		r.Unsupported[strings.ToLower(filepath.Ext(path))]++
+		return
+	}
+	fr := FileReport{File: path, Failed: len(fis) == 0 && len(tr.errs) > 0}
+	fr.Err = errors.Join(tr.errs...)
+	for _, fi := range fis {
+		fr.SyntaxErrors = append(fr.SyntaxErrors, fi.SyntaxErrors...)
+	}
+	if fr.Err != nil || len(fr.SyntaxErrors) > 0 {
+		r.Files = append(r.Files, fr)
+	}
+}
+
+// extractTrace collects the errors and phase timings of extracting one file.
+type extractTrace struct {
+	errs     []error
+	excluded string // why the file was skipped without extraction, if it was
+	timing   IndexTiming
+}
+
+// since adds the time elapsed since start to a phase of the trace's timing.
+func since(phase *time.Duration, start time.Time) { *phase += time.Since(start) }
+
+// syntaxErrors returns the ranges of the ERROR and MISSING nodes under n, descending only into
+// subtrees that contain errors.
+func syntaxErrors(n *sitter.Node) []Range {
+	if n == nil || !n.HasError() {
+		return nil
+	}
+	var out []Range
+	var walk func(n *sitter.Node)
+	walk = func(n *sitter.Node) {
+		if len(out) >= maxSyntaxErrors {
+			return
+		}
+		if n.IsError() || n.IsMissing() {
+			sb, eb := n.StartPoint(), n.EndPoint()
+			out = append(out, Range{Start: Pos{int(sb.Row) + 1, int(sb.Column) + 1}, End: Pos{int(eb.Row) + 1, int(eb.Column) + 1}})
+			return
+		}
+		for i := 0; i < int(n.ChildCount()); i++ {
+			if c := n.Child(i); c != nil && (c.HasError() || c.IsMissing()) {
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return out
+}
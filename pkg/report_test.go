@@ -0,0 +1,103 @@
+package xref
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestIndexReport indexes a tree with syntax errors in a Go file and in a Markdown fence, files
+// indexing skips or leaves out, and a path that doesn't exist, and checks what the report says
+// of each.
+func TestIndexReport(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ok.go":     "package a\n\nfunc A() {}\n",
+		"broken.go": "package a\n\nfunc B( {\n}\n\nfunc C() {}\n",
+		"README.md": "# A\n\n```go\nfunc D() { x := }\n```\n",
+		"notes.txt": "text\n",
+		"Makefile":  "all:\n",
+		"big.go":    "package a\n\n" + strings.Repeat("// padding\n", 20),
+		"bin.go":    "package a\x00\n",
+	}
+	writeTree(t, dir, files)
+	e, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Walk.MaxFileSize = 100
+	missing := filepath.Join(dir, "missing.go")
+	r, err := e.IndexPathsContext(context.Background(), IndexOptions{}, dir, missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bytes int64 // everything read, which is all but the file too large to read
+	for name, src := range files {
+		if name != "big.go" {
+			bytes += int64(len(src))
+		}
+	}
+	want := IndexProgress{Discovered: 7, Parsed: 3, Skipped: 4, Failed: 1, Bytes: bytes, Done: true}
+	if r.Counts != want {
+		t.Errorf("counts %+v, want %+v", r.Counts, want)
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		failed     bool
+		notExist   bool
+		syntaxErrs []Range
+	}{
+		{"syntax error in an embedded region, at its place in the host", "README.md", false, false, []Range{{Start: Pos{4, 16}, End: Pos{4, 16}}}},
+		{"syntax error", "broken.go", false, false, []Range{{Start: Pos{3, 8}, End: Pos{3, 8}}}},
+		{"missing path", "missing.go", true, true, nil},
+	}
+	if len(r.Files) != len(tests) {
+		t.Fatalf("%d file reports, want %d:\n%s", len(r.Files), len(tests), r)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := r.Files[i]
+			if f.File != filepath.Join(dir, tt.file) {
+				t.Fatalf("report %d is of %s, want %s", i, f.File, tt.file)
+			}
+			if f.Failed != tt.failed || errors.Is(f.Err, fs.ErrNotExist) != tt.notExist {
+				t.Errorf("Failed %v, Err %v; want Failed %v, not-exist error %v", f.Failed, f.Err, tt.failed, tt.notExist)
+			}
+			if !slices.Equal(f.SyntaxErrors, tt.syntaxErrs) {
+				t.Errorf("syntax errors at %v, want %v", f.SyntaxErrors, tt.syntaxErrs)
+			}
+		})
+	}
+	if got := defNames(e, filepath.Join(dir, "broken.go")); !slices.Contains(got, "C") {
+		t.Errorf("definitions of broken.go %q, want C from past the syntax error", got)
+	}
+
+	if want := map[string]int{".txt": 1, "": 1}; !maps.Equal(r.Unsupported, want) {
+		t.Errorf("unsupported %v, want %v", r.Unsupported, want)
+	}
+	if want := map[string]int{"binary": 1, "too large": 1}; !maps.Equal(r.Excluded, want) {
+		t.Errorf("excluded %v, want %v", r.Excluded, want)
+	}
+	if tm := r.Timing; tm.Total <= 0 || tm.Parse <= 0 || tm.Extract <= 0 {
+		t.Errorf("timing %+v, want total, parse and extract time", tm)
+	}
+
+	s := r.String()
+	for _, line := range []string{
+		"7 files: 3 parsed, 4 skipped, 1 failed, ",
+		filepath.Join(dir, "broken.go") + ": 1 syntax errors (first at 3:8)\n",
+		"unsupported (no extension): 1\nunsupported .txt: 1\nexcluded binary: 1\nexcluded too large: 1\n",
+	} {
+		if !strings.Contains(s, line) {
+			t.Errorf("report\n%s\nlacks %q", s, line)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package xref
+
+import (
+	"io/fs"
+	"os"
+)
+
+// osFS is the operating system's file system addressed by OS paths, relative or absolute, as
+// IndexPaths has always used them (os.DirFS would reject absolute and ../ paths).
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// setSourceFS records the file system a file was indexed from; nil (or the OS file system)
+// forgets it, so the file is read from disk.
+func (e *Engine) setSourceFS(path string, fsys fs.FS) {
+	e.docMu.Lock()
+	defer e.docMu.Unlock()
+	if _, isOS := fsys.(osFS); fsys == nil || isOS {
+		delete(e.sources, path)
+		return
+	}
+	if e.sources == nil {
+		e.sources = map[string]fs.FS{}
+	}
+	e.sources[path] = fsys
+}
+
+// sourceFS returns the file system a file was indexed from: the OS file system by default.
+func (e *Engine) sourceFS(path string) fs.FS {
+	e.docMu.Lock()
+	defer e.docMu.Unlock()
+	if fsys, ok := e.sources[path]; ok {
+		return fsys
+	}
+	return osFS{}
+}
+
+// readSource returns a file's contents: the open document's text if there is one, else the file
+// from the file system it was indexed from (the OS file system by default).
+func (e *Engine) readSource(path string) ([]byte, error) {
+	e.docMu.Lock()
+	doc, isDoc := e.docs[path]
+	fsys, isFS := e.sources[path]
+	e.docMu.Unlock()
+	switch {
+	case isDoc:
+		return doc.src, nil
+	case isFS:
+		return fs.ReadFile(fsys, path)
+	}
+	return os.ReadFile(path)
+}
+
+// statSource returns the size and modification time of a file as readSource would read it.
+// Open documents have no stamp: their text isn't what is on disk.
+func (e *Engine) statSource(path string) (fileStamp, bool) {
+	e.docMu.Lock()
+	_, isDoc := e.docs[path]
+	fsys, isFS := e.sources[path]
+	e.docMu.Unlock()
+	if isDoc {
+		return fileStamp{}, false
+	}
+	if !isFS {
+		fsys = osFS{}
+	}
+	info, err := fs.Stat(fsys, path)
+	if err != nil {
+		return fileStamp{}, false
+	}
+	return fileStamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, true
+}
@@ -0,0 +1,76 @@
+package xref
+
+import (
+	"archive/zip"
+	"bytes"
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// fsFiles is a small Go module with an ignored directory and a file no adapter handles. None of
+// it exists on disk.
+var fsFiles = fstest.MapFS{
+	".gitignore":   {Data: []byte("gen/\n")},
+	"app/main.go":  {Data: []byte("package main\n\nfunc main() { util.Hello() }\n")},
+	"util/util.go": {Data: []byte("package util\n\nfunc Hello() {}\n")},
+	"gen/gen.go":   {Data: []byte("package gen\n\nfunc Hello() {}\n")},
+	"notes.txt":    {Data: []byte("Hello\n")},
+}
+
+// TestIndexFS indexes file systems other than the OS's, whole and from a subdirectory, and
+// checks which files are indexed under the usual ignore rules and that lookups read the files
+// back from them.
+func TestIndexFS(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range slices.Sorted(maps.Keys(fsFiles)) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(fsFiles[name].Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		index func(e *Engine) error
+		want  []string
+	}{
+		{"map", func(e *Engine) error { return e.IndexFS(fsFiles, ".") }, []string{"app/main.go", "util/util.go"}},
+		{"zip archive", func(e *Engine) error { return e.IndexFS(zr, ".") }, []string{"app/main.go", "util/util.go"}},
+		{"roots", func(e *Engine) error { return e.IndexFS(fsFiles, "app", "util/util.go") }, []string{"app/main.go", "util/util.go"}},
+		{"one root", func(e *Engine) error { return e.IndexFS(fsFiles, "util") }, []string{"util/util.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(WithLanguages("go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.index(e); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Index.Files(); !slices.Equal(got, tt.want) {
+				t.Errorf("indexed %q, want %q", got, tt.want)
+			}
+			if !slices.Contains(tt.want, "app/main.go") {
+				return
+			}
+			d, _, err := e.FindDefinitionAt("app/main.go", 3, 20)
+			if err != nil || d.File != "util/util.go" {
+				t.Errorf("Hello defined in %q (%v), want util/util.go", d.File, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package xref
+
+import (
+	"errors"
+	"sync"
+)
+
+// Store keeps the symbol index: what each file defines, references and contains, and the
+// lookups resolvers run against it. ProjectIndex, in memory, is the default; KVStore keeps the
+// index in a key-value store, such as DiskKV for indexes too large for RAM. Implementations
+// must be safe for concurrent use: files are put by several indexing workers while queries run.
+type Store interface {
+	// PutFile replaces everything file contributed with fis: one FileIndex, or one per embedded
+	// region of a host file. Symbols the file no longer defines lose their references.
+	PutFile(file string, fis []*FileIndex) error
+	// DeleteFile removes everything file contributed.
+	DeleteFile(file string) error
+
+	// Definition returns the definition of a symbol ID.
+	Definition(sid string) (DefLocation, bool)
+	// References returns the references to a symbol ID.
+	References(sid string) []RefLocation
+	// Lookup returns the symbols named name in lang.
+	Lookup(lang, name string) []string
+	// FileLookup returns the symbols named name in lang defined in file, in source order.
+	FileLookup(file, lang, name string) []string
+	// Occurrences returns the occurrences of a file, sorted by position.
+	Occurrences(file string) []Occurrence
+	// OccurrenceAt returns the innermost occurrence of a file containing pos.
+	OccurrenceAt(file string, pos Pos) (Occurrence, bool)
+	// Imports returns the imports of a file (FileIndex.Imports of all its regions).
+	Imports(file string) map[string]string
+	// Package returns the package or namespace a file declared (FileIndex.Package), if any.
+	Package(file string) string
+	// Files returns the indexed files, sorted.
+	Files() []string
+}
+
+var _ Store = (*ProjectIndex)(nil)
+
+// updateCounter is implemented by stores that count their updates, so what is derived from the
+// whole index can be kept until the next one (see storeCache).
+type updateCounter interface {
+	updates() uint64
+}
+
+func (pi *ProjectIndex) updates() uint64 { return pi.updateCount.Load() }
+func (s *KVStore) updates() uint64       { return s.updateCount.Load() }
+
+// storeCache keeps a value an adapter derives from a whole store, such as the C include graph,
+// until the store is updated, so lookups don't each go over every file. With a store that
+// doesn't count its updates, the value is derived afresh every time.
+type storeCache[T any] struct {
+	mu      sync.Mutex
+	store   Store
+	updates uint64
+	val     T
+}
+
+// get returns the value for idx, calling derive if it wasn't derived from idx as it is now.
+func (c *storeCache[T]) get(idx Store, derive func() T) T {
+	uc, ok := idx.(updateCounter)
+	if !ok {
+		return derive()
+	}
+	n := uc.updates() // Before deriving: an update meanwhile makes the next call derive again
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store != idx || c.updates != n {
+		c.val, c.store, c.updates = derive(), idx, n
+	}
+	return c.val
+}
+
+// errCustomStore is returned by the operations that serialize the in-memory index when the
+// engine uses another Store; a persistent Store needs no saving.
+var errCustomStore = errors.New("xref: Save, Load and IndexCached work on the in-memory Index, but Engine.Store is set")
+
+// store returns where indexing writes and queries read: Store if set, else Index.
+func (e *Engine) store() Store {
+	if e.Store != nil {
+		return e.Store
+	}
+	return e.Index
+}
+
+// eachDef calls fn for every definition of the store. A Store lists definitions only through
+// the def occurrences of its files, so other stores are walked file by file.
+func (e *Engine) eachDef(fn func(sid string, d DefLocation)) {
+	if e.Store == nil {
+		pi := e.Index
+		pi.mu.RLock()
+		defer pi.mu.RUnlock()
+		for k, d := range pi.allDefs() {
+			fn(pi.sid(k), d)
+		}
+		return
+	}
+	seen := map[string]struct{}{}
+	for _, file := range e.Store.Files() {
+		for _, o := range e.Store.Occurrences(file) {
+			if _, ok := seen[o.SymbolID]; ok || o.KindHint != "def" || o.SymbolID == "" {
+				continue
+			}
+			seen[o.SymbolID] = struct{}{}
+			if d, ok := e.Store.Definition(o.SymbolID); ok {
+				fn(o.SymbolID, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package xref
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// WalkOptions control which files under a directory IndexPaths, IndexFS, IndexCached and Watch
+// index. Files passed by name are always indexed, subject only to MaxFileSize.
+type WalkOptions struct {
+	// NoGitignore turns off .gitignore files, .git/info/exclude and git's global excludes file.
+	// .xrefignore files, in the same syntax, are always honored and take precedence.
+	NoGitignore bool
+	// Include, if set, limits indexing to files matching one of these globs. Globs match
+	// slash-separated paths relative to the walked root: "*", "?" and "[...]" match within a
+	// path segment, a "**" segment matches any number of segments, and a glob without a slash
+	// matches the base name at any depth.
+	Include []string
+	// Exclude skips files and directories matching one of these globs.
+	Exclude []string
+	// MaxFileSize skips files larger than this many bytes; 0 means no limit.
+	MaxFileSize int64
+	// FollowSymlinks indexes symlinked files and descends into symlinked directories of the OS
+	// file system, visiting each real directory once. Symlinks are skipped otherwise.
+	FollowSymlinks bool
+}
+
+// walker lists the files of a tree that the walk options and ignore files let through.
+type walker struct {
+	fsys fs.FS
+	opts WalkOptions
+	isOS bool
+
+	mu    sync.Mutex
+	rules map[string][]ignoreRule // directory -> rules of its ignore files, for ignoredPath
+}
+
+// ignoreFrame holds the rules of one directory's ignore files; rel is the directory relative to
+// the top of the tree (the repository root), "" for the top itself.
+type ignoreFrame struct {
+	rel   string
+	rules []ignoreRule
+}
+
+func (e *Engine) newWalker(fsys fs.FS) *walker {
+	w := &walker{fsys: fsys, opts: e.Walk, rules: map[string][]ignoreRule{}}
+	_, w.isOS = fsys.(osFS)
+	if _, ok := fsys.(*gitFS); ok {
+		w.opts.NoGitignore = true // Files of a commit are tracked, and git never ignores those
+	}
+	return w
+}
+
+func (w *walker) join(dir, name string) string {
+	if w.isOS {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
+
+func joinRel(rel, name string) string {
+	if rel == "" {
+		return name
+	}
+	return rel + "/" + name
+}
+
+// top returns the top of the tree root belongs to: the nearest enclosing git work tree on the OS
+// file system, else the root of fsys. The ignore files of the directories from the top down to
+// root apply inside root. rel is root relative to the top.
+func (w *walker) top(root string) (top, rel string) {
+	if !w.isOS {
+		top = "."
+		if root = path.Clean(root); root != "." {
+			rel = root
+		}
+		return top, rel
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return root, ""
+	}
+	for dir := abs; ; {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			rel, _ = filepath.Rel(dir, abs)
+			if rel == "." {
+				rel = ""
+			}
+			return dir, filepath.ToSlash(rel)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs, ""
+		}
+		dir = parent
+	}
+}
+
+// readRules reads the ignore files of a directory; .xrefignore comes last so its rules win.
+func (w *walker) readRules(dir string, gitignore, xrefignore bool) []ignoreRule {
+	var rules []ignoreRule
+	if gitignore && !w.opts.NoGitignore {
+		if b, err := fs.ReadFile(w.fsys, w.join(dir, ".gitignore")); err == nil {
+			rules = parseIgnore(b)
+		}
+	}
+	if xrefignore {
+		if b, err := fs.ReadFile(w.fsys, w.join(dir, ".xrefignore")); err == nil {
+			rules = append(rules, parseIgnore(b)...)
+		}
+	}
+	return rules
+}
+
+// cachedRules is readRules for both files, remembered per directory.
+func (w *walker) cachedRules(dir string) []ignoreRule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rules, ok := w.rules[dir]
+	if !ok {
+		rules = w.readRules(dir, true, true)
+		w.rules[dir] = rules
+	}
+	return rules
+}
+
+// forget drops the cached rules of a directory after one of its ignore files changed.
+func (w *walker) forget(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.rules, dir)
+}
+
+// frames returns the rules that apply at root: git's global and per-repository excludes, then
+// the ignore files of the directories from the top of the tree down to root's parent.
+func (w *walker) frames(top, rel string) []ignoreFrame {
+	var base []ignoreRule
+	if !w.opts.NoGitignore {
+		if w.isOS {
+			base = append(base, globalIgnoreRules()...)
+		}
+		if b, err := fs.ReadFile(w.fsys, w.join(top, ".git/info/exclude")); err == nil {
+			base = append(base, parseIgnore(b)...)
+		}
+	}
+	frames := []ignoreFrame{{rules: base}}
+	if rel == "" {
+		return frames
+	}
+	dir, drel := top, ""
+	for _, name := range strings.Split(rel, "/") {
+		frames = append(frames, ignoreFrame{rel: drel, rules: w.cachedRules(dir)})
+		dir, drel = w.join(dir, name), joinRel(drel, name)
+	}
+	return frames
+}
+
+// ignored reports whether the ignore rules exclude a path (relative to the top of the tree). As
+// in git, deeper files take precedence over shallower ones and later rules over earlier ones.
+func ignored(frames []ignoreFrame, rel string, isDir bool) bool {
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		sub := rel
+		if f.rel != "" {
+			sub = strings.TrimPrefix(rel, f.rel+"/")
+		}
+		for j := len(f.rules) - 1; j >= 0; j-- {
+			if f.rules[j].match(sub, isDir) {
+				return !f.rules[j].negate
+			}
+		}
+	}
+	return false
+}
+
+// skipped reports whether an entry is left out, by the ignore rules, the fixed directory list or
+// the user's globs; relTop is relative to the top of the tree and relRoot to the walked root.
+func (w *walker) skipped(frames []ignoreFrame, p, relTop, relRoot string, isDir bool) bool {
+	if isDir && skipDir(p) {
+		return true
+	}
+	if ignored(frames, relTop, isDir) || matchAnyGlob(w.opts.Exclude, relRoot) {
+		return true
+	}
+	return !isDir && len(w.opts.Include) > 0 && !matchAnyGlob(w.opts.Include, relRoot)
+}
+
+// walk calls fn for each file under the directory root that is not skipped, in lexical order,
+// until fn returns false. It returns false if fn stopped it.
+func (w *walker) walk(root string, fn func(path string) bool) bool {
+	return w.walkUnder(root, root, fn)
+}
+
+// walkUnder is walk restricted to dir, a directory under root: globs still match relative to root.
+func (w *walker) walkUnder(root, dir string, fn func(path string) bool) bool {
+	relRoot := ""
+	if dir != root {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return true
+		}
+		relRoot = filepath.ToSlash(rel)
+	}
+	top, relTop := w.top(dir)
+	var seen map[string]struct{} // Real directories visited, when following symlinks
+	real := dir
+	if w.isOS && w.opts.FollowSymlinks {
+		seen = map[string]struct{}{}
+		if r, err := filepath.EvalSymlinks(dir); err == nil {
+			real = r
+		}
+		seen[real] = struct{}{}
+	}
+	return w.walkDir(dir, real, relTop, relRoot, w.frames(top, relTop), seen, fn)
+}
+
+func (w *walker) walkDir(dir, real, relTop, relRoot string, frames []ignoreFrame, seen map[string]struct{}, fn func(string) bool) bool {
+	entries, err := fs.ReadDir(w.fsys, dir)
+	if err != nil {
+		return true
+	}
+	hasGitignore := slices.ContainsFunc(entries, func(d fs.DirEntry) bool { return d.Name() == ".gitignore" })
+	hasXrefignore := slices.ContainsFunc(entries, func(d fs.DirEntry) bool { return d.Name() == ".xrefignore" })
+	if rules := w.readRules(dir, hasGitignore, hasXrefignore); len(rules) > 0 {
+		frames = append(frames[:len(frames):len(frames)], ignoreFrame{rel: relTop, rules: rules})
+	}
+
+	for _, d := range entries {
+		p := w.join(dir, d.Name())
+		childReal := w.join(real, d.Name())
+		isDir := d.IsDir()
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !w.isOS || !w.opts.FollowSymlinks {
+				continue
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				continue // Dangling link
+			}
+			isDir = info.IsDir()
+			if isDir {
+				if childReal, err = filepath.EvalSymlinks(p); err != nil {
+					continue
+				}
+			}
+		}
+		childTop, childRoot := joinRel(relTop, d.Name()), joinRel(relRoot, d.Name())
+		if w.skipped(frames, p, childTop, childRoot, isDir) {
+			continue
+		}
+		if !isDir {
+			if !fn(p) {
+				return false
+			}
+			continue
+		}
+		if seen != nil {
+			if _, ok := seen[childReal]; ok {
+				continue // Symlink loop, or a directory already reached through another link
+			}
+			seen[childReal] = struct{}{}
+		}
+		if !w.walkDir(p, childReal, childTop, childRoot, frames, seen, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoredPath reports whether a file or directory under root is skipped by the walk, without
+// walking: the ignore rules of each directory on the way down are read once and cached.
+func (w *walker) ignoredPath(root, p string, isDir bool) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	top, relTop := w.top(root)
+	frames := w.frames(top, relTop)
+	dir, relRoot := root, ""
+	names := strings.Split(filepath.ToSlash(rel), "/")
+	for i, name := range names {
+		frames = append(frames, ignoreFrame{rel: relTop, rules: w.cachedRules(dir)})
+		dir, relTop, relRoot = w.join(dir, name), joinRel(relTop, name), joinRel(relRoot, name)
+		if w.skipped(frames, dir, relTop, relRoot, i < len(names)-1 || isDir) {
+			return true
+		}
+	}
+	return false
+}
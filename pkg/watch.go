@@ -0,0 +1,308 @@
+package xref
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// watchDebounce is how long the file system must stay quiet before a burst of changes
+	// (a save, a git checkout) is re-indexed.
+	watchDebounce = 150 * time.Millisecond
+	// watchMaxDelay bounds how long a continuous stream of changes can postpone re-indexing.
+	watchMaxDelay = 2 * time.Second
+	// watchPollInterval is the scan interval of the polling watcher.
+	watchPollInterval = time.Second
+)
+
+// ChangeKind tells whether a watched file was (re-)indexed or removed from the index.
+type ChangeKind int
+
+const (
+	FileUpdated ChangeKind = iota
+	FileRemoved
+)
+
+func (k ChangeKind) String() string {
+	if k == FileRemoved {
+		return "removed"
+	}
+	return "updated"
+}
+
+// ChangeEvent reports a file that Watch re-indexed or removed.
+type ChangeEvent struct {
+	Path string
+	Kind ChangeKind
+	Err  error // set if the file changed but could not be re-indexed or removed from the index
+}
+
+// fileWatcher reports paths under the watched roots that may have changed: files, or
+// directories whose whole subtree should be rescanned.
+type fileWatcher interface {
+	Changes() <-chan string
+	Close() error
+}
+
+// Subscribe registers for the change events emitted by Watch. Events are delivered on a channel
+// with the given buffer; a subscriber that falls behind misses events rather than stalling the
+// index. The returned function cancels the subscription and closes the channel.
+func (e *Engine) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	ch := make(chan ChangeEvent, buffer)
+	e.subMu.Lock()
+	if e.subs == nil {
+		e.subs = map[chan ChangeEvent]struct{}{}
+	}
+	e.subs[ch] = struct{}{}
+	e.subMu.Unlock()
+	return ch, func() {
+		e.subMu.Lock()
+		defer e.subMu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (e *Engine) publish(ev ChangeEvent) {
+	e.subMu.Lock()
+	defer e.subMu.Unlock()
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default: // Subscriber is behind; drop rather than block indexing
+		}
+	}
+}
+
+// Watch keeps the index live while files under roots change, until ctx is cancelled.
+// It uses inotify on Linux and falls back to polling elsewhere or when inotify is unavailable.
+// Bursts of changes are debounced, files and directories skipped by IndexPaths (see WalkOptions)
+// are ignored, and only the affected files are re-indexed (or removed), each reported to
+// subscribers as a ChangeEvent. Editing an ignore file re-applies it to its directory.
+// Roots are expected to have been indexed already; Watch returns ctx.Err() when cancelled.
+func (e *Engine) Watch(ctx context.Context, roots ...string) error {
+	w, err := newNativeWatcher(roots)
+	if err != nil {
+		e.logger().Info("watching by polling", "err", err)
+		w, err = newPollWatcher(roots, watchPollInterval)
+		if err != nil {
+			return err
+		}
+	}
+	defer w.Close()
+	return e.watch(ctx, w, roots, watchDebounce, watchMaxDelay)
+}
+
+// watch applies the changes w reports in batches: a batch is applied once no change has arrived
+// for debounce, or maxDelay after its first change. A path reported several times in a batch is
+// handled once.
+func (e *Engine) watch(ctx context.Context, w fileWatcher, roots []string, debounce, maxDelay time.Duration) error {
+	walk := e.newWalker(osFS{})
+	pending := map[string]struct{}{}
+	var quiet, deadline <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-w.Changes():
+			if !ok {
+				return errors.New("watcher stopped")
+			}
+			if len(pending) == 0 {
+				deadline = time.After(maxDelay)
+			}
+			pending[p] = struct{}{}
+			quiet = time.After(debounce)
+			continue
+		case <-quiet:
+		case <-deadline:
+		}
+		paths := make([]string, 0, len(pending))
+		for p := range pending {
+			paths = append(paths, p)
+		}
+		clear(pending)
+		quiet, deadline = nil, nil
+		e.applyChanges(walk, roots, paths)
+	}
+}
+
+// applyChanges re-indexes or removes the files behind a batch of changed paths and publishes
+// the results. A path that is now a directory is rescanned; a path that vanished takes every
+// indexed file beneath it along, and so does a path the walk options now skip.
+func (e *Engine) applyChanges(walk *walker, roots, paths []string) {
+	sort.Strings(paths)
+	rootOf := func(p string) string {
+		for _, root := range roots {
+			if rel, err := filepath.Rel(root, p); err == nil && !strings.HasPrefix(rel, "..") {
+				return root
+			}
+		}
+		return p
+	}
+	skipped := func(p string, isDir bool) bool { return walk.ignoredPath(rootOf(p), p, isDir) }
+	var events []ChangeEvent
+	updated := map[string]struct{}{} // A file can arrive on its own and inside a rescanned directory
+	update := func(path string) {
+		if _, ok := updated[path]; !ok && e.handles(path) {
+			updated[path] = struct{}{}
+			events = append(events, ChangeEvent{Path: path, Kind: FileUpdated, Err: e.updateFile(path)})
+		}
+	}
+	var indexed []string // Files indexed before the batch, listed once when first needed
+	listed := false
+	indexedUnder := func(path string) []string {
+		if !listed {
+			indexed, listed = e.store().Files(), true
+		}
+		return filesUnder(indexed, path)
+	}
+	removed := map[string]struct{}{} // A file can go on its own and with its directory
+	remove := func(path string) {
+		e.forget(path)
+		for _, f := range indexedUnder(path) {
+			if _, ok := removed[f]; !ok {
+				removed[f] = struct{}{}
+				events = append(events, ChangeEvent{Path: f, Kind: FileRemoved, Err: e.store().DeleteFile(f)})
+			}
+		}
+	}
+	for _, p := range paths {
+		if name := filepath.Base(p); name == ".gitignore" || name == ".xrefignore" {
+			// The rules changed: drop files they now skip and pick up those they let through
+			dir := filepath.Dir(p)
+			walk.forget(dir)
+			for _, f := range indexedUnder(dir) {
+				if skipped(f, false) {
+					remove(f)
+				}
+			}
+			p = dir
+		}
+		info, err := os.Stat(p)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			remove(p)
+		case err != nil:
+			events = append(events, ChangeEvent{Path: p, Kind: FileUpdated, Err: err})
+		case skipped(p, info.IsDir()):
+			remove(p)
+		case info.IsDir():
+			walk.walkUnder(rootOf(p), p, func(path string) bool {
+				update(path)
+				return true
+			})
+		default:
+			update(p)
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+	e.Index.linkGenerated()
+	for _, ev := range events {
+		if ev.Err != nil {
+			e.logger().Warn("re-indexing changed file failed", "file", ev.Path, "err", ev.Err)
+		}
+		e.publish(ev)
+	}
+}
+
+// filesUnder returns the files at or beneath path of a sorted list of files.
+func filesUnder(files []string, path string) []string {
+	var out []string
+	if i := sort.SearchStrings(files, path); i < len(files) && files[i] == path {
+		out = append(out, path)
+	}
+	prefix := path + string(filepath.Separator)
+	for i := sort.SearchStrings(files, prefix); i < len(files) && strings.HasPrefix(files[i], prefix); i++ {
+		out = append(out, files[i])
+	}
+	return out
+}
+
+// pollWatcher detects changes by periodically rescanning the roots and comparing file sizes and
+// modification times. It works on every platform and file system, at the cost of latency.
+type pollWatcher struct {
+	roots   []string
+	changes chan string
+	stop    chan struct{}
+}
+
+type pollStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func newPollWatcher(roots []string, interval time.Duration) (fileWatcher, error) {
+	w := &pollWatcher{roots: roots, changes: make(chan string, 256), stop: make(chan struct{})}
+	prev := w.scan()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-t.C:
+			}
+			cur := w.scan()
+			for p, st := range cur {
+				if old, ok := prev[p]; !ok || old != st {
+					w.send(p)
+				}
+			}
+			for p := range prev {
+				if _, ok := cur[p]; !ok {
+					w.send(p)
+				}
+			}
+			prev = cur
+		}
+	}()
+	return w, nil
+}
+
+func (w *pollWatcher) scan() map[string]pollStamp {
+	out := map[string]pollStamp{}
+	for _, root := range w.roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if skipDir(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				out[path] = pollStamp{size: info.Size(), modTime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return out
+}
+
+func (w *pollWatcher) send(p string) {
+	select {
+	case w.changes <- p:
+	case <-w.stop:
+	}
+}
+
+func (w *pollWatcher) Changes() <-chan string { return w.changes }
+
+func (w *pollWatcher) Close() error {
+	close(w.stop)
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package xref
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyWatcher watches every directory under the roots with inotify, adding watches for
+// directories as they are created.
+type inotifyWatcher struct {
+	f       *os.File // non-blocking inotify fd, so Close interrupts a pending Read
+	fd      int
+	roots   []string
+	mu      sync.Mutex
+	dirs    map[int]string // watch descriptor -> directory
+	changes chan string
+	stop    chan struct{}
+}
+
+func newNativeWatcher(roots []string) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		roots:   roots,
+		dirs:    map[int]string{},
+		changes: make(chan string, 256),
+		stop:    make(chan struct{}),
+	}
+	for _, root := range roots {
+		if err := w.addTree(root); err != nil {
+			w.f.Close()
+			return nil, err // e.g. out of watches (fs.inotify.max_user_watches): poll instead
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+// addTree watches dir and every directory beneath it that indexing doesn't skip.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && skipDir(path) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.dirs[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.changes)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return // Closed
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				for _, root := range w.roots {
+					w.send(root) // Events were lost: rescan everything
+				}
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[int(ev.Wd)]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, int(ev.Wd))
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			path := dir
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			if len(name) > 0 {
+				path = filepath.Join(dir, string(name))
+			}
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if skipDir(path) {
+					continue
+				}
+				w.addTree(path) // New directory: watch it and rescan what landed in it
+			}
+			if ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 && len(name) == 0 {
+				continue // The parent directory reports the removal
+			}
+			w.send(path)
+		}
+	}
+}
+
+func (w *inotifyWatcher) send(p string) {
+	select {
+	case w.changes <- p:
+	case <-w.stop:
+	}
+}
+
+func (w *inotifyWatcher) Changes() <-chan string { return w.changes }
+
+func (w *inotifyWatcher) Close() error {
+	close(w.stop)
+	return w.f.Close()
+}
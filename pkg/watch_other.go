@@ -0,0 +1,10 @@
+//go:build !linux
+
+package xref
+
+import "errors"
+
+// newNativeWatcher has no implementation on this platform; Watch falls back to polling.
+func newNativeWatcher(_ []string) (fileWatcher, error) {
+	return nil, errors.New("native file watching not supported on this platform")
+}
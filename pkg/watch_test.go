@@ -0,0 +1,185 @@
+package xref
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// chanWatcher is a fileWatcher the test reports changes through.
+type chanWatcher chan string
+
+func (w chanWatcher) Changes() <-chan string { return w }
+func (w chanWatcher) Close() error           { return nil }
+
+// watchEvents runs the watch loop over dir with the given timings, reports paths through a
+// chanWatcher gap apart, and returns the events published until the loop has been idle for a
+// while, as "kind path" with paths relative to dir.
+func watchEvents(t *testing.T, e *Engine, dir string, paths []string, gap, debounce, maxDelay time.Duration) []string {
+	t.Helper()
+	events, cancel := e.Subscribe(1024)
+	defer cancel()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	w := make(chanWatcher)
+	go e.watch(ctx, w, []string{dir}, debounce, maxDelay)
+	for _, p := range paths {
+		w <- p
+		time.Sleep(gap)
+	}
+	var got []string
+	for {
+		select {
+		case ev := <-events:
+			if ev.Err != nil {
+				t.Errorf("%s: %v", ev.Path, ev.Err)
+			}
+			rel, _ := filepath.Rel(dir, ev.Path)
+			got = append(got, ev.Kind.String()+" "+filepath.ToSlash(rel))
+		case <-time.After(debounce + 300*time.Millisecond):
+			return got
+		}
+	}
+}
+
+// TestWatchCoalesces reports bursts of changes to the watch loop and checks that each affected
+// file is re-indexed or removed once.
+func TestWatchCoalesces(t *testing.T) {
+	files := map[string]string{
+		"a.go":     "package p\n\nfunc A() {}\n",
+		"sub/b.go": "package sub\n\nfunc B() {}\n",
+		"sub/c.go": "package sub\n\nfunc C() {}\n",
+		"gen/g.go": "package gen\n\nfunc G() {}\n",
+	}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string) []string // edits the tree, returns the paths to report
+		want   []string
+	}{
+		{
+			name: "repeated writes",
+			change: func(t *testing.T, dir string) []string {
+				var paths []string
+				for range 20 {
+					writeTree(t, dir, map[string]string{"a.go": "package p\n\nfunc A2() {}\n"})
+					paths = append(paths, filepath.Join(dir, "a.go"))
+				}
+				return paths
+			},
+			want: []string{"updated a.go"},
+		},
+		{
+			name: "file and its directory",
+			change: func(t *testing.T, dir string) []string {
+				writeTree(t, dir, map[string]string{"sub/b.go": "package sub\n\nfunc B2() {}\n"})
+				return []string{filepath.Join(dir, "sub/b.go"), filepath.Join(dir, "sub"), filepath.Join(dir, "sub/b.go")}
+			},
+			want: []string{"updated sub/b.go", "updated sub/c.go"},
+		},
+		{
+			name: "written then removed",
+			change: func(t *testing.T, dir string) []string {
+				p := filepath.Join(dir, "sub/c.go")
+				writeTree(t, dir, map[string]string{"sub/c.go": "package sub\n"})
+				if err := os.Remove(p); err != nil {
+					t.Fatal(err)
+				}
+				return []string{p, p}
+			},
+			want: []string{"removed sub/c.go"},
+		},
+		{
+			name: "removed directory",
+			change: func(t *testing.T, dir string) []string {
+				if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+					t.Fatal(err)
+				}
+				return []string{filepath.Join(dir, "sub/b.go"), filepath.Join(dir, "sub"), filepath.Join(dir, "sub/c.go")}
+			},
+			want: []string{"removed sub/b.go", "removed sub/c.go"},
+		},
+		{
+			name: "ignore file",
+			change: func(t *testing.T, dir string) []string {
+				writeTree(t, dir, map[string]string{".gitignore": "gen/\n", "gen/g.go": "package gen\n\nfunc G2() {}\n"})
+				return []string{filepath.Join(dir, "gen/g.go"), filepath.Join(dir, ".gitignore"), filepath.Join(dir, "gen")}
+			},
+			want: []string{"removed gen/g.go", "updated a.go", "updated sub/b.go", "updated sub/c.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, files)
+			e, err := New(WithLanguages("go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.IndexPaths(dir); err != nil {
+				t.Fatal(err)
+			}
+			got := watchEvents(t, e, dir, tt.change(t, dir), 0, 20*time.Millisecond, time.Minute)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatchMaxDelay checks that a continuous stream of changes is applied every so often rather
+// than postponed until it ends.
+func TestWatchMaxDelay(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.go": "package p\n"})
+	e, err := New(WithLanguages("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IndexPaths(dir); err != nil {
+		t.Fatal(err)
+	}
+	paths := slices.Repeat([]string{filepath.Join(dir, "a.go")}, 40)
+	tests := []struct {
+		name     string
+		maxDelay time.Duration
+		min, max int // re-indexings of a.go
+	}{
+		{"bounded", 100 * time.Millisecond, 2, len(paths)},
+		{"unbounded", time.Minute, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := watchEvents(t, e, dir, paths, 10*time.Millisecond, 200*time.Millisecond, tt.maxDelay)
+			if n := len(got); n < tt.min || n > tt.max {
+				t.Errorf("%d events over a %v stream, want %d to %d: %q", n, 10*time.Millisecond*time.Duration(len(paths)), tt.min, tt.max, got)
+			}
+		})
+	}
+}
+
+// TestFilesUnder checks the files found at or beneath a path, among siblings that sort between
+// the path and its children.
+func TestFilesUnder(t *testing.T) {
+	sep := string(filepath.Separator)
+	files := []string{"a.go", "sub", "sub" + sep + "b.go", "sub" + sep + "d" + sep + "c.go", "sub-x.go", "sub.go", "subway" + sep + "e.go"}
+	slices.Sort(files)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"sub", []string{"sub", "sub" + sep + "b.go", "sub" + sep + "d" + sep + "c.go"}},
+		{"sub" + sep + "d", []string{"sub" + sep + "d" + sep + "c.go"}},
+		{"sub.go", []string{"sub.go"}},
+		{"su", nil},
+		{"zz", nil},
+	}
+	for _, tt := range tests {
+		if got := filesUnder(files, tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("filesUnder(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package xref
 
-import sitter "github.com/smacker/go-tree-sitter"
+import (
+	"go/build"
+	"io/fs"
+	"log/slog"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
 
 // Pos/Range/DefLocation/RefLocation are stable types you can print or JSON.
 type (
@@ -14,6 +21,7 @@ type DefLocation struct {
 	Rng  Range
 	Name string
 	Kind string // e.g., func, class, var, type, ...
+	Decl bool   // declaration only (e.g. a C prototype); the definition lives elsewhere
 }
 
 type RefLocation struct {
@@ -28,11 +36,36 @@ type LanguageAdapter interface {
 	CanHandle(path string) bool
 	Parse(path string, src []byte) (*sitter.Tree, error) // thin alias over ts parser
 	Extract(path string, src []byte, tree *sitter.Tree) (*FileIndex, error)
-	ResolveAt(path string, src []byte, occ Occurrence, pi *ProjectIndex) []string
+	ResolveAt(path string, src []byte, occ Occurrence, idx Store) []string
+}
+
+// IncrementalAdapter is implemented by tree-sitter adapters that expose their grammar, so the
+// engine can reparse open documents incrementally from the previous tree (see OpenDocument).
+type IncrementalAdapter interface {
+	LanguageAdapter
+	Language(path string) *sitter.Language
 }
 
 // Engine holds the cross-file index and exposes queries.
 type Engine struct {
-	Index    *ProjectIndex
-	Adapters []LanguageAdapter
+	Index     *ProjectIndex
+	Store     Store // where files are indexed and queries read, if not Index (e.g. a KVStore on disk)
+	Adapters  []LanguageAdapter
+	Injectors []Injector     // embedded-language regions (e.g. <script> in .vue), routed to Adapters
+	Blobs     *BlobCache     // extraction results of git blobs, shared across IndexRevision calls
+	Extracts  *ExtractCache  // optional on-disk cache of extraction results by content hash
+	Walk      WalkOptions    // ignore files, globs and limits applied when indexing directories
+	Workers   int            // files parsed in parallel when IndexOptions doesn't say; 0 means GOMAXPROCS
+	Build     *build.Context // if set, Go files its build constraints exclude are skipped
+	Logger    *slog.Logger   // indexing failures, query reloads and watch errors; nil logs nothing
+
+	adaptMu sync.RWMutex // guards Adapters, Injectors and queries against ReloadQueries
+	queries *querySource // overrides of the embedded queries, if any
+
+	subMu sync.Mutex
+	subs  map[chan ChangeEvent]struct{} // Watch subscribers
+
+	docMu   sync.Mutex
+	docs    map[string]*document // open editor buffers that override disk contents
+	sources map[string]fs.FS     // files indexed through IndexFS -> their file system
 }